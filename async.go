@@ -0,0 +1,67 @@
+package omnicache
+
+import (
+	"sync"
+	"time"
+)
+
+// pendingKeys dedupes background work per key
+type pendingKeys struct {
+	mu   sync.Mutex
+	keys map[string]struct{}
+}
+
+// start marks key as pending, reporting false if it already was
+func (p *pendingKeys) start(key string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.keys[key]; ok {
+		return false
+	}
+	if p.keys == nil {
+		p.keys = map[string]struct{}{}
+	}
+	p.keys[key] = struct{}{}
+	return true
+}
+
+func (p *pendingKeys) done(key string) {
+	p.mu.Lock()
+	delete(p.keys, key)
+	p.mu.Unlock()
+}
+
+// FetchAsync gets data from the cache for the specified key without blocking on a backfill
+// On a hit the value is returned with ready set. On a miss it returns immediately with ready unset
+// while a single background BackfillCache.CacheMiss per key populates the cache with ttl for later calls.
+// Errors from the background backfill are discarded, while read errors other than a miss are returned
+func (oc *OmniCache) FetchAsync(k []byte, b BackfillCache, ttl time.Duration) ([]byte, bool, error) {
+	ttl, err := oc.checkTTL(ttl)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := oc.checkKey(k); err != nil {
+		return nil, false, err
+	}
+
+	ret, err := oc.read(k)
+	if err == nil {
+		return ret, true, nil
+	}
+	if err != ErrNotFound {
+		return nil, false, err
+	}
+
+	key := oc.sharedKey(k)
+	if oc.async.start(key) {
+		// the caller may reuse k once FetchAsync returns
+		k = copyBytes(k)
+		go func() {
+			defer oc.async.done(key)
+			if ret, err := oc.backfill(k, b); err == nil {
+				oc.writeTTL(k, ret, ttl)
+			}
+		}()
+	}
+	return nil, false, nil
+}
@@ -0,0 +1,62 @@
+package omnicache
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFetchAsync(t *testing.T) {
+	oc := New(createConn())
+	defer oc.Close()
+
+	var calls int32
+	b := slowBackfill{calls: &calls}
+	key := []byte("dashboard")
+
+	// cold miss returns immediately, not ready
+	start := time.Now()
+	v, ready, err := oc.FetchAsync(key, b, time.Minute)
+	assert.Nil(t, err)
+	assert.False(t, ready)
+	assert.Nil(t, v)
+	assert.True(t, time.Since(start) < 20*time.Millisecond)
+
+	// a second miss while the backfill runs doesn't start another
+	_, ready, err = oc.FetchAsync(key, b, time.Minute)
+	assert.Nil(t, err)
+	assert.False(t, ready)
+
+	time.Sleep(50 * time.Millisecond)
+	v, ready, err = oc.FetchAsync(key, b, time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, ready)
+	assert.Equal(t, key, v)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestFetchAsyncErrors(t *testing.T) {
+	oc := New(createConn())
+
+	var calls int32
+	b := slowBackfill{calls: &calls}
+	_, _, err := oc.FetchAsync(nil, b, time.Minute)
+	assert.Equal(t, ErrEmptyKey, err)
+
+	// the background backfill doesn't see the caller reusing k
+	key := []byte("report")
+	oc.FetchAsync(key, b, time.Minute)
+	copy(key, "XXXXXX")
+	time.Sleep(50 * time.Millisecond)
+	v, ready, err := oc.FetchAsync([]byte("report"), b, time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, ready)
+	assert.Equal(t, []byte("report"), v)
+
+	oc.Close()
+	_, _, err = oc.FetchAsync([]byte("other"), b, time.Minute)
+	assert.Equal(t, ErrClosed, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
@@ -0,0 +1,231 @@
+package omnicache
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// ErrNotInteger is returned by Increment and Decrement when the key holds a value that isn't a decimal integer
+var ErrNotInteger = errors.New("omnicache: value is not an integer")
+
+// CompareAndDelete deletes k only if it currently holds expected, reporting whether it was deleted,
+// e.g. to release a lock only while it still holds the caller's token. A missing key returns false
+// without error. The check and delete are atomic with respect to other OmniCache writes, and with
+// respect to every write when the Conn implements CompareDeleter. Otherwise the Conn must implement Deleter
+func (oc *OmniCache) CompareAndDelete(k, expected []byte) (bool, error) {
+	cd, cas := oc.Conn.(CompareDeleter)
+	d, ok := oc.Conn.(Deleter)
+	if !cas && !ok {
+		return false, ErrNotSupported
+	}
+	if err := oc.checkKey(k); err != nil {
+		return false, err
+	}
+
+	defer oc.observeWrite()
+	defer oc.locks.lock(k).Unlock()
+	if cas {
+		deleted, err := cd.CompareAndDelete(k, expected)
+		if deleted {
+			oc.written(k)
+			oc.evict.notify([]byte(oc.sharedKey(k)), expected, EvictDeleted)
+		}
+		return deleted, err
+	}
+
+	v, err := oc.Conn.Read(k)
+	if err != nil || !bytes.Equal(v, expected) {
+		return false, nil
+	}
+	oc.written(k)
+	if err := d.Delete(k); err != nil {
+		return false, err
+	}
+	oc.evict.notify([]byte(oc.sharedKey(k)), v, EvictDeleted)
+	return true, nil
+}
+
+// DeleteIf is the same as CompareAndDelete
+//
+// Deprecated: use CompareAndDelete
+func (oc *OmniCache) DeleteIf(k, expected []byte) (bool, error) {
+	return oc.CompareAndDelete(k, expected)
+}
+
+// GetSet replaces the value of k with v, stored with ttl, returning the previous value and whether
+// there was one. It's atomic with respect to other OmniCache writes, and with respect to every write
+// when the Conn implements ReadWriter
+func (oc *OmniCache) GetSet(k, v []byte, ttl time.Duration) (old []byte, existed bool, err error) {
+	ttl, err = oc.checkTTL(ttl)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := oc.checkWrite(k, v); err != nil {
+		return nil, false, err
+	}
+	v = copyBytes(v)
+
+	defer oc.observeWrite()
+	defer oc.locks.lock(k).Unlock()
+	oc.written(k)
+	if rw, ok := oc.Conn.(ReadWriter); ok {
+		old, existed, err := rw.ReadWrite(k, v, ttl)
+		return copyBytes(old), existed, err
+	}
+
+	old, rerr := oc.Conn.Read(k)
+	if err := oc.Conn.WriteTTL(k, v, ttl); err != nil {
+		return nil, false, err
+	}
+	if rerr != nil {
+		return nil, false, nil
+	}
+	return copyBytes(old), true, nil
+}
+
+// Increment adds delta to the decimal integer stored at k, returning the new value
+// A missing key is initialized to delta with ttl, while an existing key keeps its remaining TTL when the
+// Conn can report it (see TTL) and is rewritten with ttl otherwise. It's atomic with respect to other
+// OmniCache writes
+func (oc *OmniCache) Increment(k []byte, delta int64, ttl time.Duration) (int64, error) {
+	ttl, err := oc.checkTTL(ttl)
+	if err != nil {
+		return 0, err
+	}
+	if err := oc.checkKey(k); err != nil {
+		return 0, err
+	}
+
+	defer oc.observeWrite()
+	defer oc.locks.lock(k).Unlock()
+	n := delta
+	if v, err := oc.Conn.Read(k); err == nil {
+		cur, err := strconv.ParseInt(string(v), 10, 64)
+		if err != nil {
+			return 0, ErrNotInteger
+		}
+		n += cur
+		if left, err := oc.TTL(k); err == nil {
+			ttl = left
+			if left == NoExpiry {
+				ttl = 0
+			}
+		}
+	}
+
+	v := []byte(strconv.FormatInt(n, 10))
+	if err := oc.checkWrite(k, v); err != nil {
+		return 0, err
+	}
+	oc.written(k)
+	if err := oc.Conn.WriteTTL(k, v, ttl); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// Decrement subtracts delta from the decimal integer stored at k, as Increment does
+func (oc *OmniCache) Decrement(k []byte, delta int64, ttl time.Duration) (int64, error) {
+	return oc.Increment(k, -delta, ttl)
+}
+
+// Update replaces the value of k with the result of fn, stored with ttl, e.g. to read-modify-write a
+// JSON map without concurrent callers clobbering each other. fn gets a copy of the current value, with
+// found false if k is missing or expired, and nothing is written when it returns an error. It's atomic
+// with respect to other OmniCache writes, and with respect to every write when the Conn implements
+// Updater. fn must not call back into the cache, which would deadlock
+func (oc *OmniCache) Update(k []byte, fn func(current []byte, found bool) ([]byte, error), ttl time.Duration) error {
+	ttl, err := oc.checkTTL(ttl)
+	if err != nil {
+		return err
+	}
+	if err := oc.checkKey(k); err != nil {
+		return err
+	}
+
+	defer oc.observeWrite()
+	defer oc.locks.lock(k).Unlock()
+	update := func(current []byte, found bool) ([]byte, error) {
+		v, err := fn(copyBytes(current), found)
+		if err != nil {
+			return nil, err
+		}
+		if err := oc.checkWrite(k, v); err != nil {
+			return nil, err
+		}
+		oc.written(k)
+		return copyBytes(v), nil
+	}
+	if u, ok := oc.Conn.(Updater); ok {
+		return u.Update(k, update, ttl)
+	}
+
+	current, rerr := oc.Conn.Read(k)
+	v, err := update(current, rerr == nil)
+	if err != nil {
+		return err
+	}
+	return oc.Conn.WriteTTL(k, v, ttl)
+}
+
+// SetNX stores v with ttl only if k is missing or expired, reporting whether it was stored
+// It's atomic with respect to other OmniCache writes, and with respect to every write when
+// the Conn implements AbsentWriter
+func (oc *OmniCache) SetNX(k, v []byte, ttl time.Duration) (bool, error) {
+	ttl, err := oc.checkTTL(ttl)
+	if err != nil {
+		return false, err
+	}
+	if err := oc.checkWrite(k, v); err != nil {
+		return false, err
+	}
+	v = copyBytes(v)
+
+	defer oc.observeWrite()
+	defer oc.locks.lock(k).Unlock()
+	if aw, ok := oc.Conn.(AbsentWriter); ok {
+		stored, err := aw.WriteIfAbsent(k, v, ttl)
+		if stored {
+			oc.written(k)
+		}
+		return stored, err
+	}
+
+	if _, err := oc.Conn.Read(k); err == nil {
+		return false, nil
+	}
+	oc.written(k)
+	if err := oc.Conn.WriteTTL(k, v, ttl); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// GetOrSet returns the live value of k, or stores v with ttl and returns it if k is missing or expired,
+// reporting whether v was stored. It's atomic with respect to other OmniCache writes, so concurrent
+// callers all get the same value
+func (oc *OmniCache) GetOrSet(k, v []byte, ttl time.Duration) ([]byte, bool, error) {
+	ttl, err := oc.checkTTL(ttl)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := oc.checkKey(k); err != nil {
+		return nil, false, err
+	}
+
+	defer oc.observeWrite()
+	defer oc.locks.lock(k).Unlock()
+	if old, err := oc.read(k); err == nil {
+		return old, false, nil
+	}
+	if err := oc.checkWrite(k, v); err != nil {
+		return nil, false, err
+	}
+	oc.written(k)
+	if err := oc.Conn.WriteTTL(k, copyBytes(v), ttl); err != nil {
+		return nil, false, err
+	}
+	return v, true, nil
+}
@@ -0,0 +1,342 @@
+package omnicache
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeleteIf(t *testing.T) {
+	oc := New(newMapConn())
+	defer oc.Close()
+
+	key := []byte("lock")
+
+	// missing key
+	deleted, err := oc.DeleteIf(key, []byte("token"))
+	assert.Nil(t, err)
+	assert.False(t, deleted)
+
+	// mismatched value
+	oc.Set(key, []byte("other"))
+	deleted, err = oc.DeleteIf(key, []byte("token"))
+	assert.Nil(t, err)
+	assert.False(t, deleted)
+	_, err = oc.Get(key)
+	assert.Nil(t, err)
+
+	// matching value
+	oc.Set(key, []byte("token"))
+	deleted, err = oc.DeleteIf(key, []byte("token"))
+	assert.Nil(t, err)
+	assert.True(t, deleted)
+	_, err = oc.Get(key)
+	assert.Errorf(t, err, "Key not found")
+}
+
+func TestDeleteIfConcurrentUpdate(t *testing.T) {
+	oc := New(newMapConn())
+	defer oc.Close()
+
+	key := []byte("lock")
+	for i := 0; i < 500; i++ {
+		oc.Set(key, []byte("old"))
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			oc.DeleteIf(key, []byte("old"))
+		}()
+		go func() {
+			defer wg.Done()
+			oc.Set(key, []byte("new"))
+		}()
+		wg.Wait()
+
+		// the stale DeleteIf never removes the new value
+		b, err := oc.Get(key)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("new"), b)
+	}
+}
+
+func TestDeleteIfNotSupported(t *testing.T) {
+	oc := New(createConn())
+	defer oc.Close()
+
+	_, err := oc.DeleteIf([]byte("k"), nil)
+	assert.Equal(t, ErrNotSupported, err)
+}
+
+// casConn is a mapConn that compares and deletes in one step, counting calls
+type casConn struct {
+	*mapConn
+	calls int
+}
+
+func (c *casConn) CompareAndDelete(k, expected []byte) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls++
+	e, ok := c.dat[string(k)]
+	if !ok || c.expired(e) || !bytes.Equal(e.dat, expected) {
+		return false, nil
+	}
+	delete(c.dat, string(k))
+	return true, nil
+}
+
+func TestCompareAndDelete(t *testing.T) {
+	c := &casConn{mapConn: newMapConn()}
+	oc := New(c)
+	defer oc.Close()
+
+	key := []byte("lock")
+
+	// missing key and mismatched value
+	deleted, err := oc.CompareAndDelete(key, []byte("token"))
+	assert.Nil(t, err)
+	assert.False(t, deleted)
+	oc.Set(key, []byte("other"))
+	deleted, err = oc.CompareAndDelete(key, []byte("token"))
+	assert.Nil(t, err)
+	assert.False(t, deleted)
+
+	// matching value, compared and deleted by the Conn
+	oc.Set(key, []byte("token"))
+	deleted, err = oc.CompareAndDelete(key, []byte("token"))
+	assert.Nil(t, err)
+	assert.True(t, deleted)
+	assert.Equal(t, 3, c.calls)
+	_, err = oc.Get(key)
+	assert.Errorf(t, err, "Key not found")
+}
+
+func TestGetSet(t *testing.T) {
+	oc := New(newMapConn())
+	defer oc.Close()
+
+	// missing key
+	old, existed, err := oc.GetSet([]byte("buf"), []byte("0"), 0)
+	assert.Nil(t, err)
+	assert.False(t, existed)
+	assert.Nil(t, old)
+
+	// concurrent rotations each get back a distinct previous value
+	var mu sync.Mutex
+	seen := map[string]int{}
+	var wg sync.WaitGroup
+	for i := 1; i <= 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			old, existed, err := oc.GetSet([]byte("buf"), []byte(fmt.Sprint(i)), 0)
+			assert.Nil(t, err)
+			assert.True(t, existed)
+			mu.Lock()
+			seen[string(old)]++
+			mu.Unlock()
+		}(i)
+	}
+	wg.Wait()
+
+	last, err := oc.Get([]byte("buf"))
+	assert.Nil(t, err)
+	seen[string(last)]++
+	assert.Len(t, seen, 101)
+	for v, n := range seen {
+		assert.Equal(t, 1, n, v)
+	}
+}
+
+func TestIncrement(t *testing.T) {
+	oc := New(newMapConn())
+	defer oc.Close()
+
+	key := []byte("requests")
+
+	// missing key starts at delta
+	n, err := oc.Increment(key, 2, time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), n)
+
+	n, err = oc.Increment(key, 3, time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), n)
+	n, err = oc.Decrement(key, 7, time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-2), n)
+	b, _ := oc.Get(key)
+	assert.Equal(t, []byte("-2"), b)
+
+	oc.Set([]byte("name"), []byte("bob"))
+	_, err = oc.Increment([]byte("name"), 1, time.Minute)
+	assert.Equal(t, ErrNotInteger, err)
+}
+
+func TestIncrementKeepsTTL(t *testing.T) {
+	oc := New(newMapConn())
+	defer oc.Close()
+
+	key := []byte("window")
+	oc.Increment(key, 1, 50*time.Millisecond)
+	oc.Increment(key, 1, time.Hour)
+
+	ttl, err := oc.TTL(key)
+	assert.Nil(t, err)
+	assert.True(t, ttl <= 50*time.Millisecond)
+}
+
+func TestIncrementConcurrent(t *testing.T) {
+	oc := New(newMapConn())
+	defer oc.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			oc.Increment([]byte("hits"), 1, time.Minute)
+		}()
+	}
+	wg.Wait()
+
+	n, err := oc.Increment([]byte("hits"), 0, time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(50), n)
+}
+
+func TestSetNX(t *testing.T) {
+	m, clock := newFakeMapConn()
+	oc := New(m)
+	defer oc.Close()
+
+	key := []byte("job")
+	stored, err := oc.SetNX(key, []byte("a"), time.Millisecond)
+	assert.Nil(t, err)
+	assert.True(t, stored)
+
+	// live key is kept
+	stored, err = oc.SetNX(key, []byte("b"), time.Minute)
+	assert.Nil(t, err)
+	assert.False(t, stored)
+	b, _ := oc.Get(key)
+	assert.Equal(t, []byte("a"), b)
+
+	// expired key is overwritten
+	clock.Advance(5 * time.Millisecond)
+	stored, err = oc.SetNX(key, []byte("c"), time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, stored)
+	b, _ = oc.Get(key)
+	assert.Equal(t, []byte("c"), b)
+}
+
+func TestSetNXConcurrent(t *testing.T) {
+	oc := New(newMapConn())
+	defer oc.Close()
+
+	var wins int32
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if stored, _ := oc.SetNX([]byte("job"), []byte(fmt.Sprint(i)), time.Minute); stored {
+				atomic.AddInt32(&wins, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), wins)
+}
+
+func TestGetOrSet(t *testing.T) {
+	m, clock := newFakeMapConn()
+	oc := New(m)
+	defer oc.Close()
+
+	key := []byte("config")
+
+	// cache miss stores v
+	b, stored, err := oc.GetOrSet(key, []byte("a"), time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, stored)
+	assert.Equal(t, []byte("a"), b)
+
+	// cache hit returns the live value
+	b, stored, err = oc.GetOrSet(key, []byte("b"), time.Minute)
+	assert.Nil(t, err)
+	assert.False(t, stored)
+	assert.Equal(t, []byte("a"), b)
+
+	clock.Advance(2 * time.Minute)
+	b, stored, _ = oc.GetOrSet(key, []byte("c"), time.Minute)
+	assert.True(t, stored)
+	assert.Equal(t, []byte("c"), b)
+}
+
+func TestGetOrSetConcurrent(t *testing.T) {
+	oc := New(newMapConn())
+	defer oc.Close()
+
+	var wg sync.WaitGroup
+	got := make([][]byte, 50)
+	for i := range got {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			got[i], _, _ = oc.GetOrSet([]byte("config"), []byte(fmt.Sprint(i)), time.Minute)
+		}(i)
+	}
+	wg.Wait()
+
+	// every caller sees the single stored value
+	for _, b := range got {
+		assert.Equal(t, got[0], b)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	oc := New(newMapConn())
+	defer oc.Close()
+
+	key := []byte("counts")
+	appendOne := func(current []byte, found bool) ([]byte, error) {
+		return append(current, 1), nil
+	}
+
+	// missing key
+	err := oc.Update(key, func(current []byte, found bool) ([]byte, error) {
+		assert.False(t, found)
+		return []byte{1}, nil
+	}, time.Minute)
+	assert.Nil(t, err)
+
+	// an error writes nothing
+	err = oc.Update(key, func([]byte, bool) ([]byte, error) {
+		return []byte{9}, ErrNotInteger
+	}, time.Minute)
+	assert.Equal(t, ErrNotInteger, err)
+	b, _ := oc.Get(key)
+	assert.Equal(t, []byte{1}, b)
+
+	// concurrent updates don't clobber each other
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			oc.Update(key, appendOne, time.Minute)
+		}()
+	}
+	wg.Wait()
+	b, _ = oc.Get(key)
+	assert.Len(t, b, 51)
+}
@@ -0,0 +1,140 @@
+package omnicache
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+)
+
+// ErrBackfillTimeout is returned by Fetch when BackfillCache.CacheMiss doesn't return within the backfill timeout
+var ErrBackfillTimeout = errors.New("omnicache: backfill timed out")
+
+// ErrDontCache may be returned by BackfillCache.CacheMiss along with a value that should be served but not stored
+// (e.g. a degraded fallback during an upstream outage). Fetch and its variants return the value without error
+var ErrDontCache = errors.New("omnicache: don't cache backfilled value")
+
+// ContextBackfillCache is a BackfillCache that also accepts a context, which is cancelled
+// when the backfill times out or is cancelled with CancelBackfill
+type ContextBackfillCache interface {
+	BackfillCache
+	CacheMissContext(ctx context.Context, key string) ([]byte, error)
+}
+
+// inflightBackfills tracks running backfills so they can be listed and cancelled
+type inflightBackfills struct {
+	mu     sync.Mutex
+	nextID uint64
+	byKey  map[string]map[uint64]context.CancelFunc
+}
+
+func (ib *inflightBackfills) add(key string, cancel context.CancelFunc) uint64 {
+	ib.mu.Lock()
+	defer ib.mu.Unlock()
+	if ib.byKey == nil {
+		ib.byKey = map[string]map[uint64]context.CancelFunc{}
+	}
+	if ib.byKey[key] == nil {
+		ib.byKey[key] = map[uint64]context.CancelFunc{}
+	}
+	ib.nextID++
+	ib.byKey[key][ib.nextID] = cancel
+	return ib.nextID
+}
+
+func (ib *inflightBackfills) remove(key string, id uint64) {
+	ib.mu.Lock()
+	defer ib.mu.Unlock()
+	delete(ib.byKey[key], id)
+	if len(ib.byKey[key]) == 0 {
+		delete(ib.byKey, key)
+	}
+}
+
+// backfill calls b.CacheMiss, giving up with ErrBackfillTimeout once the backfill timeout elapses,
+// or with context.Canceled if cancelled by CancelBackfill. A CacheMiss that gave up keeps running
+// in the background (unless it honors the context of a ContextBackfillCache) and its result is discarded
+func (oc *OmniCache) backfill(k []byte, b BackfillCache) ([]byte, error) {
+	return oc.backfillContext(context.Background(), k, b)
+}
+
+// backfillContext is backfill, also giving up with parent's error once parent is done
+func (oc *OmniCache) backfillContext(parent context.Context, k []byte, b BackfillCache) ([]byte, error) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if oc.backfillTimeout > 0 {
+		ctx, cancel = context.WithTimeout(parent, oc.backfillTimeout)
+	} else {
+		ctx, cancel = context.WithCancel(parent)
+	}
+	defer cancel()
+
+	key := string(k)
+	id := oc.inflight.add(key, cancel)
+	defer oc.inflight.remove(key, id)
+
+	type result struct {
+		b   []byte
+		err error
+	}
+	if oc.backfillSlots != nil {
+		select {
+		case oc.backfillSlots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, backfillErr(parent, ctx)
+		}
+	}
+	ch := make(chan result, 1)
+	go func() {
+		var r result
+		if cb, ok := b.(ContextBackfillCache); ok {
+			r.b, r.err = cb.CacheMissContext(ctx, key)
+		} else {
+			r.b, r.err = b.CacheMiss(key)
+		}
+		if oc.backfillSlots != nil {
+			// held until CacheMiss returns, even once it's been given up on
+			<-oc.backfillSlots
+		}
+		ch <- r
+	}()
+
+	select {
+	case r := <-ch:
+		return r.b, r.err
+	case <-ctx.Done():
+		return nil, backfillErr(parent, ctx)
+	}
+}
+
+// backfillErr returns the error a backfill gives up with once ctx, derived from parent, is done
+func backfillErr(parent, ctx context.Context) error {
+	if err := parent.Err(); err != nil {
+		return err
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return ErrBackfillTimeout
+	}
+	return ctx.Err()
+}
+
+// InflightBackfills returns the sorted keys currently being backfilled
+func (oc *OmniCache) InflightBackfills() []string {
+	oc.inflight.mu.Lock()
+	defer oc.inflight.mu.Unlock()
+	keys := make([]string, 0, len(oc.inflight.byKey))
+	for k := range oc.inflight.byKey {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// CancelBackfill cancels every in-flight backfill for key; waiting Fetch calls return context.Canceled
+func (oc *OmniCache) CancelBackfill(key string) {
+	oc.inflight.mu.Lock()
+	defer oc.inflight.mu.Unlock()
+	for _, cancel := range oc.inflight.byKey[key] {
+		cancel()
+	}
+}
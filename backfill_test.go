@@ -0,0 +1,238 @@
+package omnicache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// ctxBackfill blocks until its context is done, signalling started first
+type ctxBackfill struct {
+	started chan struct{}
+	stopped chan error
+}
+
+func (c ctxBackfill) CacheMiss(key string) ([]byte, error) {
+	return c.CacheMissContext(context.Background(), key)
+}
+
+func (c ctxBackfill) CacheMissContext(ctx context.Context, key string) ([]byte, error) {
+	close(c.started)
+	<-ctx.Done()
+	c.stopped <- ctx.Err()
+	return nil, ctx.Err()
+}
+
+func TestCancelBackfill(t *testing.T) {
+	oc := New(createConn())
+	defer oc.Close()
+
+	b := ctxBackfill{started: make(chan struct{}), stopped: make(chan error, 1)}
+	done := make(chan error, 1)
+	go func() {
+		_, err := oc.Fetch([]byte("slow"), b)
+		done <- err
+	}()
+
+	<-b.started
+	assert.Equal(t, []string{"slow"}, oc.InflightBackfills())
+
+	oc.CancelBackfill("slow")
+	select {
+	case err := <-done:
+		assert.Equal(t, context.Canceled, err)
+	case <-time.After(time.Second):
+		t.Fatal("Fetch didn't return after cancel")
+	}
+
+	// the backfill itself saw the cancellation
+	assert.Equal(t, context.Canceled, <-b.stopped)
+	assert.Len(t, oc.InflightBackfills(), 0)
+}
+
+func TestFetchContext(t *testing.T) {
+	oc := New(createConn())
+	defer oc.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	b := ctxBackfill{started: make(chan struct{}), stopped: make(chan error, 1)}
+
+	// the deadline aborts the backfill and reaches the upstream call
+	_, err := oc.FetchContext(ctx, []byte("slow"), b)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, context.DeadlineExceeded, <-b.stopped)
+
+	// already done
+	_, err = oc.FetchContext(ctx, []byte("slow"), doubler{Value: 1})
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	v, err := oc.FetchWithTTLContext(context.Background(), []byte("fast"), doubler{Value: 1}, time.Minute)
+	assert.Nil(t, err)
+	d, _ := decodeDoubler(v)
+	assert.Equal(t, 2, d.Value)
+}
+
+func TestFetchContextSharedBackfill(t *testing.T) {
+	oc := New(createConn())
+	defer oc.Close()
+
+	h := hanger{release: make(chan struct{})}
+	go oc.Fetch([]byte("slow"), h)
+	for len(oc.InflightBackfills()) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// a caller waiting on another's backfill still honors its own context
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := oc.FetchContext(ctx, []byte("slow"), h)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	close(h.release)
+}
+
+func TestBackfillDebounce(t *testing.T) {
+	oc := New(newMapConn(), WithBackfillDebounce(100*time.Millisecond))
+	defer oc.Close()
+
+	// each stored value expires before the next serial Fetch
+	var calls int32
+	for i := 0; i < 3; i++ {
+		b, err := oc.FetchWithTTL([]byte("k"), slowBackfill{calls: &calls}, time.Millisecond)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("k"), b)
+		time.Sleep(5 * time.Millisecond)
+	}
+	assert.Equal(t, int32(1), calls)
+
+	// a write supersedes the debounced result
+	oc.SetWithTTL([]byte("k"), []byte("set"), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	_, err := oc.FetchWithTTL([]byte("k"), slowBackfill{calls: &calls}, time.Millisecond)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(2), calls)
+
+	// past the window
+	time.Sleep(100 * time.Millisecond)
+	_, err = oc.FetchWithTTL([]byte("k"), slowBackfill{calls: &calls}, time.Millisecond)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(3), calls)
+}
+
+func TestBackfillDebounceCopies(t *testing.T) {
+	oc := New(newMapConn(), WithBackfillDebounce(100*time.Millisecond))
+	defer oc.Close()
+
+	var calls int32
+	b, err := oc.FetchWithTTL([]byte("k"), slowBackfill{calls: &calls}, time.Millisecond)
+	assert.Nil(t, err)
+	b[0] = 'x'
+	time.Sleep(5 * time.Millisecond)
+
+	// mutating one caller's result leaves the debounced value intact
+	for i := 0; i < 2; i++ {
+		b, err = oc.FetchWithTTL([]byte("k"), slowBackfill{calls: &calls}, time.Millisecond)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("k"), b)
+		b[0] = 'y'
+	}
+	assert.Equal(t, int32(1), calls)
+}
+
+func TestFetchDontCache(t *testing.T) {
+	oc := New(NewMetaConn(newMapConn()))
+	defer oc.Close()
+
+	fallback := backfillFunc(func(string) ([]byte, error) {
+		return []byte("degraded"), ErrDontCache
+	})
+
+	// served without error but not stored
+	b, err := oc.Fetch([]byte("k"), fallback)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("degraded"), b)
+	_, err = oc.Get([]byte("k"))
+	assert.Errorf(t, err, "Key not found")
+
+	b, err = oc.FetchTol([]byte("k"), fallback, time.Minute, time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("degraded"), b)
+	_, err = oc.Get([]byte("k"))
+	assert.Errorf(t, err, "Key not found")
+}
+
+func TestMaxConcurrentBackfills(t *testing.T) {
+	oc := New(newMapConn(), WithMaxConcurrentBackfills(2))
+	defer oc.Close()
+
+	var running, peak int32
+	release := make(chan struct{})
+	gated := backfillFunc(func(key string) ([]byte, error) {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		<-release
+		atomic.AddInt32(&running, -1)
+		return []byte(key), nil
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			oc.Fetch([]byte(fmt.Sprint(i)), gated)
+		}(i)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	// a caller waiting for a slot gives up with its context
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := oc.FetchContext(ctx, []byte("waiting"), gated)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(2), peak)
+	assert.Equal(t, 2, oc.Config().MaxConcurrentBackfills)
+}
+
+func TestFetchEmptyValue(t *testing.T) {
+	calls := 0
+	empty := backfillFunc(func(string) ([]byte, error) {
+		calls++
+		return []byte{}, nil
+	})
+
+	// returned but not stored by default
+	oc := New(newMapConn())
+	b, err := oc.FetchWithTTL([]byte("k"), empty, time.Minute)
+	assert.Nil(t, err)
+	assert.Empty(t, b)
+	oc.Fetch([]byte("k"), empty)
+	assert.Equal(t, 2, calls)
+	_, err = oc.Get([]byte("k"))
+	assert.Equal(t, ErrNotFound, err)
+	oc.Close()
+
+	// stored when allowed
+	oc = New(newMapConn(), WithCacheEmptyValues())
+	defer oc.Close()
+	oc.Fetch([]byte("k"), empty)
+	oc.Fetch([]byte("k"), empty)
+	assert.Equal(t, 3, calls)
+	b, err = oc.Get([]byte("k"))
+	assert.Nil(t, err)
+	assert.Empty(t, b)
+	assert.True(t, oc.Config().CacheEmpty)
+}
@@ -1,11 +1,25 @@
 package omnicache
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/panoplymedia/cache"
 )
 
+// ErrValueTooLarge is returned when a value written is longer than WithMaxValueBytes allows, and nothing is stored
+var ErrValueTooLarge = errors.New("omnicache: value exceeds the maximum value size")
+
+// ErrClosed is returned by reads, writes and deletes made through an OmniCache after Close
+var ErrClosed = errors.New("omnicache: cache closed")
+
+// ErrEmptyKey is returned when a read, write or delete is given an empty or nil key, which Conns may not handle
+var ErrEmptyKey = errors.New("omnicache: empty key")
+
 // BackfillCache is an interface implementing CacheMiss that is called
 // to hydrate the cache when fetching data via `Fetch` results in a miss
 type BackfillCache interface {
@@ -14,64 +28,568 @@ type BackfillCache interface {
 
 // OmniCache contains connection to a cache layer
 type OmniCache struct {
+	// Conn is the underlying connection, kept exported for backward compatibility; prefer Backend
 	Conn cache.Conn
+
+	backfillTimeout time.Duration
+	defaultTTL      time.Duration
+	minTTL          time.Duration
+	minTTLPolicy    MinTTLPolicy
+	maxTTL          time.Duration
+	noExpiryPolicy  NoExpiryPolicy
+	ttlJitter       float64
+	snapshotMode    TTLMode
+	maxValueBytes   int
+	cacheEmpty      bool
+	validator       func(key string, val []byte) error
+	serializer      Serializer
+	clock           func() time.Time
+	backfillSlots   chan struct{}
+
+	negative *negativeCache
+	peaks    *peakWatch
+	hot      *hotKeys
+	debounce *debouncer
+	expire   *expireRefresher
+	sweep    *sweeper
+	evict    *evictNotifier
+
+	// prefix is the full key prefix of a Namespace view, under which it shares its parent's state
+	prefix []byte
+	closed *closeState
+
+	locks      keyLocks
+	thresholds *thresholdWatch
+	prewarm    *prewarmState
+	inflight   *inflightBackfills
+	flights    *flightGroup
+	reads      *readCounts
+	async      *pendingKeys
+	refreshing *pendingKeys
+}
+
+// closeState records whether an OmniCache, or the parent of a Namespace view, has been closed
+type closeState struct {
+	once   sync.Once
+	closed uint32
+	parent *closeState
+}
+
+func (cs *closeState) isClosed() bool {
+	for ; cs != nil; cs = cs.parent {
+		if atomic.LoadUint32(&cs.closed) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// readCounts counts the hits and misses of reads made through an OmniCache
+type readCounts struct {
+	hits   uint64
+	misses uint64
 }
 
 // New creates a new OmniCache
-func New(c cache.Conn) *OmniCache {
-	return &OmniCache{Conn: c}
+func New(c cache.Conn, opts ...Option) *OmniCache {
+	oc := &OmniCache{
+		Conn:       c,
+		closed:     &closeState{},
+		locks:      keyLocks{lockTable: &lockTable{}},
+		thresholds: &thresholdWatch{},
+		prewarm:    &prewarmState{},
+		inflight:   &inflightBackfills{},
+		flights:    &flightGroup{},
+		reads:      &readCounts{},
+		async:      &pendingKeys{},
+		refreshing: &pendingKeys{},
+	}
+	for _, opt := range opts {
+		opt(oc)
+	}
+	if cs, ok := c.(ClockSetter); ok && oc.clock != nil {
+		cs.SetClock(oc.clock)
+	}
+	if h, ok := c.(ExpireHooker); ok && oc.expire != nil {
+		h.SetExpireHook(oc.onExpire)
+	}
+	if oc.evict != nil {
+		if en, ok := c.(EvictNotifier); ok {
+			en.SetEvictHook(oc.evict.notify)
+		}
+		go oc.evict.run()
+	}
+	if cp, ok := c.(Compacter); ok && oc.sweep != nil {
+		go oc.sweep.run(cp)
+	} else {
+		oc.sweep = nil
+	}
+	return oc
 }
 
-// Close closes connection to local cache backend
+// Backend returns the underlying cache.Conn as an escape hatch for backend specific capabilities
+// (e.g. a Redis pipeline) that OmniCache doesn't abstract. Operations made directly on the Conn
+// bypass OmniCache behavior such as TTL guardrails and backfill timeouts
+func (oc *OmniCache) Backend() cache.Conn {
+	return oc.Conn
+}
+
+// Close closes connection to local cache backend, stopping the WithSweepInterval sweeper and
+// WithOnEvict notifications first. Only the first call closes, later ones are no-ops returning nil,
+// and reads, writes and deletes made through the OmniCache afterwards return ErrClosed
+// Closing a Namespace view leaves its parent, and the parent's sweeper and notifications, running
 func (oc *OmniCache) Close() error {
-	return oc.Conn.Close()
+	var err error
+	oc.closed.once.Do(func() {
+		atomic.StoreUint32(&oc.closed.closed, 1)
+		if oc.closed.parent != nil {
+			return
+		}
+		oc.sweep.halt()
+		oc.evict.halt()
+		err = oc.Conn.Close()
+	})
+	return err
+}
+
+// Ping checks the cache connection is healthy without touching cached data, e.g. for a liveness probe,
+// returning ErrClosed after Close. Conns implementing Pinger are asked to check themselves (e.g. a network
+// round trip); others, such as in-process stores, are healthy until closed
+func (oc *OmniCache) Ping() error {
+	if oc.closed.isClosed() {
+		return ErrClosed
+	}
+	return ping(oc.Conn)
+}
+
+// checkKey returns ErrClosed once the OmniCache is closed, or ErrEmptyKey for an empty k
+func (oc *OmniCache) checkKey(k []byte) error {
+	if oc.closed.isClosed() {
+		return ErrClosed
+	}
+	if len(k) == 0 {
+		return ErrEmptyKey
+	}
+	return nil
 }
 
 // Fetch gets data from the cache for the specified key
-// If the data is missing, the result from BackfillCache.CacheMiss is returned and stored to the key
+// If the data is missing, the result from BackfillCache.CacheMiss is returned and stored to the key, unless it
+// returns ErrDontCache or, without WithCacheEmptyValues, an empty value
+// Concurrent misses for the same key run a single CacheMiss and share its result (the same slice) or error
 func (oc *OmniCache) Fetch(k []byte, b BackfillCache) ([]byte, error) {
-	ret, err := oc.Conn.Read(k)
-	if err != nil {
-		ret, err = b.CacheMiss(string(k))
-		if err != nil {
-			return ret, err
-		}
-		err = oc.Conn.Write(k, ret)
-	}
+	ret, _, err := oc.FetchWithStatus(k, b)
+	return ret, err
+}
 
+// FetchWithStatus is the same as Fetch, also reporting whether the value was a cache hit, i.e. read live
+// from the Conn rather than backfilled (or shared from a concurrent or debounced backfill)
+func (oc *OmniCache) FetchWithStatus(k []byte, b BackfillCache) ([]byte, bool, error) {
+	return oc.fetch(context.Background(), k, b, 0, func(v []byte) error {
+		return oc.Set(k, v)
+	})
+}
+
+// FetchContext is the same as Fetch, but gives up with ctx's error once ctx is done, e.g. at a request
+// deadline. A ContextBackfillCache receives a context cancelled along with ctx, so the upstream call can
+// stop too. Concurrent misses for the same key share the backfill started by the first, under its context
+func (oc *OmniCache) FetchContext(ctx context.Context, k []byte, b BackfillCache) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	ret, _, err := oc.fetch(ctx, k, b, 0, func(v []byte) error {
+		return oc.Set(k, v)
+	})
 	return ret, err
 }
 
 // FetchWithTTL is the same as Fetch, but with an explicit TTL
 func (oc *OmniCache) FetchWithTTL(k []byte, b BackfillCache, ttl time.Duration) ([]byte, error) {
-	ret, err := oc.Conn.Read(k)
+	ttl, err := oc.checkTTL(ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	ret, _, err := oc.fetch(context.Background(), k, b, 0, func(v []byte) error {
+		return oc.writeTTL(k, v, ttl)
+	})
+	return ret, err
+}
+
+// FetchWithTTLContext is the same as FetchWithTTL, but gives up with ctx's error once ctx is done, as FetchContext does
+func (oc *OmniCache) FetchWithTTLContext(ctx context.Context, k []byte, b BackfillCache, ttl time.Duration) ([]byte, error) {
+	ttl, err := oc.checkTTL(ttl)
 	if err != nil {
-		ret, err = b.CacheMiss(string(k))
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ret, _, err := oc.fetch(ctx, k, b, 0, func(v []byte) error {
+		return oc.writeTTL(k, v, ttl)
+	})
+	return ret, err
+}
+
+// FetchNegative is the same as Fetch, but remembers a backfill returning ErrNotFound for negTTL rather
+// than the WithNegativeCache ttl, so Fetch calls for k return ErrNotFound without backfilling until then
+// Negatives are held in the WithNegativeCache LRU, apart from real values (so even an empty value is
+// never mistaken for one), which must be enabled
+func (oc *OmniCache) FetchNegative(k []byte, b BackfillCache, negTTL time.Duration) ([]byte, error) {
+	if oc.negative == nil {
+		return nil, ErrNotSupported
+	}
+	ret, _, err := oc.fetch(context.Background(), k, b, negTTL, func(v []byte) error {
+		return oc.Set(k, v)
+	})
+	return ret, err
+}
+
+// fetch reads k, backfilling and storing it with store on a miss, and reports whether it was a hit
+// A backfill returning ErrNotFound is negatively cached for negTTL, or the WithNegativeCache ttl if zero
+func (oc *OmniCache) fetch(ctx context.Context, k []byte, b BackfillCache, negTTL time.Duration, store func(v []byte) error) ([]byte, bool, error) {
+	if err := oc.checkKey(k); err != nil {
+		return nil, false, err
+	}
+	ret, err := oc.read(k)
+	if err == nil {
+		return ret, true, nil
+	}
+	ret, err = oc.fill(ctx, k, b, negTTL, store)
+	return ret, false, err
+}
+
+// fill backfills k after a miss, storing the result with store unless it's negatively cached,
+// debounced, not to be cached or a disallowed empty value
+func (oc *OmniCache) fill(ctx context.Context, k []byte, b BackfillCache, negTTL time.Duration, store func(v []byte) error) ([]byte, error) {
+	key := oc.sharedKey(k)
+	if oc.negative.has(key, oc.now()) {
+		return nil, ErrNotFound
+	}
+	if ret, ok := oc.debounce.get(key, oc.now()); ok {
+		return ret, nil
+	}
+
+	// concurrent misses for k share a single backfill and store
+	ret, err, _ := oc.flights.do(ctx, key, func() ([]byte, error) {
+		ret, err := oc.backfillContext(ctx, k, b)
+		if err == ErrDontCache || (err == nil && len(ret) == 0 && !oc.cacheEmpty) {
+			return ret, nil
+		}
 		if err != nil {
+			if err == ErrNotFound {
+				oc.negative.add(key, oc.now(), negTTL)
+			}
 			return ret, err
 		}
-		err = oc.Conn.WriteTTL(k, ret, ttl)
-	}
 
+		err = store(ret)
+		oc.debounce.put(key, ret, oc.now())
+		return ret, err
+	})
 	return ret, err
 }
 
-// Set writes data to the cache
+// now returns the current time from the OmniCache's clock
+func (oc *OmniCache) now() time.Time {
+	if oc.clock != nil {
+		return oc.clock()
+	}
+	return time.Now()
+}
+
+// Set writes data to the cache with the WithDefaultTTL TTL, never expiring without one
+// v is copied before being stored, so the caller may reuse it afterwards
 func (oc *OmniCache) Set(k, v []byte) error {
+	if oc.defaultTTL > 0 {
+		return oc.SetWithTTL(k, v, oc.defaultTTL)
+	}
+	defer oc.observeWrite()
+	if err := oc.checkWrite(k, v); err != nil {
+		return err
+	}
+	v = copyBytes(v)
+	defer oc.locks.lock(k).Unlock()
+	oc.written(k)
 	return oc.Conn.Write(k, v)
 }
 
 // SetWithTTL writes data to the cache with an explicit TTL
 func (oc *OmniCache) SetWithTTL(k, v []byte, ttl time.Duration) error {
+	ttl, err := oc.checkTTL(ttl)
+	if err != nil {
+		return err
+	}
+	return oc.writeTTL(k, v, ttl)
+}
+
+// writeTTL writes to the Conn under the key's lock
+func (oc *OmniCache) writeTTL(k, v []byte, ttl time.Duration) error {
+	defer oc.observeWrite()
+	if err := oc.checkWrite(k, v); err != nil {
+		return err
+	}
+	v = copyBytes(v)
+	defer oc.locks.lock(k).Unlock()
+	oc.written(k)
 	return oc.Conn.WriteTTL(k, v, ttl)
 }
 
-// Get retrieves data for a key from the cache
+// observeWrite feeds the Conn's stats after a write to OnThreshold and WithPeakTracking, if used
+func (oc *OmniCache) observeWrite() {
+	if atomic.LoadInt32(&oc.thresholds.active) == 0 && oc.peaks == nil {
+		return
+	}
+	if s, err := oc.Conn.Stats(); err == nil {
+		oc.thresholds.observe(s)
+		oc.peaks.observe(s)
+	}
+}
+
+// written drops in-process state superseded by a write to k
+func (oc *OmniCache) written(k []byte) {
+	oc.negative.forget(oc.sharedKey(k))
+	oc.debounce.forget(oc.sharedKey(k))
+}
+
+// sharedKey returns k as known to the state a Namespace view shares with its parent, i.e. with the view's prefix
+func (oc *OmniCache) sharedKey(k []byte) string {
+	return string(oc.prefix) + string(k)
+}
+
+// checkWrite rejects writes after Close and to empty keys, and vets a write with the WithMaxValueBytes limit, WithWriteValidator validator and WithHotKeys throttling, if any
+func (oc *OmniCache) checkWrite(k, v []byte) error {
+	if err := oc.checkKey(k); err != nil {
+		return err
+	}
+	if oc.maxValueBytes > 0 && len(v) > oc.maxValueBytes {
+		return ErrValueTooLarge
+	}
+	if oc.validator != nil {
+		if err := oc.validator(string(k), v); err != nil {
+			return err
+		}
+	}
+	return oc.hot.write(oc.sharedKey(k), oc.now())
+}
+
+// Delete removes a key from the cache, e.g. to evict a session on logout rather than wait for its TTL
+// Deleting a missing key is a no-op. The Conn must implement Deleter
+func (oc *OmniCache) Delete(k []byte) error {
+	d, ok := oc.Conn.(Deleter)
+	if !ok {
+		return ErrNotSupported
+	}
+	if err := oc.checkKey(k); err != nil {
+		return err
+	}
+
+	defer oc.observeWrite()
+	return oc.deleteLocked(d, k)
+}
+
+// DeletePrefix removes every key starting with prefix, e.g. all of a tenant's keys, returning how many it removed
+// Conns implementing PrefixDeleter remove them directly; otherwise the Conn must implement Ranger and Deleter,
+// and keys written with the prefix while it runs may survive. WithOnEvict is notified of each removed key
+// either way, except when a PrefixDeleter doesn't implement Ranger, as the removed keys can't be found
+func (oc *OmniCache) DeletePrefix(prefix []byte) (int, error) {
+	if oc.closed.isClosed() {
+		return 0, ErrClosed
+	}
+	defer oc.observeWrite()
+	if pd, ok := oc.Conn.(PrefixDeleter); ok {
+		oc.locks.lockAll()
+		defer oc.locks.unlockAll()
+		oc.negative.clear()
+		oc.debounce.clear()
+
+		var keys, vals [][]byte
+		if oc.evict != nil {
+			oc.rangeConn(func(k, v []byte) bool {
+				if bytes.HasPrefix(k, prefix) {
+					keys = append(keys, copyBytes(k))
+					vals = append(vals, copyBytes(v))
+				}
+				return true
+			})
+		}
+		n, err := pd.DeletePrefix(prefix)
+		if err != nil {
+			return n, err
+		}
+		for i, k := range keys {
+			oc.evict.notify([]byte(oc.sharedKey(k)), vals[i], EvictDeleted)
+		}
+		return n, nil
+	}
+
+	d, ok := oc.Conn.(Deleter)
+	if !ok {
+		return 0, ErrNotSupported
+	}
+	var keys [][]byte
+	err := oc.rangeConn(func(k, _ []byte) bool {
+		if bytes.HasPrefix(k, prefix) {
+			keys = append(keys, append([]byte(nil), k...))
+		}
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, k := range keys {
+		if err := oc.deleteLocked(d, k); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// deleteLocked deletes k with d under the key's lock, notifying WithOnEvict if k was there
+func (oc *OmniCache) deleteLocked(d Deleter, k []byte) error {
+	defer oc.locks.lock(k).Unlock()
+	return oc.deleteHeld(d, k)
+}
+
+// deleteHeld is deleteLocked with the key's lock already held
+func (oc *OmniCache) deleteHeld(d Deleter, k []byte) error {
+	oc.written(k)
+	if oc.evict == nil {
+		return d.Delete(k)
+	}
+
+	v, rerr := oc.Conn.Read(k)
+	if err := d.Delete(k); err != nil {
+		return err
+	}
+	if rerr == nil {
+		oc.evict.notify([]byte(oc.sharedKey(k)), v, EvictDeleted)
+	}
+	return nil
+}
+
+// Flush removes every key from the cache, holding off writes made through the OmniCache meanwhile
+// Reads and writes made directly on the Conn while it runs may observe a partially cleared cache
+// The Conn must implement Flusher
+func (oc *OmniCache) Flush() error {
+	f, ok := oc.Conn.(Flusher)
+	if !ok {
+		return ErrNotSupported
+	}
+	if oc.closed.isClosed() {
+		return ErrClosed
+	}
+
+	defer oc.observeWrite()
+	oc.locks.lockAll()
+	defer oc.locks.unlockAll()
+	oc.negative.clear()
+	oc.debounce.clear()
+	return f.Flush()
+}
+
+// Get retrieves data for a key from the cache, returning ErrNotFound if it's missing or expired
+// Other Conn errors (e.g. a closed connection) are returned as is. The returned bytes are a copy the caller may modify without affecting the cached value. Copying
+// on Get and Set costs an allocation and a copy of the value per call
 func (oc *OmniCache) Get(k []byte) ([]byte, error) {
-	return oc.Conn.Read(k)
+	return oc.read(k)
+}
+
+// read reads a copy of k from the Conn, counting a hit or miss
+func (oc *OmniCache) read(k []byte) ([]byte, error) {
+	if err := oc.checkKey(k); err != nil {
+		return nil, err
+	}
+	v, err := oc.Conn.Read(k)
+	if err != nil {
+		atomic.AddUint64(&oc.reads.misses, 1)
+		return nil, notFound(err)
+	}
+	atomic.AddUint64(&oc.reads.hits, 1)
+	return copyBytes(v), nil
+}
+
+// notFound turns a Conn read error signalling a miss, ErrNotFound or the "Key not found" error
+// of the memorystore Conn, into ErrNotFound, keeping other errors distinct
+func notFound(err error) error {
+	if err == ErrNotFound || err.Error() == "Key not found" {
+		return ErrNotFound
+	}
+	return err
+}
+
+// copyBytes returns a copy of b, so stored values and the caller's slices never alias
+func copyBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append(make([]byte, 0, len(b)), b...)
+}
+
+// Has reports whether a live (unexpired) key is in the cache without returning its value
+// Conns implementing Exister are asked directly, others are read
+func (oc *OmniCache) Has(k []byte) (bool, error) {
+	if err := oc.checkKey(k); err != nil {
+		return false, err
+	}
+	if e, ok := oc.Conn.(Exister); ok {
+		return e.Exists(k), nil
+	}
+	_, err := oc.Conn.Read(k)
+	return err == nil, nil
 }
 
 // Stats provides stats about the cache connection
+// "Hits" and "Misses" count the reads made by Get, GetMulti and the Fetch variants (an expired key is a miss),
+// unless the Conn reports its own. With WithNegativeCache, stats about negatively cached keys are included, and with WithPeakTracking "PeakKeyCount" and "PeakBytesStored". Once Prewarm has been called, "PrewarmRemaining" reports the number of keys still being warmed
 func (oc *OmniCache) Stats() (map[string]interface{}, error) {
-	return oc.Conn.Stats()
+	s, err := oc.Conn.Stats()
+	if err != nil {
+		return s, err
+	}
+	if _, ok := s["Hits"]; !ok {
+		s["Hits"] = atomic.LoadUint64(&oc.reads.hits)
+	}
+	if _, ok := s["Misses"]; !ok {
+		s["Misses"] = atomic.LoadUint64(&oc.reads.misses)
+	}
+	if atomic.LoadInt32(&oc.prewarm.started) == 1 {
+		s["PrewarmRemaining"] = atomic.LoadInt64(&oc.prewarm.remaining)
+	}
+	oc.negative.stats(s)
+	oc.evict.stats(s)
+	oc.thresholds.observe(s)
+	oc.peaks.observe(s)
+	oc.peaks.stats(s)
+	return s, nil
+}
+
+// ResetStats zeroes the hit, miss and negative cache counters, and those of the Conn if it implements
+// StatsResetter, without touching cached data, e.g. to start a new metrics window. It's safe to call
+// alongside reads and writes, though increments racing with the reset may be lost
+func (oc *OmniCache) ResetStats() error {
+	atomic.StoreUint64(&oc.reads.hits, 0)
+	atomic.StoreUint64(&oc.reads.misses, 0)
+	oc.negative.resetStats()
+	return resetStats(oc.Conn)
+}
+
+// resetStats resets c's counters if it implements StatsResetter
+func resetStats(c cache.Conn) error {
+	if r, ok := c.(StatsResetter); ok {
+		return r.ResetStats()
+	}
+	return nil
+}
+
+// ping pings c if it implements Pinger, succeeding trivially otherwise
+func ping(c cache.Conn) error {
+	if p, ok := c.(Pinger); ok {
+		return p.Ping()
+	}
+	return nil
 }
@@ -3,9 +3,14 @@ package omnicache
 import (
 	"bytes"
 	"encoding/gob"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/panoplymedia/cache"
 	"github.com/panoplymedia/omni-cache-memorystore"
 	"github.com/stretchr/testify/assert"
 )
@@ -34,6 +39,16 @@ func decodeDoubler(b []byte) (doubler, error) {
 	return d, err
 }
 
+// hanger is a BackfillCache that blocks until release is closed
+type hanger struct {
+	release chan struct{}
+}
+
+func (h hanger) CacheMiss(key string) ([]byte, error) {
+	<-h.release
+	return []byte(key), nil
+}
+
 func createConn() *memorystorecache.Conn {
 	memCache, _ := memorystorecache.NewCache(time.Second)
 	c, _ := memCache.Open("")
@@ -44,7 +59,25 @@ func TestNew(t *testing.T) {
 	c := createConn()
 	oc := New(c)
 	defer oc.Close()
-	assert.Equal(t, &OmniCache{Conn: c}, oc)
+	assert.Equal(t, &OmniCache{
+		Conn:       c,
+		closed:     &closeState{},
+		locks:      keyLocks{lockTable: &lockTable{}},
+		thresholds: &thresholdWatch{},
+		prewarm:    &prewarmState{},
+		inflight:   &inflightBackfills{},
+		flights:    &flightGroup{},
+		reads:      &readCounts{},
+		async:      &pendingKeys{},
+		refreshing: &pendingKeys{},
+	}, oc)
+}
+
+func TestBackend(t *testing.T) {
+	c := createConn()
+	oc := New(c)
+	defer oc.Close()
+	assert.Equal(t, c, oc.Backend())
 }
 
 func TestSet(t *testing.T) {
@@ -102,7 +135,7 @@ func TestGet(t *testing.T) {
 	key := []byte("my-key")
 	// cache miss
 	b, err := oc.Get(key)
-	assert.Errorf(t, err, "Key not found")
+	assert.Equal(t, ErrNotFound, err)
 
 	// cache hit
 	v := []byte{1, 2}
@@ -113,6 +146,23 @@ func TestGet(t *testing.T) {
 	assert.Equal(t, v, b)
 }
 
+func TestGetNotFound(t *testing.T) {
+	m, clock := newFakeMapConn()
+	oc := New(m)
+	defer oc.Close()
+
+	// expired
+	oc.SetWithTTL([]byte("k"), []byte{1}, time.Second)
+	clock.Advance(2 * time.Second)
+	_, err := oc.Get([]byte("k"))
+	assert.Equal(t, ErrNotFound, err)
+
+	// other errors stay distinct
+	oc = New(&flakyConn{mapConn: m, failures: 1})
+	_, err = oc.Get([]byte("k"))
+	assert.Equal(t, temporaryError{}, err)
+}
+
 func TestFetch(t *testing.T) {
 	c := createConn()
 	oc := New(c)
@@ -141,6 +191,35 @@ func TestFetch(t *testing.T) {
 	assert.Equal(t, 8, newD.Value)
 }
 
+func TestFetchWithStatus(t *testing.T) {
+	m, clock := newFakeMapConn()
+	oc := New(m)
+	defer oc.Close()
+
+	key := []byte("fetch")
+
+	// cache miss
+	b, hit, err := oc.FetchWithStatus(key, doubler{Value: 2})
+	assert.Nil(t, err)
+	assert.False(t, hit)
+	newD, _ := decodeDoubler(b)
+	assert.Equal(t, 4, newD.Value)
+
+	// cache hit
+	b, hit, err = oc.FetchWithStatus(key, doubler{Value: 2})
+	assert.Nil(t, err)
+	assert.True(t, hit)
+	newD, _ = decodeDoubler(b)
+	assert.Equal(t, 4, newD.Value)
+
+	// expired keys are misses
+	oc.SetWithTTL(key, b, time.Millisecond)
+	clock.Advance(5 * time.Millisecond)
+	_, hit, err = oc.FetchWithStatus(key, doubler{Value: 2})
+	assert.Nil(t, err)
+	assert.False(t, hit)
+}
+
 func TestFetchWithTTL(t *testing.T) {
 	c := createConn()
 	oc := New(c)
@@ -169,6 +248,35 @@ func TestFetchWithTTL(t *testing.T) {
 	assert.Equal(t, 8, newD.Value)
 }
 
+func TestFetchBackfillTimeout(t *testing.T) {
+	c := createConn()
+	oc := New(c, WithDefaultBackfillTimeout(50*time.Millisecond))
+	defer oc.Close()
+
+	h := hanger{release: make(chan struct{})}
+	defer close(h.release)
+
+	start := time.Now()
+	_, err := oc.Fetch([]byte("hang"), h)
+	elapsed := time.Since(start)
+	assert.Equal(t, ErrBackfillTimeout, err)
+	assert.True(t, elapsed >= 50*time.Millisecond)
+	assert.True(t, elapsed < time.Second)
+
+	_, err = oc.FetchWithTTL([]byte("hang"), h, time.Second)
+	assert.Equal(t, ErrBackfillTimeout, err)
+
+	// nothing was cached for the timed out key
+	_, err = oc.Get([]byte("hang"))
+	assert.Errorf(t, err, "Key not found")
+
+	// a backfill finishing in time is unaffected
+	b, err := oc.Fetch([]byte("fast"), doubler{Value: 1})
+	assert.Nil(t, err)
+	d, err := decodeDoubler(b)
+	assert.Equal(t, 2, d.Value)
+}
+
 func TestStats(t *testing.T) {
 	c := createConn()
 	oc := New(c)
@@ -182,5 +290,344 @@ func TestStats(t *testing.T) {
 
 	s, err := oc.Stats()
 	assert.Nil(t, err)
-	assert.Equal(t, map[string]interface{}{"KeyCount": uint64(1)}, s)
+	assert.Equal(t, map[string]interface{}{"KeyCount": uint64(1), "Hits": uint64(0), "Misses": uint64(0)}, s)
+
+	// cache hit, cache miss and an expired key
+	oc.Get(key)
+	oc.Get([]byte("missing"))
+	oc.SetWithTTL([]byte("short"), v, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	oc.Fetch([]byte("short"), doubler{Value: 1})
+	s, err = oc.Stats()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), s["Hits"])
+	assert.Equal(t, uint64(2), s["Misses"])
+}
+
+func TestDeletePrefix(t *testing.T) {
+	oc := New(NewRoutingConn(newMapConn(), newMapConn()))
+	defer oc.Close()
+
+	for i := 0; i < 10; i++ {
+		oc.Set([]byte(fmt.Sprintf("tenant:1:%d", i)), []byte{1})
+		oc.Set([]byte(fmt.Sprintf("tenant:2:%d", i)), []byte{2})
+	}
+
+	// matching keys are removed across every child
+	n, err := oc.DeletePrefix([]byte("tenant:1:"))
+	assert.Nil(t, err)
+	assert.Equal(t, 10, n)
+	keys, _ := oc.Keys()
+	assert.Len(t, keys, 10)
+	_, err = oc.Get([]byte("tenant:2:0"))
+	assert.Nil(t, err)
+
+	_, err = New(createConn()).DeletePrefix([]byte("tenant:1:"))
+	assert.Equal(t, ErrNotSupported, err)
+}
+
+func TestDelete(t *testing.T) {
+	oc := New(NewRecordingConn(NewTieredConn(newMapConn(), newMapConn())))
+	defer oc.Close()
+
+	oc.Set([]byte("session:1"), []byte{1})
+	err := oc.Delete([]byte("session:1"))
+	assert.Nil(t, err)
+	// cache miss, in both tiers
+	_, err = oc.Get([]byte("session:1"))
+	assert.Errorf(t, err, "Key not found")
+
+	// missing key
+	err = oc.Delete([]byte("session:2"))
+	assert.Nil(t, err)
+
+	ops := oc.Conn.(*RecordingConn).Operations()
+	assert.Equal(t, OpDelete, ops[1].Op)
+
+	oc = New(createConn())
+	defer oc.Close()
+	err = oc.Delete([]byte("session:1"))
+	assert.Equal(t, ErrNotSupported, err)
+}
+
+func TestHas(t *testing.T) {
+	for _, c := range []cache.Conn{newMapConn(), createConn()} {
+		oc := New(c)
+
+		oc.SetWithTTL([]byte("short"), []byte{1}, 10*time.Millisecond)
+		ok, err := oc.Has([]byte("short"))
+		assert.Nil(t, err)
+		assert.True(t, ok)
+
+		// expired and missing keys
+		time.Sleep(20 * time.Millisecond)
+		ok, err = oc.Has([]byte("short"))
+		assert.Nil(t, err)
+		assert.False(t, ok)
+		ok, err = oc.Has([]byte("missing"))
+		assert.Nil(t, err)
+		assert.False(t, ok)
+
+		oc.Close()
+	}
+}
+
+func TestFlush(t *testing.T) {
+	var calls int
+	oc := New(NewTieredConn(newMapConn(), newMapConn()), WithNegativeCache(time.Minute, 10))
+	defer oc.Close()
+
+	oc.Set([]byte("a"), []byte{1})
+	oc.Set([]byte("b"), []byte{2})
+	oc.Fetch([]byte("gone"), notFoundBackfill{calls: &calls})
+
+	err := oc.Flush()
+	assert.Nil(t, err)
+	ok, _ := oc.Has([]byte("a"))
+	assert.False(t, ok)
+	s, err := oc.Stats()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), s["NearKeyCount"])
+	assert.Equal(t, uint64(0), s["FarKeyCount"])
+	assert.Equal(t, uint64(0), s["NegativeKeyCount"])
+
+	oc = New(createConn())
+	defer oc.Close()
+	assert.Equal(t, ErrNotSupported, oc.Flush())
+}
+
+// countingBackfill echoes keys after a delay, counting calls
+type countingBackfill struct {
+	calls *int32
+	err   error
+}
+
+func (c countingBackfill) CacheMiss(key string) ([]byte, error) {
+	atomic.AddInt32(c.calls, 1)
+	time.Sleep(20 * time.Millisecond)
+	return []byte(key), c.err
+}
+
+func TestFetchSingleflight(t *testing.T) {
+	oc := New(newMapConn())
+	defer oc.Close()
+
+	for _, upstreamErr := range []error{nil, errors.New("upstream down")} {
+		var calls int32
+		var wg sync.WaitGroup
+		key := []byte(fmt.Sprintf("hot-%v", upstreamErr))
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				b, err := oc.FetchWithTTL(key, countingBackfill{calls: &calls, err: upstreamErr}, time.Minute)
+				assert.Equal(t, upstreamErr, err)
+				if err == nil {
+					assert.Equal(t, key, b)
+				}
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	}
+
+	// cleared once complete
+	var calls int32
+	oc.Fetch([]byte("once"), countingBackfill{calls: &calls, err: errors.New("failed")})
+	oc.Fetch([]byte("once"), countingBackfill{calls: &calls, err: errors.New("failed")})
+	assert.Equal(t, int32(2), calls)
+}
+
+func TestCopyOnReadWrite(t *testing.T) {
+	oc := New(newMapConn())
+	defer oc.Close()
+
+	key := []byte("buf")
+	v := []byte("value")
+	oc.Set(key, v)
+
+	// the caller reusing its buffer doesn't change the cached value
+	v[0] = 'X'
+	b, err := oc.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), b)
+
+	// nor does mutating what Get returned
+	b[0] = 'Y'
+	b, err = oc.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), b)
+
+	got, _ := oc.GetMulti([][]byte{key})
+	got["buf"][0] = 'Z'
+	b, _ = oc.Get(key)
+	assert.Equal(t, []byte("value"), b)
+}
+
+func TestCopyOnReadWriteVariants(t *testing.T) {
+	lc, _ := NewLRUConn(newMapConn(), 10)
+	oc := New(NewMetaConn(lc))
+	defer oc.Close()
+
+	// shard reads and writes copy like Get and Set
+	v := []byte("value")
+	oc.WithShardForKey([]byte("a"), func(rw ShardReaderWriter) error {
+		rw.Write([]byte("a"), v)
+		v[0] = 'X'
+		b, _ := rw.Read([]byte("a"))
+		b[0] = 'Y'
+		return nil
+	})
+	b, _ := oc.Get([]byte("a"))
+	assert.Equal(t, []byte("value"), b)
+
+	// as do typed reads
+	oc.SetWithType([]byte("t"), []byte("value"), "text/plain", time.Minute)
+	b, _, _ = oc.GetWithType([]byte("t"))
+	b[0] = 'X'
+	b, _, _ = oc.GetWithType([]byte("t"))
+	assert.Equal(t, []byte("value"), b)
+
+	// and prioritized writes
+	lc, _ = NewLRUConn(newMapConn(), 10)
+	oc = New(lc)
+	defer oc.Close()
+	v = []byte("value")
+	oc.SetWithPriority([]byte("p"), v, time.Minute, PriorityHigh)
+	v[0] = 'X'
+	b, _ = oc.Get([]byte("p"))
+	assert.Equal(t, []byte("value"), b)
+}
+
+func TestResetStats(t *testing.T) {
+	lc, _ := NewLRUConn(newMapConn(), 1)
+	oc := New(NewMetaConn(lc), WithNegativeCache(time.Minute, 10))
+	defer oc.Close()
+
+	oc.Set([]byte("a"), []byte{1})
+	oc.Set([]byte("b"), []byte{2})
+	oc.Get([]byte("b"))
+	oc.Get([]byte("a"))
+	calls := 0
+	oc.Fetch([]byte("nope"), notFoundBackfill{calls: &calls})
+	s, _ := oc.Stats()
+	assert.Equal(t, uint64(1), s["Hits"])
+	assert.Equal(t, uint64(1), s["Evictions"])
+
+	// counters are zeroed through the Conn chain, data is kept
+	assert.Nil(t, oc.ResetStats())
+	s, err := oc.Stats()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), s["Hits"])
+	assert.Equal(t, uint64(0), s["Misses"])
+	assert.Equal(t, uint64(0), s["Evictions"])
+	assert.Equal(t, uint64(0), s["NegativeMisses"])
+	assert.Equal(t, uint64(1), s["KeyCount"])
+	b, err := oc.Get([]byte("b"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{2}, b)
+}
+
+func TestEmptyKey(t *testing.T) {
+	oc := New(newMapConn())
+	defer oc.Close()
+
+	for _, k := range [][]byte{nil, {}} {
+		_, err := oc.Get(k)
+		assert.Equal(t, ErrEmptyKey, err)
+		err = oc.Set(k, []byte{1})
+		assert.Equal(t, ErrEmptyKey, err)
+		err = oc.SetWithTTL(k, []byte{1}, time.Minute)
+		assert.Equal(t, ErrEmptyKey, err)
+		err = oc.Delete(k)
+		assert.Equal(t, ErrEmptyKey, err)
+		_, err = oc.Has(k)
+		assert.Equal(t, ErrEmptyKey, err)
+		_, err = oc.TTL(k)
+		assert.Equal(t, ErrEmptyKey, err)
+		_, err = oc.GetMulti([][]byte{[]byte("a"), k})
+		assert.Equal(t, ErrEmptyKey, err)
+		assert.Equal(t, ErrEmptyKey, oc.Touch(k, time.Minute))
+		_, err = oc.Increment(k, 1, time.Minute)
+		assert.Equal(t, ErrEmptyKey, err)
+		_, _, err = oc.GetOrSet(k, []byte{1}, time.Minute)
+		assert.Equal(t, ErrEmptyKey, err)
+
+		// the backfill isn't called
+		called := false
+		_, err = oc.Fetch(k, backfillFunc(func(string) ([]byte, error) {
+			called = true
+			return []byte{1}, nil
+		}))
+		assert.Equal(t, ErrEmptyKey, err)
+		assert.False(t, called)
+	}
+}
+
+func TestCloseIdempotent(t *testing.T) {
+	oc := New(newMapConn(),
+		WithSweepInterval(time.Millisecond),
+		WithOnEvict(func(string, []byte, EvictReason) {}),
+	)
+	oc.Set([]byte("k"), []byte{1})
+
+	// concurrent and repeated closes stop the background goroutines once
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Nil(t, oc.Close())
+		}()
+	}
+	wg.Wait()
+	assert.Nil(t, oc.Close())
+
+	_, err := oc.Get([]byte("k"))
+	assert.Equal(t, ErrClosed, err)
+	err = oc.Set([]byte("k"), []byte{1})
+	assert.Equal(t, ErrClosed, err)
+	err = oc.Delete([]byte("k"))
+	assert.Equal(t, ErrClosed, err)
+	_, err = oc.Fetch([]byte("k"), doubler{Value: 1})
+	assert.Equal(t, ErrClosed, err)
+	_, err = oc.Has([]byte("k"))
+	assert.Equal(t, ErrClosed, err)
+	_, err = oc.TTL([]byte("k"))
+	assert.Equal(t, ErrClosed, err)
+	_, err = oc.GetMulti([][]byte{[]byte("k")})
+	assert.Equal(t, ErrClosed, err)
+	assert.Equal(t, ErrClosed, oc.Touch([]byte("k"), time.Minute))
+	_, err = oc.DeletePrefix([]byte("k"))
+	assert.Equal(t, ErrClosed, err)
+	assert.Equal(t, ErrClosed, oc.Flush())
+	_, err = oc.Increment([]byte("n"), 1, time.Minute)
+	assert.Equal(t, ErrClosed, err)
+	_, _, err = oc.GetOrSet([]byte("k"), []byte{1}, time.Minute)
+	assert.Equal(t, ErrClosed, err)
+}
+
+// pingConn is a mapConn whose Ping returns err
+type pingConn struct {
+	*mapConn
+	err error
+}
+
+func (pc pingConn) Ping() error {
+	return pc.err
+}
+
+func TestPing(t *testing.T) {
+	// in-process Conns are healthy
+	oc := New(createConn())
+	assert.Nil(t, oc.Ping())
+	oc.Close()
+	assert.Equal(t, ErrClosed, oc.Ping())
+
+	// wrappers ask the Conns they wrap
+	down := errors.New("connection refused")
+	oc = New(NewMetaConn(NewTieredConn(newMapConn(), pingConn{newMapConn(), down})))
+	defer oc.Close()
+	assert.Equal(t, down, oc.Ping())
+	assert.Equal(t, down, oc.Namespace([]byte("ns:")).Ping())
 }
@@ -0,0 +1,99 @@
+package omnicache
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"time"
+
+	"github.com/panoplymedia/cache"
+)
+
+// ErrChecksumMismatch is returned by ChecksumConn when a stored value no longer matches its checksum
+var ErrChecksumMismatch = errors.New("omnicache: checksum mismatch")
+
+const checksumSize = 4
+
+// ChecksumConn is a cache.Conn middleware that stores a CRC32 checksum alongside every value
+// and verifies it on Read to detect in-memory corruption
+type ChecksumConn struct {
+	conn cache.Conn
+}
+
+// NewChecksumConn wraps c with a ChecksumConn
+func NewChecksumConn(c cache.Conn) *ChecksumConn {
+	return &ChecksumConn{conn: c}
+}
+
+func checksum(v []byte) []byte {
+	b := make([]byte, checksumSize+len(v))
+	binary.BigEndian.PutUint32(b, crc32.ChecksumIEEE(v))
+	copy(b[checksumSize:], v)
+	return b
+}
+
+// Close closes the wrapped Conn
+func (cc *ChecksumConn) Close() error {
+	return cc.conn.Close()
+}
+
+// Ping checks the wrapped Conn, succeeding trivially if it doesn't implement Pinger
+func (cc *ChecksumConn) Ping() error {
+	return ping(cc.conn)
+}
+
+// Write stores v with its checksum
+func (cc *ChecksumConn) Write(k, v []byte) error {
+	return cc.conn.Write(k, checksum(v))
+}
+
+// WriteTTL stores v with its checksum and an explicit TTL
+func (cc *ChecksumConn) WriteTTL(k, v []byte, ttl time.Duration) error {
+	return cc.conn.WriteTTL(k, checksum(v), ttl)
+}
+
+// Read reads and verifies a value. A corrupted entry is evicted when the wrapped Conn
+// implements Deleter, and ErrChecksumMismatch is returned
+func (cc *ChecksumConn) Read(k []byte) ([]byte, error) {
+	b, err := cc.conn.Read(k)
+	if err != nil {
+		return b, err
+	}
+
+	if len(b) < checksumSize || binary.BigEndian.Uint32(b) != crc32.ChecksumIEEE(b[checksumSize:]) {
+		if d, ok := cc.conn.(Deleter); ok {
+			d.Delete(k)
+		}
+		return nil, ErrChecksumMismatch
+	}
+
+	return b[checksumSize:], nil
+}
+
+// Delete removes a key from the wrapped Conn, returning ErrNotSupported if it doesn't implement Deleter
+func (cc *ChecksumConn) Delete(k []byte) error {
+	if d, ok := cc.conn.(Deleter); ok {
+		return d.Delete(k)
+	}
+	return ErrNotSupported
+}
+
+// Range iterates the verified values of the wrapped Conn, returning ErrNotSupported if it doesn't implement Ranger
+// Corrupted values are skipped
+func (cc *ChecksumConn) Range(fn func(k, v []byte) bool) error {
+	r, ok := cc.conn.(Ranger)
+	if !ok {
+		return ErrNotSupported
+	}
+	return r.Range(func(k, b []byte) bool {
+		if len(b) < checksumSize || binary.BigEndian.Uint32(b) != crc32.ChecksumIEEE(b[checksumSize:]) {
+			return true
+		}
+		return fn(k, b[checksumSize:])
+	})
+}
+
+// Stats provides stats about the wrapped Conn
+func (cc *ChecksumConn) Stats() (map[string]interface{}, error) {
+	return cc.conn.Stats()
+}
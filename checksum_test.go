@@ -0,0 +1,50 @@
+package omnicache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChecksumConn(t *testing.T) {
+	m := newMapConn()
+	oc := New(NewChecksumConn(m))
+	defer oc.Close()
+
+	key := []byte("checked")
+	v := []byte{1, 2, 3}
+	err := oc.SetWithTTL(key, v, time.Minute)
+	assert.Nil(t, err)
+
+	// intact read
+	b, err := oc.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, v, b)
+
+	// flip a bit in the stored payload behind the checksum's back
+	raw, err := m.Read(key)
+	assert.Nil(t, err)
+	raw[len(raw)-1] ^= 0x01
+
+	_, err = oc.Get(key)
+	assert.Equal(t, ErrChecksumMismatch, err)
+
+	// the corrupted entry was evicted
+	_, err = m.Read(key)
+	assert.Errorf(t, err, "Key not found")
+}
+
+func TestChecksumConnShortValue(t *testing.T) {
+	c := createConn()
+	cc := NewChecksumConn(c)
+	defer cc.Close()
+
+	// a value too short to hold a checksum is corrupt
+	c.Write([]byte("short"), []byte{1})
+	_, err := cc.Read([]byte("short"))
+	assert.Equal(t, ErrChecksumMismatch, err)
+
+	// nothing to evict with, but the mismatch is still reported
+	assert.Equal(t, ErrNotSupported, cc.Delete([]byte("short")))
+}
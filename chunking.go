@@ -0,0 +1,204 @@
+package omnicache
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"strconv"
+	"time"
+
+	"github.com/panoplymedia/cache"
+)
+
+// ChunkingConn is a cache.Conn middleware that transparently splits values larger than a chunk size
+// across several entries, for backends with a per-entry size limit. A large value for key is stored
+// as chunks under keys starting with chunkPrefix, followed by a manifest under key itself. Keys
+// starting with chunkPrefix are reserved
+type ChunkingConn struct {
+	conn      cache.Conn
+	chunkSize int
+}
+
+// Stored values start with a frame byte telling inline values from manifests
+const (
+	frameInline   = 0
+	frameManifest = 1
+)
+
+// chunkPrefix starts the key of every chunk, keeping chunks apart from the keys of values
+const chunkPrefix = "\x00chunk\x00"
+
+// NewChunkingConn wraps c, splitting values larger than chunkSize bytes
+// A chunkSize below 1 is raised to 1
+func NewChunkingConn(c cache.Conn, chunkSize int) *ChunkingConn {
+	if chunkSize < 1 {
+		chunkSize = 1
+	}
+	return &ChunkingConn{conn: c, chunkSize: chunkSize}
+}
+
+// chunkKey returns the key of chunk i of k, "\x00chunk\x00<i>\x00<k>"
+func chunkKey(k []byte, i int) []byte {
+	b := strconv.AppendInt(append(make([]byte, 0, len(chunkPrefix)+len(k)+4), chunkPrefix...), int64(i), 10)
+	return append(append(b, 0), k...)
+}
+
+// Close closes the wrapped Conn
+func (cc *ChunkingConn) Close() error {
+	return cc.conn.Close()
+}
+
+// Ping checks the wrapped Conn, succeeding trivially if it doesn't implement Pinger
+func (cc *ChunkingConn) Ping() error {
+	return ping(cc.conn)
+}
+
+// Write stores v with the wrapped Conn's default TTL, chunking it if needed
+func (cc *ChunkingConn) Write(k, v []byte) error {
+	return cc.write(k, v, func(k, v []byte) error {
+		return cc.conn.Write(k, v)
+	})
+}
+
+// WriteTTL stores v with an explicit TTL applied to every chunk, chunking it if needed
+func (cc *ChunkingConn) WriteTTL(k, v []byte, ttl time.Duration) error {
+	return cc.write(k, v, func(k, v []byte) error {
+		return cc.conn.WriteTTL(k, v, ttl)
+	})
+}
+
+// write stores chunks before the manifest so a reader never finds a manifest ahead of its chunks,
+// then deletes chunks of the value it replaced beyond the new count
+func (cc *ChunkingConn) write(k, v []byte, write func(k, v []byte) error) error {
+	old := cc.chunks(k)
+	if len(v) <= cc.chunkSize {
+		if err := write(k, append([]byte{frameInline}, v...)); err != nil {
+			return err
+		}
+		return cc.deleteChunks(k, 0, old)
+	}
+
+	n := 0
+	for off := 0; off < len(v); off += cc.chunkSize {
+		end := off + cc.chunkSize
+		if end > len(v) {
+			end = len(v)
+		}
+		if err := write(chunkKey(k, n), v[off:end]); err != nil {
+			return err
+		}
+		n++
+	}
+
+	// manifest: chunk count, total length and a checksum guarding against chunks from different writes
+	m := make([]byte, 1, 1+3*binary.MaxVarintLen64)
+	m[0] = frameManifest
+	m = appendUvarint(m, uint64(n))
+	m = appendUvarint(m, uint64(len(v)))
+	m = appendUvarint(m, uint64(crc32.ChecksumIEEE(v)))
+	if err := write(k, m); err != nil {
+		return err
+	}
+	return cc.deleteChunks(k, n, old)
+}
+
+// chunks returns the chunk count of the value stored for k, zero if it's missing or inline
+func (cc *ChunkingConn) chunks(k []byte) int {
+	b, err := cc.conn.Read(k)
+	if err != nil || len(b) == 0 || b[0] != frameManifest {
+		return 0
+	}
+	n, _, _, err := manifest(b)
+	if err != nil {
+		return 0
+	}
+	return int(n)
+}
+
+// deleteChunks deletes chunks [from, to) of k, leaving them to expire if the wrapped Conn doesn't implement Deleter
+func (cc *ChunkingConn) deleteChunks(k []byte, from, to int) error {
+	d, ok := cc.conn.(Deleter)
+	if !ok {
+		return nil
+	}
+	for i := from; i < to; i++ {
+		if err := d.Delete(chunkKey(k, i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func appendUvarint(b []byte, x uint64) []byte {
+	var n [binary.MaxVarintLen64]byte
+	return append(b, n[:binary.PutUvarint(n[:], x)]...)
+}
+
+// manifest parses a manifest frame into its chunk count, total length and checksum
+func manifest(b []byte) (n, size, sum uint64, err error) {
+	vals := make([]uint64, 3)
+	b = b[1:]
+	for i := range vals {
+		x, sz := binary.Uvarint(b)
+		if sz <= 0 {
+			return 0, 0, 0, ErrInvalidEntry
+		}
+		vals[i] = x
+		b = b[sz:]
+	}
+	return vals[0], vals[1], vals[2], nil
+}
+
+// Read reads a value, reassembling it from its chunks
+// If any chunk is missing or expired the error reading it is returned, so the value reads as a miss
+// A manifest claiming more than its chunks can hold at the chunk size is rejected with ErrInvalidEntry
+func (cc *ChunkingConn) Read(k []byte) ([]byte, error) {
+	b, err := cc.conn.Read(k)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, ErrInvalidEntry
+	}
+	if b[0] == frameInline {
+		return b[1:], nil
+	}
+
+	n, size, sum, err := manifest(b)
+	if err != nil {
+		return nil, err
+	}
+	// every chunk holds between 1 and chunkSize bytes, so size can be trusted to preallocate
+	if n == 0 || n > size || (size-1)/uint64(cc.chunkSize)+1 > n {
+		return nil, ErrInvalidEntry
+	}
+	v := make([]byte, 0, size)
+	for i := 0; i < int(n); i++ {
+		c, err := cc.conn.Read(chunkKey(k, i))
+		if err != nil {
+			return nil, err
+		}
+		v = append(v, c...)
+	}
+	if uint64(len(v)) != size || uint64(crc32.ChecksumIEEE(v)) != sum {
+		return nil, ErrChecksumMismatch
+	}
+	return v, nil
+}
+
+// Delete removes a value and its chunks, returning ErrNotSupported if the wrapped Conn doesn't implement Deleter
+func (cc *ChunkingConn) Delete(k []byte) error {
+	d, ok := cc.conn.(Deleter)
+	if !ok {
+		return ErrNotSupported
+	}
+
+	if err := cc.deleteChunks(k, 0, cc.chunks(k)); err != nil {
+		return err
+	}
+	return d.Delete(k)
+}
+
+// Stats provides stats about the wrapped Conn, where each chunk counts as a key
+func (cc *ChunkingConn) Stats() (map[string]interface{}, error) {
+	return cc.conn.Stats()
+}
@@ -0,0 +1,121 @@
+package omnicache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChunkingConn(t *testing.T) {
+	m := newMapConn()
+	oc := New(NewChunkingConn(m, 4))
+	defer oc.Close()
+
+	// small values are stored inline
+	err := oc.SetWithTTL([]byte("small"), []byte{1, 2}, time.Minute)
+	assert.Nil(t, err)
+	b, err := oc.Get([]byte("small"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{1, 2}, b)
+
+	// large values are split across chunks
+	v := []byte("0123456789")
+	err = oc.SetWithTTL([]byte("big"), v, time.Minute)
+	assert.Nil(t, err)
+	for i := 0; i < 3; i++ {
+		_, err = m.Read(chunkKey([]byte("big"), i))
+		assert.Nil(t, err, i)
+	}
+	b, err = oc.Get([]byte("big"))
+	assert.Nil(t, err)
+	assert.Equal(t, v, b)
+
+	// a missing chunk makes the whole value a miss
+	m.Delete(chunkKey([]byte("big"), 1))
+	_, err = oc.Get([]byte("big"))
+	assert.Errorf(t, err, "Key not found")
+}
+
+func TestChunkingConnTTL(t *testing.T) {
+	m, clock := newFakeMapConn()
+	cc := NewChunkingConn(m, 4)
+	defer cc.Close()
+
+	err := cc.WriteTTL([]byte("big"), []byte("0123456789"), 20*time.Millisecond)
+	assert.Nil(t, err)
+	clock.Advance(30 * time.Millisecond)
+
+	// every chunk expired with the value
+	_, err = m.Read(chunkKey([]byte("big"), 0))
+	assert.Errorf(t, err, "Key not found")
+	_, err = cc.Read([]byte("big"))
+	assert.Errorf(t, err, "Key not found")
+}
+
+func TestChunkingConnDelete(t *testing.T) {
+	m := newMapConn()
+	cc := NewChunkingConn(m, 4)
+	defer cc.Close()
+
+	cc.Write([]byte("big"), []byte("0123456789"))
+	err := cc.Delete([]byte("big"))
+	assert.Nil(t, err)
+
+	s, err := m.Stats()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), s["KeyCount"])
+}
+
+func TestChunkingConnOverwrite(t *testing.T) {
+	m := newMapConn()
+	cc := NewChunkingConn(m, 4)
+	defer cc.Close()
+
+	// shrinking a value deletes the chunks it no longer uses
+	cc.Write([]byte("big"), []byte("0123456789"))
+	cc.Write([]byte("big"), []byte("012345"))
+	s, _ := m.Stats()
+	assert.Equal(t, uint64(3), s["KeyCount"])
+	cc.Write([]byte("big"), []byte("01"))
+	s, _ = m.Stats()
+	assert.Equal(t, uint64(1), s["KeyCount"])
+	b, err := cc.Read([]byte("big"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("01"), b)
+
+	// chunks don't collide with user keys that look like them
+	cc.Write([]byte("big#0"), []byte("mine"))
+	cc.Write([]byte("big"), []byte("0123456789"))
+	b, err = cc.Read([]byte("big#0"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("mine"), b)
+}
+
+func TestChunkingConnChunkSize(t *testing.T) {
+	cc := NewChunkingConn(newMapConn(), 0)
+	defer cc.Close()
+
+	// a non-positive chunk size is clamped rather than looping forever
+	assert.Nil(t, cc.Write([]byte("k"), []byte("abc")))
+	b, err := cc.Read([]byte("k"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("abc"), b)
+}
+
+func TestChunkingConnBadManifest(t *testing.T) {
+	m := newMapConn()
+	cc := NewChunkingConn(m, 4)
+	defer cc.Close()
+
+	for _, vals := range [][3]uint64{{0, 0, 0}, {2, 1, 0}, {1, 1 << 40, 0}} {
+		b := []byte{frameManifest}
+		for _, x := range vals {
+			b = appendUvarint(b, x)
+		}
+		m.Write([]byte("k"), b)
+		_, err := cc.Read([]byte("k"))
+		assert.Equal(t, ErrInvalidEntry, err, fmt.Sprint(vals))
+	}
+}
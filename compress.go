@@ -0,0 +1,161 @@
+package omnicache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"sync/atomic"
+	"time"
+
+	"github.com/panoplymedia/cache"
+)
+
+// CompressionConn is a cache.Conn middleware that gzips values of at least a threshold size before
+// storing them and transparently decompresses them on read. Every stored value starts with a flag
+// byte saying whether it's compressed, so entries written under different thresholds coexist
+type CompressionConn struct {
+	conn      cache.Conn
+	threshold int
+
+	rawBytes    uint64
+	storedBytes uint64
+}
+
+// Stored values start with a flag byte telling raw values from compressed ones
+const (
+	compressNone = 0
+	compressGzip = 1
+)
+
+// NewCompressionConn wraps c, compressing values of at least threshold bytes
+func NewCompressionConn(c cache.Conn, threshold int) *CompressionConn {
+	return &CompressionConn{conn: c, threshold: threshold}
+}
+
+// Close closes the wrapped Conn
+func (cc *CompressionConn) Close() error {
+	return cc.conn.Close()
+}
+
+// Ping checks the wrapped Conn, succeeding trivially if it doesn't implement Pinger
+func (cc *CompressionConn) Ping() error {
+	return ping(cc.conn)
+}
+
+// Write stores v with the wrapped Conn's default TTL, compressing it if it's large enough
+func (cc *CompressionConn) Write(k, v []byte) error {
+	b, err := cc.encode(v)
+	if err != nil {
+		return err
+	}
+	return cc.conn.Write(k, b)
+}
+
+// WriteTTL stores v with an explicit TTL, compressing it if it's large enough
+func (cc *CompressionConn) WriteTTL(k, v []byte, ttl time.Duration) error {
+	b, err := cc.encode(v)
+	if err != nil {
+		return err
+	}
+	return cc.conn.WriteTTL(k, b, ttl)
+}
+
+// encode compresses v if it's at least the threshold and that saves space, flagging the result
+func (cc *CompressionConn) encode(v []byte) ([]byte, error) {
+	b := append([]byte{compressNone}, v...)
+	if len(v) >= cc.threshold {
+		var buf bytes.Buffer
+		buf.WriteByte(compressGzip)
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(v); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		if buf.Len() < len(b) {
+			b = buf.Bytes()
+		}
+	}
+
+	atomic.AddUint64(&cc.rawBytes, uint64(len(v)))
+	atomic.AddUint64(&cc.storedBytes, uint64(len(b)))
+	return b, nil
+}
+
+func decompress(b []byte) ([]byte, error) {
+	if len(b) == 0 {
+		return nil, ErrInvalidEntry
+	}
+	switch b[0] {
+	case compressNone:
+		return b[1:], nil
+	case compressGzip:
+		zr, err := gzip.NewReader(bytes.NewReader(b[1:]))
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.ReadAll(zr)
+	}
+	return nil, ErrInvalidEntry
+}
+
+// Read reads k from the wrapped Conn, decompressing it if needed
+func (cc *CompressionConn) Read(k []byte) ([]byte, error) {
+	b, err := cc.conn.Read(k)
+	if err != nil {
+		return nil, err
+	}
+	return decompress(b)
+}
+
+// Delete removes k from the wrapped Conn, returning ErrNotSupported if it doesn't implement Deleter
+func (cc *CompressionConn) Delete(k []byte) error {
+	if d, ok := cc.conn.(Deleter); ok {
+		return d.Delete(k)
+	}
+	return ErrNotSupported
+}
+
+// Flush flushes the wrapped Conn, returning ErrNotSupported if it doesn't implement Flusher
+func (cc *CompressionConn) Flush() error {
+	if f, ok := cc.conn.(Flusher); ok {
+		return f.Flush()
+	}
+	return ErrNotSupported
+}
+
+// Range iterates the decompressed values of the wrapped Conn, returning ErrNotSupported if it doesn't implement Ranger
+// Values that fail to decompress are skipped
+func (cc *CompressionConn) Range(fn func(k, v []byte) bool) error {
+	r, ok := cc.conn.(Ranger)
+	if !ok {
+		return ErrNotSupported
+	}
+	return r.Range(func(k, b []byte) bool {
+		v, err := decompress(b)
+		if err != nil {
+			return true
+		}
+		return fn(k, v)
+	})
+}
+
+// Stats provides stats about the wrapped Conn, adding "RawBytesWritten" and "StoredBytesWritten",
+// the total bytes of values written before and after compression, to show the savings
+func (cc *CompressionConn) Stats() (map[string]interface{}, error) {
+	s, err := cc.conn.Stats()
+	if err != nil {
+		return s, err
+	}
+	s["RawBytesWritten"] = atomic.LoadUint64(&cc.rawBytes)
+	s["StoredBytesWritten"] = atomic.LoadUint64(&cc.storedBytes)
+	return s, nil
+}
+
+// ResetStats zeroes the byte totals and resets the wrapped Conn's counters if it implements StatsResetter
+func (cc *CompressionConn) ResetStats() error {
+	atomic.StoreUint64(&cc.rawBytes, 0)
+	atomic.StoreUint64(&cc.storedBytes, 0)
+	return resetStats(cc.conn)
+}
@@ -0,0 +1,43 @@
+package omnicache
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompressionConn(t *testing.T) {
+	m := newMapConn()
+	cc := NewCompressionConn(m, 64)
+	defer cc.Close()
+
+	doc := bytes.Repeat([]byte(`{"name":"value"},`), 100)
+	assert.Nil(t, cc.Write([]byte("doc"), doc))
+	assert.Nil(t, cc.Write([]byte("small"), []byte("tiny")))
+
+	// read back transparently
+	b, err := cc.Read([]byte("doc"))
+	assert.Nil(t, err)
+	assert.Equal(t, doc, b)
+	b, err = cc.Read([]byte("small"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("tiny"), b)
+
+	// only the large value is stored compressed
+	raw, _ := m.Read([]byte("doc"))
+	assert.Equal(t, byte(compressGzip), raw[0])
+	assert.True(t, len(raw) < len(doc))
+	raw, _ = m.Read([]byte("small"))
+	assert.Equal(t, []byte("\x00tiny"), raw)
+
+	// entries written under another threshold still read
+	b, err = NewCompressionConn(m, 1<<20).Read([]byte("doc"))
+	assert.Nil(t, err)
+	assert.Equal(t, doc, b)
+
+	s, err := cc.Stats()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(len(doc)+4), s["RawBytesWritten"])
+	assert.True(t, s["StoredBytesWritten"].(uint64) < s["RawBytesWritten"].(uint64))
+}
@@ -0,0 +1,80 @@
+package omnicache
+
+import "time"
+
+// CacheConfig is a snapshot of an OmniCache's effective configuration
+// Options taking a function or an implementation, WithClock, WithOnEvict, WithSerializer, WithShardFunc,
+// WithWriteValidator and the BackfillCache of WithRefreshOnExpire, have no counterpart
+type CacheConfig struct {
+	BackfillTimeout time.Duration
+	DefaultTTL      time.Duration
+	MinTTL          time.Duration
+	MinTTLPolicy    MinTTLPolicy
+	MaxTTL          time.Duration
+	NoExpiryPolicy  NoExpiryPolicy
+	TTLJitter       float64
+	SnapshotTTLMode TTLMode
+	MaxValueBytes   int
+	CacheEmpty      bool
+	NegativeCache   bool
+	NegativeTTL     time.Duration
+	NegativeMaxKeys int
+
+	MaxConcurrentBackfills int
+	BackfillDebounce       time.Duration
+	// RefreshOnExpire is the WithRefreshOnExpire concurrency, zero when it's off or the Conn isn't an ExpireHooker
+	RefreshOnExpire int
+	// SweepInterval is zero when sweeping is off or the Conn isn't a Compacter
+	SweepInterval time.Duration
+	PeakTracking  bool
+
+	HotKeys         bool
+	HotKeyMaxWrites uint32
+	HotKeyWindow    time.Duration
+	HotKeyThrottle  bool
+
+	// Backend holds the Conn's own configuration when it implements Configurer
+	Backend map[string]interface{}
+}
+
+// Config returns the configuration the OmniCache is running with, resolved from its options and defaults
+func (oc *OmniCache) Config() CacheConfig {
+	c := CacheConfig{
+		BackfillTimeout: oc.backfillTimeout,
+		DefaultTTL:      oc.defaultTTL,
+		MinTTL:          oc.minTTL,
+		MinTTLPolicy:    oc.minTTLPolicy,
+		MaxTTL:          oc.maxTTL,
+		NoExpiryPolicy:  oc.noExpiryPolicy,
+		TTLJitter:       oc.ttlJitter,
+		SnapshotTTLMode: oc.snapshotMode,
+		MaxValueBytes:   oc.maxValueBytes,
+		CacheEmpty:      oc.cacheEmpty,
+	}
+	c.MaxConcurrentBackfills = cap(oc.backfillSlots)
+	if oc.debounce != nil {
+		c.BackfillDebounce = oc.debounce.window
+	}
+	if _, ok := oc.Conn.(ExpireHooker); ok && oc.expire != nil {
+		c.RefreshOnExpire = cap(oc.expire.sem)
+	}
+	if oc.sweep != nil {
+		c.SweepInterval = oc.sweep.interval
+	}
+	c.PeakTracking = oc.peaks != nil
+	if oc.hot != nil {
+		c.HotKeys = true
+		c.HotKeyMaxWrites = oc.hot.max
+		c.HotKeyWindow = oc.hot.window
+		c.HotKeyThrottle = oc.hot.throttle
+	}
+	if oc.negative != nil {
+		c.NegativeCache = true
+		c.NegativeTTL = oc.negative.ttl
+		c.NegativeMaxKeys = oc.negative.max
+	}
+	if cf, ok := oc.Conn.(Configurer); ok {
+		c.Backend = cf.Config()
+	}
+	return c
+}
@@ -0,0 +1,76 @@
+package omnicache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// configConn reports a fixed backend configuration
+type configConn struct {
+	*mapConn
+}
+
+func (configConn) Config() map[string]interface{} {
+	return map[string]interface{}{"Shards": 256}
+}
+
+func TestConfig(t *testing.T) {
+	oc := New(configConn{newMapConn()},
+		WithDefaultBackfillTimeout(time.Second),
+		WithDefaultTTL(time.Hour),
+		WithMinTTL(time.Millisecond, ClampTTL),
+		WithNegativeCache(time.Minute, 100),
+		WithMaxValueBytes(1024),
+	)
+	defer oc.Close()
+
+	assert.Equal(t, CacheConfig{
+		BackfillTimeout: time.Second,
+		DefaultTTL:      time.Hour,
+		MinTTL:          time.Millisecond,
+		MinTTLPolicy:    ClampTTL,
+		SnapshotTTLMode: RelativeTTL,
+		MaxValueBytes:   1024,
+		NegativeCache:   true,
+		NegativeTTL:     time.Minute,
+		NegativeMaxKeys: 100,
+		Backend:         map[string]interface{}{"Shards": 256},
+	}, oc.Config())
+}
+
+func TestConfigDefaults(t *testing.T) {
+	oc := New(createConn())
+	defer oc.Close()
+
+	assert.Equal(t, CacheConfig{MinTTLPolicy: RejectTTL, SnapshotTTLMode: RelativeTTL}, oc.Config())
+}
+
+func TestConfigOptions(t *testing.T) {
+	oc := New(&janitorConn{mapConn: newMapConn()},
+		WithBackfillDebounce(time.Second),
+		WithRefreshOnExpire(notFoundBackfill{calls: new(int)}, 4),
+		WithSweepInterval(time.Hour),
+		WithPeakTracking(),
+		WithHotKeys(100, time.Minute, true),
+	)
+	defer oc.Close()
+
+	c := oc.Config()
+	assert.Equal(t, time.Second, c.BackfillDebounce)
+	assert.Equal(t, 4, c.RefreshOnExpire)
+	assert.Equal(t, time.Hour, c.SweepInterval)
+	assert.True(t, c.PeakTracking)
+	assert.True(t, c.HotKeys)
+	assert.Equal(t, uint32(100), c.HotKeyMaxWrites)
+	assert.Equal(t, time.Minute, c.HotKeyWindow)
+	assert.True(t, c.HotKeyThrottle)
+
+	// only in effect where the Conn supports them
+	oc = New(createConn(), WithRefreshOnExpire(notFoundBackfill{calls: new(int)}, 4), WithSweepInterval(time.Hour))
+	defer oc.Close()
+	c = oc.Config()
+	assert.Equal(t, 0, c.RefreshOnExpire)
+	assert.Equal(t, time.Duration(0), c.SweepInterval)
+}
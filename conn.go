@@ -0,0 +1,146 @@
+package omnicache
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrNotSupported is returned when an operation needs an optional capability the cache.Conn doesn't implement
+var ErrNotSupported = errors.New("omnicache: operation not supported by conn")
+
+// Deleter is implemented by a cache.Conn that can remove keys
+type Deleter interface {
+	Delete(k []byte) error
+}
+
+// Flusher is implemented by a cache.Conn that can remove all keys
+type Flusher interface {
+	Flush() error
+}
+
+// Ranger is implemented by a cache.Conn that can iterate its live entries
+// Range calls fn for each entry until fn returns false
+type Ranger interface {
+	Range(fn func(k, v []byte) bool) error
+}
+
+// Compacter is implemented by a cache.Conn that can release memory held by deleted
+// or expired entries, e.g. by rebuilding oversized maps from their live entries
+type Compacter interface {
+	Compact() error
+}
+
+// Configurer is implemented by a cache.Conn that can report its configuration
+// (shard count, capacity limits, default TTL, ...)
+type Configurer interface {
+	Config() map[string]interface{}
+}
+
+// ExpireHooker is implemented by a cache.Conn whose janitor can hand expired entries to a hook
+// before reaping them. The janitor keeps an entry for which the hook returns true rather than deleting it
+type ExpireHooker interface {
+	SetExpireHook(hook func(k []byte) bool)
+}
+
+// ClockSetter is implemented by a cache.Conn that stamps times on entries (e.g. creation and expiry) and can
+// take them from the OmniCache's WithClock clock, so the OmniCache compares them against the same clock
+type ClockSetter interface {
+	SetClock(now func() time.Time)
+}
+
+// InvariantChecker is implemented by a cache.Conn that can verify its internal bookkeeping
+// (counters, indexes, ...), returning a descriptive error for the first inconsistency found
+type InvariantChecker interface {
+	CheckInvariants() error
+}
+
+// ReadWriter is implemented by a cache.Conn that can replace a value and return the previous one
+// in a single atomic step, reporting whether the key existed
+type ReadWriter interface {
+	ReadWrite(k, v []byte, ttl time.Duration) (old []byte, existed bool, err error)
+}
+
+// Updater is implemented by a cache.Conn that can call fn with the current value of a key (found false if it's
+// missing or expired) and store what fn returns with ttl under its own lock, writing nothing when fn returns an error
+type Updater interface {
+	Update(k []byte, fn func(current []byte, found bool) ([]byte, error), ttl time.Duration) error
+}
+
+// KeepTTLWriter is implemented by a cache.Conn that can replace the value of a live key while keeping its expiry
+// in a single step, reporting false without writing if the key is missing or expired
+type KeepTTLWriter interface {
+	WriteKeepTTL(k, v []byte) (bool, error)
+}
+
+// Toucher is implemented by a cache.Conn that can change the TTL of an entry without rewriting its value
+type Toucher interface {
+	Touch(k []byte, ttl time.Duration) error
+}
+
+// Exister is implemented by a cache.Conn that can check a key is live without copying its value
+type Exister interface {
+	Exists(k []byte) bool
+}
+
+// MultiReader is implemented by a cache.Conn that can read a batch of keys at once
+// Missing and expired keys are absent from the result
+type MultiReader interface {
+	ReadMulti(keys [][]byte) map[string][]byte
+}
+
+// TTLReader is implemented by a cache.Conn that can report the time remaining before a key expires
+// It returns NoExpiry for a key that never expires and false for a missing or expired key
+type TTLReader interface {
+	TTL(k []byte) (time.Duration, bool)
+}
+
+// ValueTTLReader is implemented by a cache.Conn that can read a value and the time remaining before it expires
+// in a single step, as TTLReader reports it
+type ValueTTLReader interface {
+	ReadWithTTL(k []byte) ([]byte, time.Duration, bool)
+}
+
+// MultiWriter is implemented by a cache.Conn that can write a batch of entries at once with a shared TTL
+// (e.g. taking each shard's lock once)
+type MultiWriter interface {
+	WriteMulti(entries map[string][]byte, ttl time.Duration) error
+}
+
+// AbsentWriter is implemented by a cache.Conn that can write a value only if the key is missing or expired
+// in a single step, reporting whether it was written
+type AbsentWriter interface {
+	WriteIfAbsent(k, v []byte, ttl time.Duration) (bool, error)
+}
+
+// CompareDeleter is implemented by a cache.Conn that can delete a key only if it holds an expected value
+// in a single step, reporting whether it was deleted
+type CompareDeleter interface {
+	CompareAndDelete(k, expected []byte) (bool, error)
+}
+
+// PrefixDeleter is implemented by a cache.Conn that can remove every key with a prefix itself, reporting how many it removed
+type PrefixDeleter interface {
+	DeletePrefix(prefix []byte) (int, error)
+}
+
+// ShardStater is implemented by a cache.Conn that spreads keys across shards and can count the keys held by each
+type ShardStater interface {
+	ShardKeyCounts() ([]uint64, error)
+}
+
+// Pinger is implemented by a cache.Conn that can cheaply check its connection is healthy (e.g. a network round trip)
+type Pinger interface {
+	Ping() error
+}
+
+// StatsResetter is implemented by a cache.Conn that can zero its counters (e.g. evictions) without touching its data
+type StatsResetter interface {
+	ResetStats() error
+}
+
+// EvictNotifier is implemented by a cache.Conn that removes entries on its own (expiry, capacity eviction)
+// and can report them to a hook, passing the value if it has it. The hook may be called with the Conn's
+// locks held, so it must not block or call back into the Conn
+type EvictNotifier interface {
+	SetEvictHook(hook func(k, v []byte, reason EvictReason))
+}
@@ -0,0 +1,234 @@
+package omnicache
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// mapConn is a minimal in-memory cache.Conn that, unlike the memorystore Conn,
+// implements the optional Conn interfaces
+type mapConn struct {
+	mu  sync.Mutex
+	dat map[string]mapEntry
+
+	compactions  int
+	batches      int
+	writeBatches int
+	clock        Clock
+}
+
+type mapEntry struct {
+	dat       []byte
+	expiresAt time.Time
+}
+
+func newMapConn() *mapConn {
+	return &mapConn{dat: map[string]mapEntry{}}
+}
+
+// newFakeMapConn creates a mapConn that expires entries by a fakeClock
+func newFakeMapConn() (*mapConn, *fakeClock) {
+	clock := &fakeClock{t: time.Unix(1500000000, 0)}
+	return &mapConn{dat: map[string]mapEntry{}, clock: clock}, clock
+}
+
+func (m *mapConn) now() time.Time {
+	if m.clock != nil {
+		return m.clock.Now()
+	}
+	return time.Now()
+}
+
+// fakeClock is a Clock that only moves when advanced
+type fakeClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = c.t.Add(d)
+}
+
+func (m *mapConn) Close() error {
+	return nil
+}
+
+func (m *mapConn) Write(k, v []byte) error {
+	return m.WriteTTL(k, v, 0)
+}
+
+func (m *mapConn) WriteTTL(k, v []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := mapEntry{dat: v}
+	if ttl > 0 {
+		e.expiresAt = m.now().Add(ttl)
+	}
+	m.dat[string(k)] = e
+	return nil
+}
+
+func (m *mapConn) Read(k []byte) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.dat[string(k)]
+	if !ok || m.expired(e) {
+		return nil, errors.New("Key not found")
+	}
+	return e.dat, nil
+}
+
+func (m *mapConn) expired(e mapEntry) bool {
+	return !e.expiresAt.IsZero() && m.now().After(e.expiresAt)
+}
+
+func (m *mapConn) Exists(k []byte) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.dat[string(k)]
+	return ok && !m.expired(e)
+}
+
+// ReadMulti reads keys under a single lock, counting batches
+func (m *mapConn) ReadMulti(keys [][]byte) map[string][]byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.batches++
+	ret := map[string][]byte{}
+	for _, k := range keys {
+		if e, ok := m.dat[string(k)]; ok && !m.expired(e) {
+			ret[string(k)] = e.dat
+		}
+	}
+	return ret
+}
+
+// WriteMulti writes entries under a single lock, counting batches
+func (m *mapConn) WriteMulti(entries map[string][]byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.writeBatches++
+	for k, v := range entries {
+		e := mapEntry{dat: v}
+		if ttl > 0 {
+			e.expiresAt = m.now().Add(ttl)
+		}
+		m.dat[k] = e
+	}
+	return nil
+}
+
+func (m *mapConn) TTL(k []byte) (time.Duration, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.dat[string(k)]
+	if !ok || m.expired(e) {
+		return 0, false
+	}
+	if e.expiresAt.IsZero() {
+		return NoExpiry, true
+	}
+	return e.expiresAt.Sub(m.now()), true
+}
+
+func (m *mapConn) ReadWithTTL(k []byte) ([]byte, time.Duration, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.dat[string(k)]
+	if !ok || m.expired(e) {
+		return nil, 0, false
+	}
+	if e.expiresAt.IsZero() {
+		return e.dat, NoExpiry, true
+	}
+	return e.dat, e.expiresAt.Sub(m.now()), true
+}
+
+func (m *mapConn) WriteKeepTTL(k, v []byte) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.dat[string(k)]
+	if !ok || m.expired(e) {
+		return false, nil
+	}
+	e.dat = v
+	m.dat[string(k)] = e
+	return true, nil
+}
+
+func (m *mapConn) Touch(k []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.dat[string(k)]
+	if !ok || m.expired(e) {
+		return errors.New("Key not found")
+	}
+	e.expiresAt = time.Time{}
+	if ttl > 0 {
+		e.expiresAt = m.now().Add(ttl)
+	}
+	m.dat[string(k)] = e
+	return nil
+}
+
+func (m *mapConn) Delete(k []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.dat, string(k))
+	return nil
+}
+
+func (m *mapConn) Range(fn func(k, v []byte) bool) error {
+	m.mu.Lock()
+	entries := make(map[string]mapEntry, len(m.dat))
+	for k, e := range m.dat {
+		if !m.expired(e) {
+			entries[k] = e
+		}
+	}
+	m.mu.Unlock()
+
+	for k, e := range entries {
+		if !fn([]byte(k), e.dat) {
+			break
+		}
+	}
+	return nil
+}
+
+// Compact rebuilds the map from its live entries
+func (m *mapConn) Compact() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	dat := make(map[string]mapEntry, len(m.dat))
+	for k, e := range m.dat {
+		if !m.expired(e) {
+			dat[k] = e
+		}
+	}
+	m.dat = dat
+	m.compactions++
+	return nil
+}
+
+func (m *mapConn) Flush() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.dat = map[string]mapEntry{}
+	return nil
+}
+
+func (m *mapConn) Stats() (map[string]interface{}, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return map[string]interface{}{"KeyCount": uint64(len(m.dat))}, nil
+}
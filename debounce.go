@@ -0,0 +1,94 @@
+package omnicache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// debouncer remembers backfill results for a short window after they complete
+type debouncer struct {
+	window time.Duration
+
+	mu    sync.Mutex
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+type debounced struct {
+	key  string
+	val  []byte
+	done time.Time
+}
+
+func newDebouncer(window time.Duration) *debouncer {
+	return &debouncer{window: window, ll: list.New(), items: map[string]*list.Element{}}
+}
+
+// get returns a copy of the result of a backfill of key completed within the window before now
+func (d *debouncer) get(key string, now time.Time) ([]byte, bool) {
+	if d == nil {
+		return nil, false
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.prune(now)
+	if el, ok := d.items[key]; ok {
+		return copyBytes(el.Value.(*debounced).val), true
+	}
+	return nil, false
+}
+
+// put records a copy of the result of a backfill of key completed at now
+func (d *debouncer) put(key string, val []byte, now time.Time) {
+	if d == nil {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if el, ok := d.items[key]; ok {
+		d.ll.Remove(el)
+	}
+	d.items[key] = d.ll.PushBack(&debounced{key: key, val: copyBytes(val), done: now})
+	d.prune(now)
+}
+
+// forget drops the result for key, e.g. once a new value is written for it
+func (d *debouncer) forget(key string) {
+	if d == nil {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if el, ok := d.items[key]; ok {
+		d.ll.Remove(el)
+		delete(d.items, key)
+	}
+}
+
+// clear drops every result
+func (d *debouncer) clear() {
+	if d == nil {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.ll.Init()
+	d.items = map[string]*list.Element{}
+}
+
+// prune drops results older than the window, which are kept in completion order
+func (d *debouncer) prune(now time.Time) {
+	for el := d.ll.Front(); el != nil; el = d.ll.Front() {
+		r := el.Value.(*debounced)
+		if now.Sub(r.done) < d.window {
+			return
+		}
+		d.ll.Remove(el)
+		delete(d.items, r.key)
+	}
+}
@@ -0,0 +1,90 @@
+package omnicache
+
+import "sync/atomic"
+
+// EvictReason tells why an entry left the cache
+type EvictReason int
+
+const (
+	// EvictExpired is an entry reaped after its TTL
+	EvictExpired EvictReason = iota
+	// EvictCapacity is an entry evicted to make room, e.g. by an LRUConn
+	EvictCapacity
+	// EvictDeleted is an entry removed explicitly, e.g. with Delete
+	EvictDeleted
+)
+
+func (r EvictReason) String() string {
+	switch r {
+	case EvictExpired:
+		return "expired"
+	case EvictCapacity:
+		return "capacity"
+	case EvictDeleted:
+		return "deleted"
+	}
+	return "unknown"
+}
+
+// evictBuffer is the number of eviction events queued for the WithOnEvict callback before
+// further events are dropped
+const evictBuffer = 1024
+
+// evictNotifier hands eviction events to the WithOnEvict callback on its own goroutine
+type evictNotifier struct {
+	fn      func(key string, value []byte, reason EvictReason)
+	events  chan evictEvent
+	done    chan struct{}
+	dropped uint64
+}
+
+type evictEvent struct {
+	key    string
+	value  []byte
+	reason EvictReason
+}
+
+func newEvictNotifier(fn func(key string, value []byte, reason EvictReason)) *evictNotifier {
+	return &evictNotifier{
+		fn:     fn,
+		events: make(chan evictEvent, evictBuffer),
+		done:   make(chan struct{}),
+	}
+}
+
+func (en *evictNotifier) run() {
+	for {
+		select {
+		case e := <-en.events:
+			en.fn(e.key, e.value, e.reason)
+		case <-en.done:
+			return
+		}
+	}
+}
+
+// halt stops delivering events; it must be called at most once
+func (en *evictNotifier) halt() {
+	if en != nil {
+		close(en.done)
+	}
+}
+
+// notify queues an event without blocking, dropping it if the queue is full
+func (en *evictNotifier) notify(k, v []byte, reason EvictReason) {
+	if en == nil {
+		return
+	}
+	select {
+	case en.events <- evictEvent{key: string(k), value: copyBytes(v), reason: reason}:
+	default:
+		atomic.AddUint64(&en.dropped, 1)
+	}
+}
+
+// stats adds the number of dropped events to s
+func (en *evictNotifier) stats(s map[string]interface{}) {
+	if en != nil {
+		s["EvictEventsDropped"] = atomic.LoadUint64(&en.dropped)
+	}
+}
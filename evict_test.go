@@ -0,0 +1,88 @@
+package omnicache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type evictEventLog chan evictEvent
+
+func (l evictEventLog) onEvict(key string, value []byte, reason EvictReason) {
+	l <- evictEvent{key: key, value: value, reason: reason}
+}
+
+func (l evictEventLog) next(t *testing.T) evictEvent {
+	select {
+	case e := <-l:
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("no eviction event")
+	}
+	return evictEvent{}
+}
+
+func TestOnEvict(t *testing.T) {
+	lc, _ := NewLRUConn(newMapConn(), 2)
+	events := make(evictEventLog, 10)
+	oc := New(lc, WithOnEvict(events.onEvict))
+	defer oc.Close()
+
+	// overwrites don't notify
+	oc.Set([]byte("a"), []byte("1"))
+	oc.Set([]byte("a"), []byte("2"))
+	oc.Set([]byte("b"), []byte("3"))
+
+	// capacity eviction
+	oc.Set([]byte("c"), []byte("4"))
+	assert.Equal(t, evictEvent{key: "a", value: []byte("2"), reason: EvictCapacity}, events.next(t))
+
+	// manual delete, not for missing keys
+	oc.Delete([]byte("missing"))
+	oc.Delete([]byte("b"))
+	assert.Equal(t, evictEvent{key: "b", value: []byte("3"), reason: EvictDeleted}, events.next(t))
+	assert.Len(t, events, 0)
+
+	s, _ := oc.Stats()
+	assert.Equal(t, uint64(0), s["EvictEventsDropped"])
+}
+
+func TestOnEvictExpired(t *testing.T) {
+	j := &janitorConn{mapConn: newMapConn()}
+	events := make(evictEventLog, 10)
+	oc := New(j, WithRefreshOnExpire(notFoundBackfill{calls: new(int)}, 1), WithOnEvict(events.onEvict))
+	defer oc.Close()
+
+	// a failed regeneration is reported as expired
+	oc.SetWithTTL([]byte("k"), []byte{1}, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	j.reap()
+	e := events.next(t)
+	assert.Equal(t, "k", e.key)
+	assert.Equal(t, EvictExpired, e.reason)
+	assert.Equal(t, "expired", e.reason.String())
+}
+
+func TestOnEvictDeletePrefix(t *testing.T) {
+	events := make(evictEventLog, 10)
+	oc := New(newMapConn(), WithOnEvict(events.onEvict))
+	defer oc.Close()
+
+	// a namespace view deletes with its PrefixDeleter, and still notifies each key
+	view := oc.Namespace([]byte("v:"))
+	view.Set([]byte("a1"), []byte{1})
+	view.Set([]byte("b"), []byte{2})
+	oc.Set([]byte("a2"), []byte{3})
+	n, err := view.DeletePrefix([]byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, evictEvent{key: "v:a1", value: []byte{1}, reason: EvictDeleted}, events.next(t))
+
+	// as does the Ranger path
+	n, err = oc.DeletePrefix([]byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, evictEvent{key: "a2", value: []byte{3}, reason: EvictDeleted}, events.next(t))
+	assert.Len(t, events, 0)
+}
@@ -0,0 +1,35 @@
+package omnicache
+
+// expireRefresher regenerates entries handed over by the Conn's janitor
+type expireRefresher struct {
+	backfill BackfillCache
+	sem      chan struct{}
+}
+
+// onExpire queues a background regeneration of k, keeping it from being reaped meanwhile
+func (oc *OmniCache) onExpire(k []byte) bool {
+	key := string(k)
+	if !oc.refreshing.start(key) {
+		return true
+	}
+	k = []byte(key)
+	go func() {
+		defer oc.refreshing.done(key)
+		oc.expire.sem <- struct{}{}
+		defer func() { <-oc.expire.sem }()
+
+		ret, err := oc.backfill(k, oc.expire.backfill)
+		if err == nil {
+			err = oc.Set(k, ret)
+		}
+		if err != nil {
+			if d, ok := oc.Conn.(Deleter); ok {
+				defer oc.locks.lock(k).Unlock()
+				if d.Delete(k) == nil {
+					oc.evict.notify(k, nil, EvictExpired)
+				}
+			}
+		}
+	}()
+	return true
+}
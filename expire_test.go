@@ -0,0 +1,70 @@
+package omnicache
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// janitorConn is a mapConn whose reap deletes expired entries unless its expire hook keeps them
+type janitorConn struct {
+	*mapConn
+	hookMu sync.Mutex
+	hook   func(k []byte) bool
+}
+
+func (j *janitorConn) SetExpireHook(hook func(k []byte) bool) {
+	j.hookMu.Lock()
+	j.hook = hook
+	j.hookMu.Unlock()
+}
+
+func (j *janitorConn) reap() {
+	j.hookMu.Lock()
+	hook := j.hook
+	j.hookMu.Unlock()
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for k, e := range j.dat {
+		if j.expired(e) && (hook == nil || !hook([]byte(k))) {
+			delete(j.dat, k)
+		}
+	}
+}
+
+func TestRefreshOnExpire(t *testing.T) {
+	var calls int32
+	j := &janitorConn{mapConn: newMapConn()}
+	oc := New(j, WithRefreshOnExpire(slowBackfill{calls: &calls}, 2))
+	defer oc.Close()
+
+	keys := []string{"a", "b", "c", "bad"}
+	for _, k := range keys {
+		oc.SetWithTTL([]byte(k), []byte("old"), 10*time.Millisecond)
+	}
+	time.Sleep(15 * time.Millisecond)
+
+	// expired entries are kept while they regenerate, and reaping again doesn't queue them twice
+	j.reap()
+	j.reap()
+	s, _ := j.Stats()
+	assert.Equal(t, uint64(4), s["KeyCount"])
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, int32(4), atomic.LoadInt32(&calls))
+
+	for _, k := range keys[:3] {
+		b, err := oc.Get([]byte(k))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte(k), b)
+	}
+
+	// failed regeneration is deleted
+	_, err := oc.Get([]byte("bad"))
+	assert.Errorf(t, err, "Key not found")
+	s, _ = j.Stats()
+	assert.Equal(t, uint64(3), s["KeyCount"])
+}
@@ -0,0 +1,112 @@
+package omnicache
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrHotKeyThrottled is returned for writes to a key past the WithHotKeys limit under throttling
+var ErrHotKeyThrottled = errors.New("omnicache: hot key throttled")
+
+const (
+	hotKeySketchWidth = 4096
+	// hotKeyLimit bounds the number of hot keys remembered per window
+	hotKeyLimit = 128
+)
+
+// HotKey is a key written more than the WithHotKeys limit within a window
+type HotKey struct {
+	Key string
+	// Writes is the estimated number of writes to the key within the window
+	Writes uint32
+}
+
+// hotKeys counts writes per key over fixed windows
+type hotKeys struct {
+	max      uint32
+	window   time.Duration
+	throttle bool
+
+	mu     sync.Mutex
+	sketch *countMinSketch
+	start  time.Time
+	hot    map[string]uint32
+	prev   map[string]uint32
+}
+
+func newHotKeys(maxWrites uint32, window time.Duration, throttle bool) *hotKeys {
+	return &hotKeys{
+		max:      maxWrites,
+		window:   window,
+		throttle: throttle,
+		sketch:   newCountMinSketch(hotKeySketchWidth),
+		hot:      map[string]uint32{},
+	}
+}
+
+// write counts a write to key at now, returning ErrHotKeyThrottled if it should be rejected
+func (hk *hotKeys) write(key string, now time.Time) error {
+	if hk == nil {
+		return nil
+	}
+
+	hk.mu.Lock()
+	defer hk.mu.Unlock()
+	if now.Sub(hk.start) >= hk.window {
+		hk.prev, hk.hot = hk.hot, map[string]uint32{}
+		hk.sketch.reset()
+		hk.start = now
+	}
+
+	hk.sketch.add(key)
+	n := hk.sketch.estimate(key)
+	if n <= hk.max {
+		return nil
+	}
+	if _, ok := hk.hot[key]; ok || len(hk.hot) < hotKeyLimit {
+		hk.hot[key] = n
+	}
+	if hk.throttle {
+		return ErrHotKeyThrottled
+	}
+	return nil
+}
+
+// HotKeys lists the keys written more than the WithHotKeys limit in the current or previous window,
+// hottest first. It returns nil without WithHotKeys
+func (oc *OmniCache) HotKeys() []HotKey {
+	hk := oc.hot
+	if hk == nil {
+		return nil
+	}
+
+	hk.mu.Lock()
+	defer hk.mu.Unlock()
+	// a window that has passed without writes leaves nothing current
+	hot := hk.hot
+	prev := hk.prev
+	if since := oc.now().Sub(hk.start); since >= 2*hk.window {
+		hot, prev = nil, nil
+	} else if since >= hk.window {
+		hot, prev = nil, hk.hot
+	}
+
+	var ret []HotKey
+	for k, n := range hot {
+		ret = append(ret, HotKey{Key: k, Writes: n})
+	}
+	for k, n := range prev {
+		if _, ok := hot[k]; !ok {
+			ret = append(ret, HotKey{Key: k, Writes: n})
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Writes != ret[j].Writes {
+			return ret[i].Writes > ret[j].Writes
+		}
+		return ret[i].Key < ret[j].Key
+	})
+	return ret
+}
@@ -0,0 +1,52 @@
+package omnicache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHotKeys(t *testing.T) {
+	oc := New(newMapConn(), WithHotKeys(100, time.Minute, false))
+	defer oc.Close()
+
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, oc.Set([]byte("hot"), []byte{1}))
+		oc.Set([]byte(fmt.Sprintf("cold%d", i)), []byte{1})
+	}
+
+	hot := oc.HotKeys()
+	assert.Len(t, hot, 1)
+	assert.Equal(t, "hot", hot[0].Key)
+	assert.True(t, hot[0].Writes >= 500)
+
+	// without WithHotKeys
+	assert.Nil(t, New(newMapConn()).HotKeys())
+}
+
+func TestHotKeysThrottle(t *testing.T) {
+	clock := &fakeClock{t: time.Now()}
+	oc := New(newMapConn(), WithHotKeys(10, time.Second, true), WithClock(clock))
+	defer oc.Close()
+
+	// writes past the limit are rejected until the window rolls over
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, oc.SetWithTTL([]byte("hot"), []byte{byte(i)}, time.Minute))
+	}
+	err := oc.SetWithTTL([]byte("hot"), []byte{99}, time.Minute)
+	assert.Equal(t, ErrHotKeyThrottled, err)
+	b, _ := oc.Get([]byte("hot"))
+	assert.Equal(t, []byte{9}, b)
+	assert.Nil(t, oc.Set([]byte("other"), []byte{1}))
+	assert.Len(t, oc.HotKeys(), 1)
+
+	clock.Advance(time.Second)
+	assert.Nil(t, oc.Set([]byte("hot"), []byte{1}))
+	// still reported from the previous window
+	assert.Len(t, oc.HotKeys(), 1)
+
+	clock.Advance(2 * time.Second)
+	assert.Len(t, oc.HotKeys(), 0)
+}
@@ -0,0 +1,167 @@
+package omnicache
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/panoplymedia/cache"
+)
+
+// CheckInvariants verifies the OmniCache's internal bookkeeping and, if the Conn implements
+// InvariantChecker, the Conn's, returning a descriptive error for the first inconsistency found
+// When the Conn implements Ranger, the KeyCount and BytesStored it reports are checked against the
+// entries and value bytes it ranges over, so expired entries must have been swept for them to agree
+// It holds off all writes made through the OmniCache while checking, so it's meant for tests and debugging
+func (oc *OmniCache) CheckInvariants() error {
+	oc.locks.lockAll()
+	defer oc.locks.unlockAll()
+
+	if n := atomic.LoadInt64(&oc.prewarm.remaining); n < 0 {
+		return fmt.Errorf("omnicache: PrewarmRemaining is negative (%d)", n)
+	}
+	if err := oc.negative.check(); err != nil {
+		return err
+	}
+	if err := oc.checkUsage(); err != nil {
+		return err
+	}
+	if ic, ok := oc.Conn.(InvariantChecker); ok {
+		return ic.CheckInvariants()
+	}
+	return nil
+}
+
+// checkUsage compares the KeyCount and BytesStored stats of a Conn implementing Ranger with what it ranges over
+// It's skipped for Namespace views, whose stats are the shared Conn's, and Conns whose stats count envelopes
+func (oc *OmniCache) checkUsage() error {
+	r, ok := oc.Conn.(Ranger)
+	if !ok || len(oc.prefix) > 0 || !countsRanged(oc.Conn) {
+		return nil
+	}
+	var keys, bytes uint64
+	err := r.Range(func(_, v []byte) bool {
+		keys++
+		bytes += uint64(len(v))
+		return true
+	})
+	if err == ErrNotSupported {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	s, err := oc.Conn.Stats()
+	if err != nil {
+		return err
+	}
+	if n, ok := statFloat(s["KeyCount"]); ok && uint64(n) != keys {
+		return fmt.Errorf("omnicache: Conn reports a KeyCount of %d but ranges over %d keys", uint64(n), keys)
+	}
+	if n, ok := statFloat(s["BytesStored"]); ok && uint64(n) != bytes {
+		return fmt.Errorf("omnicache: Conn reports a BytesStored of %d but ranges over %d bytes", uint64(n), bytes)
+	}
+	return nil
+}
+
+// check verifies the negative cache's list and index agree and respect the cap
+func (nc *negativeCache) check() error {
+	if nc == nil {
+		return nil
+	}
+
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	if nc.ll.Len() != len(nc.items) {
+		return fmt.Errorf("omnicache: negative cache lists %d keys but indexes %d", nc.ll.Len(), len(nc.items))
+	}
+	if nc.ll.Len() > nc.max {
+		return fmt.Errorf("omnicache: negative cache holds %d keys, over its cap of %d", nc.ll.Len(), nc.max)
+	}
+	for el := nc.ll.Front(); el != nil; el = el.Next() {
+		key := el.Value.(*negativeEntry).key
+		if nc.items[key] != el {
+			return fmt.Errorf("omnicache: negative cache index for %q doesn't reference its list entry", key)
+		}
+	}
+	return nil
+}
+
+// CheckInvariants verifies the LRU list and index agree and respect the key limit,
+// then checks the wrapped Conn if it implements InvariantChecker
+func (lc *LRUConn) CheckInvariants() error {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	if lc.ll.Len() != len(lc.items) {
+		return fmt.Errorf("omnicache: LRUConn lists %d keys but indexes %d", lc.ll.Len(), len(lc.items))
+	}
+	if lc.ll.Len() > lc.max {
+		return fmt.Errorf("omnicache: LRUConn tracks %d keys, over its limit of %d", lc.ll.Len(), lc.max)
+	}
+	var bands [priorityBands]int
+	for el := lc.ll.Front(); el != nil; el = el.Next() {
+		item := el.Value.(*lruItem)
+		if lc.items[item.key] != el {
+			return fmt.Errorf("omnicache: LRUConn index for %q doesn't reference its list entry", item.key)
+		}
+		bands[item.priority.band()]++
+	}
+	if bands != lc.bands {
+		return fmt.Errorf("omnicache: LRUConn counts %v keys per priority but lists %v", lc.bands, bands)
+	}
+
+	if ic, ok := lc.conn.(InvariantChecker); ok {
+		return ic.CheckInvariants()
+	}
+	return nil
+}
+
+// CheckInvariants verifies every child that implements Ranger only holds keys routed to it,
+// then checks each child that implements InvariantChecker
+func (rc *RoutingConn) CheckInvariants() error {
+	for i, c := range rc.conns {
+		if r, ok := c.(Ranger); ok {
+			var misrouted []byte
+			err := r.Range(func(k, _ []byte) bool {
+				if rc.route(k) != c {
+					misrouted = append([]byte(nil), k...)
+					return false
+				}
+				return true
+			})
+			if err != nil {
+				return err
+			}
+			if misrouted != nil {
+				return fmt.Errorf("omnicache: RoutingConn child %d holds %q, which routes elsewhere", i, misrouted)
+			}
+		}
+		if ic, ok := c.(InvariantChecker); ok {
+			if err := ic.CheckInvariants(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// countsRanged reports whether c's KeyCount and BytesStored stats count the entries it ranges over, which isn't
+// the case for middlewares storing values in an envelope (metadata, checksum, compression, codec) whose Range
+// yields the unwrapped values and skips those failing to unwrap
+func countsRanged(c cache.Conn) bool {
+	switch c := c.(type) {
+	case *MetaConn, *ChecksumConn, *CompressionConn, *MigrationConn, *prefixConn, *prefixTTLConn, *prefixEntryConn, *prefixTTLEntryConn:
+		return false
+	case *LRUConn:
+		return countsRanged(c.conn)
+	case *SlowLogConn:
+		return countsRanged(c.conn)
+	case *RoutingConn:
+		for _, cc := range c.conns {
+			if !countsRanged(cc) {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,111 @@
+package omnicache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckInvariants(t *testing.T) {
+	children := []*mapConn{newMapConn(), newMapConn()}
+	lc, err := NewLRUConn(NewRoutingConn(children[0], children[1]), 5)
+	assert.Nil(t, err)
+	oc := New(lc, WithNegativeCache(time.Minute, 3))
+	defer oc.Close()
+
+	var calls int
+	// writes, evictions, deletes, overwrites and negatives
+	for i := 0; i < 20; i++ {
+		oc.Set([]byte(fmt.Sprintf("k%d", i%8)), []byte{byte(i)})
+		if i%3 == 0 {
+			lc.Delete([]byte(fmt.Sprintf("k%d", i%5)))
+		}
+		oc.Fetch([]byte(fmt.Sprintf("missing%d", i)), notFoundBackfill{calls: &calls})
+	}
+	oc.Get([]byte("k1"))
+	assert.Nil(t, oc.CheckInvariants())
+
+	// corrupted LRU index
+	for k := range lc.items {
+		delete(lc.items, k)
+		break
+	}
+	assert.Errorf(t, oc.CheckInvariants(), "LRUConn lists")
+}
+
+func TestCheckInvariantsMisrouted(t *testing.T) {
+	a, b := newMapConn(), newMapConn()
+	rc := NewRoutingConn(a, b)
+	oc := New(rc)
+	defer oc.Close()
+
+	oc.Set([]byte("x"), []byte{1})
+	assert.Nil(t, oc.CheckInvariants())
+
+	// write the key straight to the child it doesn't route to
+	wrong := a
+	if rc.route([]byte("x")) == a {
+		wrong = b
+	}
+	wrong.Write([]byte("x"), []byte{1})
+	assert.Errorf(t, oc.CheckInvariants(), "routes elsewhere")
+}
+
+// miscountingConn is a mapConn over-reporting its KeyCount and BytesStored
+type miscountingConn struct {
+	*mapConn
+	extraKeys, extraBytes uint64
+}
+
+func (m *miscountingConn) Stats() (map[string]interface{}, error) {
+	var bytes uint64
+	m.Range(func(_, v []byte) bool {
+		bytes += uint64(len(v))
+		return true
+	})
+	s, err := m.mapConn.Stats()
+	s["KeyCount"] = s["KeyCount"].(uint64) + m.extraKeys
+	s["BytesStored"] = bytes + m.extraBytes
+	return s, err
+}
+
+func TestCheckInvariantsUsage(t *testing.T) {
+	m := &miscountingConn{mapConn: newMapConn()}
+	oc := New(m)
+	defer oc.Close()
+
+	oc.Set([]byte("a"), []byte{1, 2})
+	oc.Set([]byte("b"), []byte{3})
+	assert.Nil(t, oc.CheckInvariants())
+
+	m.extraKeys = 1
+	assert.Errorf(t, oc.CheckInvariants(), "KeyCount")
+	m.extraKeys, m.extraBytes = 0, 4
+	assert.Errorf(t, oc.CheckInvariants(), "BytesStored")
+}
+
+func TestCheckInvariantsUsageSkipped(t *testing.T) {
+	m := &miscountingConn{mapConn: newMapConn()}
+	oc := New(m)
+	defer oc.Close()
+
+	// a view's stats include the shared Conn's other keys
+	oc.Set([]byte("other"), []byte{1})
+	ns := oc.Namespace([]byte("ns:"))
+	ns.Set([]byte("a"), []byte{1, 2})
+	assert.Nil(t, ns.CheckInvariants())
+
+	// envelopes aren't counted as the values Range yields
+	mc := New(NewMetaConn(m))
+	defer mc.Close()
+	mc.Set([]byte("b"), []byte{3})
+	m.extraBytes = 0
+	assert.Nil(t, mc.CheckInvariants())
+	lc, _ := NewLRUConn(NewChecksumConn(newMapConn()), 10)
+	cc := New(lc)
+	defer cc.Close()
+	cc.Set([]byte("c"), []byte{4})
+	assert.Nil(t, cc.CheckInvariants())
+}
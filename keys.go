@@ -0,0 +1,39 @@
+package omnicache
+
+// Keys returns every live key, e.g. for an admin endpoint listing what's cached
+// It's a point in time snapshot that may be stale as soon as it's returned. For large caches
+// RangeKeys avoids holding every key in memory. The Conn must implement Ranger
+func (oc *OmniCache) Keys() ([]string, error) {
+	var keys []string
+	err := oc.RangeKeys(func(k []byte) bool {
+		keys = append(keys, string(k))
+		return true
+	})
+	return keys, err
+}
+
+// RangeKeys calls fn for each live key until fn returns false. k is only valid during the call
+// The Conn must implement Ranger
+func (oc *OmniCache) RangeKeys(fn func(k []byte) bool) error {
+	return oc.rangeConn(func(k, _ []byte) bool {
+		return fn(k)
+	})
+}
+
+// Range calls fn for each live entry with a copy of its value until fn returns false, so admin tooling
+// can stream through the cache with bounded memory. Conns lock as little as their Range does (e.g. one
+// shard at a time), and entries written meanwhile may or may not be visited. The Conn must implement Ranger
+func (oc *OmniCache) Range(fn func(key string, value []byte) bool) error {
+	return oc.rangeConn(func(k, v []byte) bool {
+		return fn(string(k), copyBytes(v))
+	})
+}
+
+// rangeConn ranges over the Conn, which Keys, Range, Scan and DeletePrefix share
+func (oc *OmniCache) rangeConn(fn func(k, v []byte) bool) error {
+	r, ok := oc.Conn.(Ranger)
+	if !ok {
+		return ErrNotSupported
+	}
+	return r.Range(fn)
+}
@@ -0,0 +1,72 @@
+package omnicache
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeys(t *testing.T) {
+	m, clock := newFakeMapConn()
+	oc := New(m)
+	defer oc.Close()
+
+	oc.Set([]byte("a"), []byte{1})
+	oc.Set([]byte("b"), []byte{2})
+	oc.SetWithTTL([]byte("expired"), []byte{3}, time.Millisecond)
+	clock.Advance(5 * time.Millisecond)
+
+	keys, err := oc.Keys()
+	assert.Nil(t, err)
+	sort.Strings(keys)
+	assert.Equal(t, []string{"a", "b"}, keys)
+
+	// stop early
+	n := 0
+	err = oc.RangeKeys(func(k []byte) bool {
+		n++
+		return false
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+
+	_, err = New(createConn()).Keys()
+	assert.Equal(t, ErrNotSupported, err)
+}
+
+func TestRange(t *testing.T) {
+	m, clock := newFakeMapConn()
+	oc := New(m)
+	defer oc.Close()
+
+	oc.Set([]byte("a"), []byte{1})
+	oc.Set([]byte("b"), []byte{2})
+	oc.SetWithTTL([]byte("expired"), []byte{3}, time.Millisecond)
+	clock.Advance(5 * time.Millisecond)
+
+	got := map[string][]byte{}
+	err := oc.Range(func(key string, value []byte) bool {
+		got[key] = value
+		value[0] = 9
+		return true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]byte{"a": {9}, "b": {9}}, got)
+
+	// values are copies
+	b, _ := oc.Get([]byte("a"))
+	assert.Equal(t, []byte{1}, b)
+
+	// stop early
+	n := 0
+	oc.Range(func(string, []byte) bool {
+		n++
+		return false
+	})
+	assert.Equal(t, 1, n)
+
+	err = New(createConn()).Range(func(string, []byte) bool { return true })
+	assert.Equal(t, ErrNotSupported, err)
+}
@@ -0,0 +1,133 @@
+package omnicache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/panoplymedia/cache"
+)
+
+// KeyStatser is implemented by a cache.Conn that tracks hits and misses per key
+type KeyStatser interface {
+	KeyStats(k []byte) (hits, misses uint64, err error)
+}
+
+// KeyStatsConn is a cache.Conn middleware counting Read hits and misses per key, including keys
+// that were never written. At most maxKeys keys are tracked; the least recently read key is
+// forgotten to make room for a new one
+type KeyStatsConn struct {
+	conn    cache.Conn
+	maxKeys int
+
+	mu    sync.Mutex
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+type keyCounts struct {
+	key    string
+	hits   uint64
+	misses uint64
+}
+
+// NewKeyStatsConn wraps c, tracking at most maxKeys keys
+func NewKeyStatsConn(c cache.Conn, maxKeys int) *KeyStatsConn {
+	return &KeyStatsConn{
+		conn:    c,
+		maxKeys: maxKeys,
+		ll:      list.New(),
+		items:   map[string]*list.Element{},
+	}
+}
+
+// Close closes the wrapped Conn
+func (kc *KeyStatsConn) Close() error {
+	return kc.conn.Close()
+}
+
+// Ping checks the wrapped Conn, succeeding trivially if it doesn't implement Pinger
+func (kc *KeyStatsConn) Ping() error {
+	return ping(kc.conn)
+}
+
+// Write writes to the wrapped Conn
+func (kc *KeyStatsConn) Write(k, v []byte) error {
+	return kc.conn.Write(k, v)
+}
+
+// WriteTTL writes to the wrapped Conn with an explicit TTL
+func (kc *KeyStatsConn) WriteTTL(k, v []byte, ttl time.Duration) error {
+	return kc.conn.WriteTTL(k, v, ttl)
+}
+
+// Read reads from the wrapped Conn, counting a hit or miss for k
+func (kc *KeyStatsConn) Read(k []byte) ([]byte, error) {
+	v, err := kc.conn.Read(k)
+
+	kc.mu.Lock()
+	el, ok := kc.items[string(k)]
+	if ok {
+		kc.ll.MoveToFront(el)
+	} else {
+		el = kc.ll.PushFront(&keyCounts{key: string(k)})
+		kc.items[string(k)] = el
+		if kc.ll.Len() > kc.maxKeys {
+			last := kc.ll.Back()
+			kc.ll.Remove(last)
+			delete(kc.items, last.Value.(*keyCounts).key)
+		}
+	}
+	if err != nil {
+		el.Value.(*keyCounts).misses++
+	} else {
+		el.Value.(*keyCounts).hits++
+	}
+	kc.mu.Unlock()
+
+	return v, err
+}
+
+// KeyStats returns the hits and misses counted for k, which are zero for untracked keys
+func (kc *KeyStatsConn) KeyStats(k []byte) (uint64, uint64, error) {
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+	el, ok := kc.items[string(k)]
+	if !ok {
+		return 0, 0, nil
+	}
+	c := el.Value.(*keyCounts)
+	return c.hits, c.misses, nil
+}
+
+// ResetStats forgets every tracked key's counts and resets the wrapped Conn's counters if it implements StatsResetter
+func (kc *KeyStatsConn) ResetStats() error {
+	kc.mu.Lock()
+	kc.ll.Init()
+	kc.items = map[string]*list.Element{}
+	kc.mu.Unlock()
+	return resetStats(kc.conn)
+}
+
+// Delete removes k from the wrapped Conn, returning ErrNotSupported if it doesn't implement Deleter
+func (kc *KeyStatsConn) Delete(k []byte) error {
+	if d, ok := kc.conn.(Deleter); ok {
+		return d.Delete(k)
+	}
+	return ErrNotSupported
+}
+
+// Stats provides stats about the wrapped Conn
+func (kc *KeyStatsConn) Stats() (map[string]interface{}, error) {
+	return kc.conn.Stats()
+}
+
+// KeyStats returns the hits and misses recorded for a key
+// The Conn must implement KeyStatser (e.g. wrap it with NewKeyStatsConn)
+func (oc *OmniCache) KeyStats(k []byte) (hits, misses uint64, err error) {
+	ks, ok := oc.Conn.(KeyStatser)
+	if !ok {
+		return 0, 0, ErrNotSupported
+	}
+	return ks.KeyStats(k)
+}
@@ -0,0 +1,42 @@
+package omnicache
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyStats(t *testing.T) {
+	oc := New(NewKeyStatsConn(createConn(), 2))
+	defer oc.Close()
+
+	oc.Set([]byte("present"), []byte{1})
+	oc.Get([]byte("present"))
+	oc.Get([]byte("present"))
+	oc.Get([]byte("absent"))
+
+	hits, misses, err := oc.KeyStats([]byte("present"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), hits)
+	assert.Equal(t, uint64(0), misses)
+
+	// misses are counted for keys that were never written
+	hits, misses, err = oc.KeyStats([]byte("absent"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), hits)
+	assert.Equal(t, uint64(1), misses)
+
+	// tracking a third key forgets the least recently read one
+	oc.Get([]byte("other"))
+	hits, misses, err = oc.KeyStats([]byte("present"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), hits+misses)
+}
+
+func TestKeyStatsNotSupported(t *testing.T) {
+	oc := New(createConn())
+	defer oc.Close()
+
+	_, _, err := oc.KeyStats([]byte("k"))
+	assert.Equal(t, ErrNotSupported, err)
+}
@@ -0,0 +1,62 @@
+package omnicache
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sync"
+)
+
+const lockStripes = 64
+
+// LockShards is the number of key lock shards, the range a WithShardFunc function maps keys into
+const LockShards = lockStripes
+
+// keyLocks is a fixed set of mutexes striped by key hash, serializing writes
+// to a key so read-modify-write operations are atomic across OmniCache callers
+// Namespace views share their parent's lockTable, striping their keys with the view's prefix
+type keyLocks struct {
+	*lockTable
+	prefix []byte
+}
+
+type lockTable struct {
+	mu    [lockStripes]sync.Mutex
+	shard func(key string) int
+}
+
+// stripe returns the index of the mutex guarding k, panicking if a WithShardFunc function returns one out of range
+func (l *keyLocks) stripe(k []byte) int {
+	if len(l.prefix) > 0 {
+		k = append(append(make([]byte, 0, len(l.prefix)+len(k)), l.prefix...), k...)
+	}
+	if l.shard != nil {
+		s := l.shard(string(k))
+		if s < 0 || s >= lockStripes {
+			panic(fmt.Sprintf("omnicache: shard func returned %d for key %q, outside [0, %d)", s, k, lockStripes))
+		}
+		return s
+	}
+	h := fnv.New32a()
+	h.Write(k)
+	return int(h.Sum32() % lockStripes)
+}
+
+// lock locks and returns the mutex guarding k
+func (l *keyLocks) lock(k []byte) *sync.Mutex {
+	m := &l.mu[l.stripe(k)]
+	m.Lock()
+	return m
+}
+
+// lockAll locks every stripe, in order, blocking all OmniCache writes until unlockAll
+func (l *keyLocks) lockAll() {
+	for i := range l.mu {
+		l.mu[i].Lock()
+	}
+}
+
+func (l *keyLocks) unlockAll() {
+	for i := range l.mu {
+		l.mu[i].Unlock()
+	}
+}
@@ -0,0 +1,335 @@
+package omnicache
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/panoplymedia/cache"
+)
+
+// LRUConn is a cache.Conn middleware bounding the number of keys in the wrapped Conn,
+// deleting the least recently used key when a write would exceed the limit
+type LRUConn struct {
+	conn    cache.Conn
+	deleter Deleter
+	max     int
+
+	minResidency time.Duration
+	admission    *countMinSketch
+	policy       EvictionPolicy
+
+	mu        sync.Mutex
+	ll        *list.List
+	items     map[string]*list.Element
+	bands     [priorityBands]int
+	evictions uint64
+	evictHook func(k, v []byte, reason EvictReason)
+}
+
+type lruItem struct {
+	key      string
+	written  time.Time
+	priority Priority
+}
+
+// LRUOption configures optional LRUConn behavior in NewLRUConn
+type LRUOption func(*LRUConn)
+
+// WithMinResidency protects keys written within d from capacity eviction (they can still expire by TTL)
+// When every candidate is within its residency window the least recently used one is evicted anyway
+func WithMinResidency(d time.Duration) LRUOption {
+	return func(lc *LRUConn) {
+		lc.minResidency = d
+	}
+}
+
+// WithAdmission enables TinyLFU style admission control: reads and writes are counted in a count-min
+// sketch of width counters per row, and when the cache is full a new key is only admitted if it has been
+// accessed more often than the key it would evict. Rejected writes are dropped without error
+func WithAdmission(width int) LRUOption {
+	return func(lc *LRUConn) {
+		lc.admission = newCountMinSketch(width)
+	}
+}
+
+// WithEvictionPolicy makes the LRUConn evict the key p picks rather than the least recently used one,
+// e.g. NewLFUPolicy for workloads with a stable popular set. Priorities and WithMinResidency aren't
+// consulted, while WithAdmission still compares a new key against the least recently used one
+func WithEvictionPolicy(p EvictionPolicy) LRUOption {
+	return func(lc *LRUConn) {
+		lc.policy = p
+	}
+}
+
+// NewLRUConn wraps c, holding at most maxEntries keys
+// c must implement Deleter so evicted keys can be removed
+func NewLRUConn(c cache.Conn, maxEntries int, opts ...LRUOption) (*LRUConn, error) {
+	d, ok := c.(Deleter)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+
+	lc := &LRUConn{
+		conn:    c,
+		deleter: d,
+		max:     maxEntries,
+		ll:      list.New(),
+		items:   map[string]*list.Element{},
+	}
+	for _, opt := range opts {
+		opt(lc)
+	}
+	return lc, nil
+}
+
+// Close closes the wrapped Conn
+func (lc *LRUConn) Close() error {
+	return lc.conn.Close()
+}
+
+// Ping checks the wrapped Conn, succeeding trivially if it doesn't implement Pinger
+func (lc *LRUConn) Ping() error {
+	return ping(lc.conn)
+}
+
+// Write writes to the wrapped Conn, evicting if the key limit is exceeded
+func (lc *LRUConn) Write(k, v []byte) error {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	if !lc.admit(string(k)) {
+		return nil
+	}
+	if err := lc.conn.Write(k, v); err != nil {
+		return err
+	}
+	return lc.added(string(k), PriorityNormal, false)
+}
+
+// WriteTTL writes to the wrapped Conn with an explicit TTL, evicting if the key limit is exceeded
+func (lc *LRUConn) WriteTTL(k, v []byte, ttl time.Duration) error {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	if !lc.admit(string(k)) {
+		return nil
+	}
+	if err := lc.conn.WriteTTL(k, v, ttl); err != nil {
+		return err
+	}
+	return lc.added(string(k), PriorityNormal, false)
+}
+
+// WriteTTLPriority writes to the wrapped Conn with an explicit TTL and eviction priority,
+// evicting if the key limit is exceeded
+func (lc *LRUConn) WriteTTLPriority(k, v []byte, ttl time.Duration, p Priority) error {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	if !lc.admit(string(k)) {
+		return nil
+	}
+	if err := lc.conn.WriteTTL(k, v, ttl); err != nil {
+		return err
+	}
+	return lc.added(string(k), p, true)
+}
+
+// admit counts a write to key and reports whether it may be stored; lc.mu must be held
+func (lc *LRUConn) admit(key string) bool {
+	if lc.admission == nil {
+		return true
+	}
+	lc.admission.add(key)
+	if _, ok := lc.items[key]; ok || lc.ll.Len() < lc.max {
+		return true
+	}
+	victim := lc.victim(time.Now()).Value.(*lruItem).key
+	return lc.admission.estimate(key) > lc.admission.estimate(victim)
+}
+
+// added records a write to key and evicts down to the limit; lc.mu must be held
+// An existing key keeps its priority unless setPriority is set
+func (lc *LRUConn) added(key string, p Priority, setPriority bool) error {
+	now := time.Now()
+	if el, ok := lc.items[key]; ok {
+		item := el.Value.(*lruItem)
+		item.written = now
+		if setPriority {
+			lc.bands[item.priority.band()]--
+			item.priority = p
+			lc.bands[p.band()]++
+		}
+		lc.ll.MoveToFront(el)
+		if lc.policy != nil {
+			lc.policy.OnAccess(key)
+		}
+		return nil
+	}
+	lc.items[key] = lc.ll.PushFront(&lruItem{key: key, written: now, priority: p})
+	lc.bands[p.band()]++
+	if lc.policy != nil {
+		// added once room is made, so the policy can't pick the new key
+		defer lc.policy.OnAdd(key)
+	}
+
+	for lc.ll.Len() > lc.max {
+		el := lc.evictee(now)
+		k := []byte(el.Value.(*lruItem).key)
+		var v []byte
+		if lc.evictHook != nil {
+			v, _ = lc.conn.Read(k)
+		}
+		if err := lc.deleter.Delete(k); err != nil {
+			return err
+		}
+		lc.remove(el)
+		atomic.AddUint64(&lc.evictions, 1)
+		if lc.evictHook != nil {
+			lc.evictHook(k, v, EvictCapacity)
+		}
+	}
+	return nil
+}
+
+// SetEvictHook makes capacity evictions call hook with the evicted key and value
+func (lc *LRUConn) SetEvictHook(hook func(k, v []byte, reason EvictReason)) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	lc.evictHook = hook
+}
+
+// evictee returns the element to evict, picked by the EvictionPolicy if there is one; lc.mu must be held
+func (lc *LRUConn) evictee(now time.Time) *list.Element {
+	if lc.policy != nil {
+		for {
+			key, ok := lc.policy.Evict()
+			if !ok {
+				break
+			}
+			if el, ok := lc.items[key]; ok {
+				return el
+			}
+		}
+	}
+	return lc.victim(now)
+}
+
+// victim returns the least recently used element of the lowest priority band with an element
+// outside its residency window, or the least recently used element of the lowest band if none is; lc.mu must be held
+func (lc *LRUConn) victim(now time.Time) *list.Element {
+	var fallback *list.Element
+	for band, n := range lc.bands {
+		if n == 0 {
+			continue
+		}
+		for el := lc.ll.Back(); el != nil; el = el.Prev() {
+			item := el.Value.(*lruItem)
+			if item.priority.band() != band {
+				continue
+			}
+			if lc.minResidency <= 0 || now.Sub(item.written) >= lc.minResidency {
+				return el
+			}
+			if fallback == nil {
+				fallback = el
+			}
+		}
+	}
+	if fallback == nil {
+		fallback = lc.ll.Back()
+	}
+	return fallback
+}
+
+func (lc *LRUConn) remove(el *list.Element) {
+	item := el.Value.(*lruItem)
+	lc.bands[item.priority.band()]--
+	lc.ll.Remove(el)
+	delete(lc.items, item.key)
+	if lc.policy != nil {
+		lc.policy.OnRemove(item.key)
+	}
+}
+
+// Read reads from the wrapped Conn, marking the key as recently used
+func (lc *LRUConn) Read(k []byte) ([]byte, error) {
+	v, err := lc.conn.Read(k)
+
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	if lc.admission != nil {
+		lc.admission.add(string(k))
+	}
+	if el, ok := lc.items[string(k)]; ok {
+		if err != nil {
+			// gone from the wrapped Conn (e.g. expired)
+			lc.remove(el)
+		} else {
+			lc.ll.MoveToFront(el)
+			if lc.policy != nil {
+				lc.policy.OnAccess(string(k))
+			}
+		}
+	}
+	return v, err
+}
+
+// Delete removes k from the wrapped Conn
+func (lc *LRUConn) Delete(k []byte) error {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	if err := lc.deleter.Delete(k); err != nil {
+		return err
+	}
+	if el, ok := lc.items[string(k)]; ok {
+		lc.remove(el)
+	}
+	return nil
+}
+
+// Flush flushes the wrapped Conn, returning ErrNotSupported if it doesn't implement Flusher
+func (lc *LRUConn) Flush() error {
+	f, ok := lc.conn.(Flusher)
+	if !ok {
+		return ErrNotSupported
+	}
+
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	if err := f.Flush(); err != nil {
+		return err
+	}
+	if lc.policy != nil {
+		for key := range lc.items {
+			lc.policy.OnRemove(key)
+		}
+	}
+	lc.ll.Init()
+	lc.items = map[string]*list.Element{}
+	lc.bands = [priorityBands]int{}
+	return nil
+}
+
+// Range iterates the wrapped Conn without affecting recency, returning ErrNotSupported if it doesn't implement Ranger
+func (lc *LRUConn) Range(fn func(k, v []byte) bool) error {
+	if r, ok := lc.conn.(Ranger); ok {
+		return r.Range(fn)
+	}
+	return ErrNotSupported
+}
+
+// Stats provides stats about the wrapped Conn, adding "Evictions"
+func (lc *LRUConn) Stats() (map[string]interface{}, error) {
+	s, err := lc.conn.Stats()
+	if err != nil {
+		return s, err
+	}
+	s["Evictions"] = atomic.LoadUint64(&lc.evictions)
+	return s, nil
+}
+
+// ResetStats zeroes "Evictions" and resets the wrapped Conn's counters if it implements StatsResetter
+func (lc *LRUConn) ResetStats() error {
+	atomic.StoreUint64(&lc.evictions, 0)
+	return resetStats(lc.conn)
+}
@@ -0,0 +1,171 @@
+package omnicache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLRUConn(t *testing.T) {
+	lc, err := NewLRUConn(newMapConn(), 3)
+	assert.Nil(t, err)
+	oc := New(lc)
+	defer oc.Close()
+
+	for _, k := range []string{"a", "b", "c"} {
+		oc.Set([]byte(k), []byte(k))
+	}
+	// a becomes most recently used
+	_, err = oc.Get([]byte("a"))
+	assert.Nil(t, err)
+
+	oc.Set([]byte("d"), []byte("d"))
+	_, err = oc.Get([]byte("b"))
+	assert.Errorf(t, err, "Key not found")
+	for _, k := range []string{"a", "c", "d"} {
+		_, err = oc.Get([]byte(k))
+		assert.Nil(t, err, k)
+	}
+
+	s, err := oc.Stats()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(3), s["KeyCount"])
+	assert.Equal(t, uint64(1), s["Evictions"])
+}
+
+func TestLRUConnNotSupported(t *testing.T) {
+	_, err := NewLRUConn(createConn(), 3)
+	assert.Equal(t, ErrNotSupported, err)
+}
+
+// fillPastResidency writes a and b, waits out the residency window, writes x,
+// then reads a and b so the freshly written x is least recently used, and overflows with y
+func fillPastResidency(oc *OmniCache) {
+	oc.Set([]byte("a"), []byte("a"))
+	oc.Set([]byte("b"), []byte("b"))
+	time.Sleep(60 * time.Millisecond)
+	oc.Set([]byte("x"), []byte("x"))
+	oc.Get([]byte("a"))
+	oc.Get([]byte("b"))
+	oc.Set([]byte("y"), []byte("y"))
+}
+
+func TestLRUConnMinResidency(t *testing.T) {
+	// without a residency window the never-read x is evicted
+	lc, _ := NewLRUConn(newMapConn(), 3)
+	oc := New(lc)
+	fillPastResidency(oc)
+	_, err := oc.Get([]byte("x"))
+	assert.Errorf(t, err, "Key not found")
+
+	// with one, the older a is evicted in its place
+	lc, _ = NewLRUConn(newMapConn(), 3, WithMinResidency(50*time.Millisecond))
+	oc = New(lc)
+	fillPastResidency(oc)
+	_, err = oc.Get([]byte("x"))
+	assert.Nil(t, err)
+	_, err = oc.Get([]byte("a"))
+	assert.Errorf(t, err, "Key not found")
+
+	// b is the remaining entry outside its window
+	oc.Set([]byte("z"), []byte("z"))
+	_, err = oc.Get([]byte("b"))
+	assert.Errorf(t, err, "Key not found")
+
+	// everything is within its window, so plain LRU order applies
+	oc.Set([]byte("w"), []byte("w"))
+	_, err = oc.Get([]byte("y"))
+	assert.Errorf(t, err, "Key not found")
+	for _, k := range []string{"x", "z", "w"} {
+		_, err = oc.Get([]byte(k))
+		assert.Nil(t, err, fmt.Sprintf("%s evicted", k))
+	}
+}
+
+// hotSetHitRate reads a hot working set in rounds, re-setting misses, while streaming
+// unique one-hit keys through the cache, returning the hot set's hit rate
+func hotSetHitRate(oc *OmniCache) float64 {
+	var hits, reads int
+	unique := 0
+	for round := 0; round < 50; round++ {
+		for i := 0; i < 8; i++ {
+			k := []byte(fmt.Sprintf("hot-%d", i))
+			reads++
+			if _, err := oc.Get(k); err == nil {
+				hits++
+			} else {
+				oc.Set(k, k)
+			}
+		}
+		for i := 0; i < 5; i++ {
+			k := []byte(fmt.Sprintf("once-%d", unique))
+			unique++
+			if _, err := oc.Get(k); err != nil {
+				oc.Set(k, k)
+			}
+		}
+	}
+	return float64(hits) / float64(reads)
+}
+
+func TestLRUConnAdmission(t *testing.T) {
+	lc, _ := NewLRUConn(newMapConn(), 10)
+	plain := hotSetHitRate(New(lc))
+
+	lc, _ = NewLRUConn(newMapConn(), 10, WithAdmission(1024))
+	admitted := hotSetHitRate(New(lc))
+
+	assert.True(t, admitted > 0.9, fmt.Sprintf("hit rate with admission %.2f", admitted))
+	assert.True(t, admitted > plain, fmt.Sprintf("hit rate %.2f with admission, %.2f without", admitted, plain))
+}
+
+func TestLRUConnPriority(t *testing.T) {
+	lc, err := NewLRUConn(newMapConn(), 4)
+	assert.Nil(t, err)
+	oc := New(lc)
+	defer oc.Close()
+
+	oc.SetWithPriority([]byte("high1"), []byte{1}, 0, PriorityHigh)
+	oc.SetWithPriority([]byte("high2"), []byte{1}, 0, PriorityHigh)
+	oc.Set([]byte("normal"), []byte{1})
+	oc.SetWithPriority([]byte("low"), []byte{1}, 0, PriorityLow)
+
+	// the low priority key goes first, even though it's the most recently used
+	oc.Get([]byte("low"))
+	oc.Set([]byte("new1"), []byte{1})
+	_, err = oc.Get([]byte("low"))
+	assert.NotNil(t, err)
+
+	// then normal priority keys, least recently used first
+	oc.Set([]byte("new2"), []byte{1})
+	_, err = oc.Get([]byte("normal"))
+	assert.NotNil(t, err)
+	oc.Set([]byte("new3"), []byte{1})
+	_, err = oc.Get([]byte("new1"))
+	assert.NotNil(t, err)
+
+	for _, k := range []string{"high1", "high2", "new2", "new3"} {
+		_, err = oc.Get([]byte(k))
+		assert.Nil(t, err, k)
+	}
+	assert.Nil(t, oc.CheckInvariants())
+
+	err = New(newMapConn()).SetWithPriority([]byte("k"), []byte{1}, 0, PriorityLow)
+	assert.Equal(t, ErrNotSupported, err)
+}
+
+func TestLRUConnPriorityObserved(t *testing.T) {
+	m := newMapConn()
+	lc, err := NewLRUConn(m, 4)
+	assert.Nil(t, err)
+	oc := New(lc, WithPeakTracking())
+	defer oc.Close()
+
+	assert.Nil(t, oc.SetWithPriority([]byte("k"), []byte{1}, 0, PriorityHigh))
+	m.Flush()
+	s, err := oc.Stats()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), s["PeakKeyCount"])
+}
@@ -0,0 +1,49 @@
+package omnicache
+
+import (
+	"sync"
+	"time"
+)
+
+// Compact asks the Conn to release memory retained by deleted and expired entries
+// The Conn must implement Compacter
+func (oc *OmniCache) Compact() error {
+	c, ok := oc.Conn.(Compacter)
+	if !ok {
+		return ErrNotSupported
+	}
+	return c.Compact()
+}
+
+// sweeper runs Compact periodically in the background until stopped
+type sweeper struct {
+	interval time.Duration
+	stop     chan struct{}
+	done     chan struct{}
+	once     sync.Once
+}
+
+func (sw *sweeper) run(c Compacter) {
+	defer close(sw.done)
+	t := time.NewTicker(sw.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			c.Compact()
+		case <-sw.stop:
+			return
+		}
+	}
+}
+
+// halt stops the sweeper and waits for a running sweep to finish
+func (sw *sweeper) halt() {
+	if sw == nil {
+		return
+	}
+	sw.once.Do(func() {
+		close(sw.stop)
+		<-sw.done
+	})
+}
@@ -0,0 +1,87 @@
+package omnicache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompact(t *testing.T) {
+	m := newMapConn()
+	oc := New(m)
+	defer oc.Close()
+
+	// inflate, then drain by expiry
+	for i := 0; i < 1000; i++ {
+		oc.SetWithTTL([]byte(fmt.Sprintf("k-%d", i)), []byte{1}, 10*time.Millisecond)
+	}
+	oc.SetWithTTL([]byte("live"), []byte{1}, time.Minute)
+	time.Sleep(20 * time.Millisecond)
+
+	err := oc.Compact()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, m.compactions)
+
+	// only live entries survive the rebuild
+	s, err := oc.Stats()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), s["KeyCount"])
+	_, err = oc.Get([]byte("live"))
+	assert.Nil(t, err)
+}
+
+func TestCompactRouting(t *testing.T) {
+	a, b := newMapConn(), newMapConn()
+	oc := New(NewRoutingConn(a, b))
+	defer oc.Close()
+
+	err := oc.Compact()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, a.compactions)
+	assert.Equal(t, 1, b.compactions)
+}
+
+func TestCompactNotSupported(t *testing.T) {
+	oc := New(createConn())
+	defer oc.Close()
+
+	assert.Equal(t, ErrNotSupported, oc.Compact())
+}
+
+func TestSweepInterval(t *testing.T) {
+	m := newMapConn()
+	oc := New(m, WithSweepInterval(10*time.Millisecond))
+
+	oc.SetWithTTL([]byte("write-once"), []byte{1}, time.Millisecond)
+	time.Sleep(35 * time.Millisecond)
+
+	// reclaimed without being read
+	m.mu.Lock()
+	_, ok := m.dat["write-once"]
+	m.mu.Unlock()
+	assert.False(t, ok)
+
+	// no sweeps after Close
+	assert.Nil(t, oc.Close())
+	m.mu.Lock()
+	n := m.compactions
+	m.mu.Unlock()
+	assert.True(t, n >= 2)
+	time.Sleep(25 * time.Millisecond)
+	m.mu.Lock()
+	assert.Equal(t, n, m.compactions)
+	m.mu.Unlock()
+	assert.Nil(t, oc.Close())
+}
+
+func TestSweepIntervalDisabled(t *testing.T) {
+	for _, interval := range []time.Duration{0, -time.Second} {
+		m := newMapConn()
+		oc := New(m, WithSweepInterval(time.Millisecond), WithSweepInterval(interval))
+		time.Sleep(10 * time.Millisecond)
+		assert.Nil(t, oc.Close())
+		assert.Equal(t, 0, m.compactions)
+	}
+}
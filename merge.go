@@ -0,0 +1,62 @@
+package omnicache
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Merge applies patch to the JSON value of k as an RFC 7386 merge patch, treating a missing key as {},
+// and stores the result with ttl, returning it. Null members of patch remove fields. The read, merge and
+// write are atomic with respect to other writes made through this OmniCache
+func (oc *OmniCache) Merge(k []byte, patch []byte, ttl time.Duration) ([]byte, error) {
+	ttl, err := oc.checkTTL(ttl)
+	if err != nil {
+		return nil, err
+	}
+	if err := oc.checkKey(k); err != nil {
+		return nil, err
+	}
+	var p interface{}
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return nil, err
+	}
+
+	defer oc.observeWrite()
+	defer oc.locks.lock(k).Unlock()
+	var target interface{} = map[string]interface{}{}
+	if v, err := oc.Conn.Read(k); err == nil {
+		if err := json.Unmarshal(v, &target); err != nil {
+			return nil, err
+		}
+	}
+
+	ret, err := json.Marshal(mergePatch(target, p))
+	if err != nil {
+		return nil, err
+	}
+	if err := oc.checkWrite(k, ret); err != nil {
+		return nil, err
+	}
+	oc.written(k)
+	return ret, oc.Conn.WriteTTL(k, ret, ttl)
+}
+
+// mergePatch implements the RFC 7386 MergePatch algorithm on decoded JSON
+func mergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = map[string]interface{}{}
+	}
+	for name, v := range p {
+		if v == nil {
+			delete(t, name)
+		} else {
+			t[name] = mergePatch(t[name], v)
+		}
+	}
+	return t
+}
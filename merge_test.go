@@ -0,0 +1,56 @@
+package omnicache
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMerge(t *testing.T) {
+	oc := New(newMapConn())
+	defer oc.Close()
+
+	// missing key merges into {}
+	b, err := oc.Merge([]byte("user"), []byte(`{"name":"ada","tags":["a"]}`), 0)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"name":"ada","tags":["a"]}`, string(b))
+
+	// nested objects merge, arrays are replaced
+	oc.Set([]byte("user"), []byte(`{"name":"ada","address":{"city":"london","zip":"n1"},"tags":["a"]}`))
+	b, err = oc.Merge([]byte("user"), []byte(`{"address":{"zip":"e1"},"tags":["b"]}`), 0)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"name":"ada","address":{"city":"london","zip":"e1"},"tags":["b"]}`, string(b))
+
+	// null removes a field
+	b, err = oc.Merge([]byte("user"), []byte(`{"address":null}`), 0)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"name":"ada","tags":["b"]}`, string(b))
+	stored, err := oc.Get([]byte("user"))
+	assert.Nil(t, err)
+	assert.Equal(t, b, stored)
+
+	// invalid patch stores nothing
+	_, err = oc.Merge([]byte("other"), []byte(`{`), 0)
+	assert.NotNil(t, err)
+	_, err = oc.Get([]byte("other"))
+	assert.Errorf(t, err, "Key not found")
+}
+
+func TestMergeChecks(t *testing.T) {
+	m := newMapConn()
+	oc := New(m, WithPeakTracking())
+
+	_, err := oc.Merge(nil, []byte(`{"a":1}`), 0)
+	assert.Equal(t, ErrEmptyKey, err)
+
+	// merges are observed for peaks
+	oc.Merge([]byte("a"), []byte(`{"a":1}`), 0)
+	m.Flush()
+	s, err := oc.Stats()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), s["PeakKeyCount"])
+
+	oc.Close()
+	_, err = oc.Merge([]byte("a"), []byte(`{"a":1}`), 0)
+	assert.Equal(t, ErrClosed, err)
+}
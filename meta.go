@@ -0,0 +1,455 @@
+package omnicache
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/panoplymedia/cache"
+)
+
+// ErrInvalidEntry is returned by MetaConn when a stored value isn't an encoded Entry
+var ErrInvalidEntry = errors.New("omnicache: invalid entry")
+
+// Entry is a cached value along with its metadata
+type Entry struct {
+	Value       []byte
+	ContentType string
+	// ExpiresAt is set from the TTL by WriteEntryTTL; it is zero for entries that never
+	// expire or were written with the Conn's default TTL
+	ExpiresAt time.Time
+	// SoftExpiresAt is when the value becomes stale, while remaining readable until ExpiresAt
+	// It is zero for entries that don't go stale before they expire
+	SoftExpiresAt time.Time
+	// Generation is assigned by MetaConn on every write, increasing with each write through it,
+	// so an unchanged Generation means an unchanged value
+	Generation uint64
+	// CreatedAt is set by MetaConn to the time of the write
+	CreatedAt time.Time
+	// Ephemeral entries stay in process: Snapshot omits them and TieredConn keeps them out of its far tier
+	Ephemeral bool
+	// Version is set by the caller with SetVersioned, zero for entries written otherwise
+	Version uint64
+}
+
+// EntryConn is implemented by a cache.Conn that stores Entry metadata alongside values
+type EntryConn interface {
+	ReadEntry(k []byte) (Entry, error)
+	WriteEntry(k []byte, e Entry) error
+	WriteEntryTTL(k []byte, e Entry, ttl time.Duration) error
+}
+
+// EntryRanger is implemented by a cache.Conn that can iterate its live entries with their metadata
+// RangeEntries calls fn for each entry until fn returns false
+type EntryRanger interface {
+	RangeEntries(fn func(k []byte, e Entry) bool) error
+}
+
+// MetaConn is a cache.Conn middleware implementing EntryConn on top of any Conn
+// by storing each value in an envelope carrying its metadata. Plain Read and Write
+// see only the value, so existing callers are unaffected
+type MetaConn struct {
+	conn  cache.Conn
+	gen   uint64
+	clock func() time.Time
+}
+
+// NewMetaConn wraps c with a MetaConn
+// Generations start from the current time so they keep increasing across restarts
+func NewMetaConn(c cache.Conn) *MetaConn {
+	return &MetaConn{conn: c, gen: uint64(time.Now().UnixNano())}
+}
+
+// SetClock makes the MetaConn stamp CreatedAt and ExpiresAt with now rather than the wall clock
+// It must be called before the MetaConn is used; New calls it for WithClock
+func (mc *MetaConn) SetClock(now func() time.Time) {
+	mc.clock = now
+}
+
+func (mc *MetaConn) now() time.Time {
+	if mc.clock != nil {
+		return mc.clock().UTC()
+	}
+	return time.Now().UTC()
+}
+
+// Close closes the wrapped Conn
+func (mc *MetaConn) Close() error {
+	return mc.conn.Close()
+}
+
+// Ping checks the wrapped Conn, succeeding trivially if it doesn't implement Pinger
+func (mc *MetaConn) Ping() error {
+	return ping(mc.conn)
+}
+
+// Write stores v without metadata
+func (mc *MetaConn) Write(k, v []byte) error {
+	return mc.WriteEntry(k, Entry{Value: v})
+}
+
+// WriteTTL stores v without metadata with an explicit TTL
+func (mc *MetaConn) WriteTTL(k, v []byte, ttl time.Duration) error {
+	return mc.WriteEntryTTL(k, Entry{Value: v}, ttl)
+}
+
+// Read returns the value stored for k
+func (mc *MetaConn) Read(k []byte) ([]byte, error) {
+	e, err := mc.ReadEntry(k)
+	return e.Value, err
+}
+
+// ReadEntry returns the value and metadata stored for k
+func (mc *MetaConn) ReadEntry(k []byte) (Entry, error) {
+	b, err := mc.conn.Read(k)
+	if err != nil {
+		return Entry{}, err
+	}
+	return decodeEntry(b)
+}
+
+// WriteEntry stores e with the wrapped Conn's default TTL
+func (mc *MetaConn) WriteEntry(k []byte, e Entry) error {
+	e.ExpiresAt = time.Time{}
+	e.Generation = atomic.AddUint64(&mc.gen, 1)
+	e.CreatedAt = mc.now()
+	return mc.conn.Write(k, encodeEntry(e))
+}
+
+// WriteEntryTTL stores e with an explicit TTL, recording its expiry
+func (mc *MetaConn) WriteEntryTTL(k []byte, e Entry, ttl time.Duration) error {
+	e.ExpiresAt = time.Time{}
+	e.Generation = atomic.AddUint64(&mc.gen, 1)
+	e.CreatedAt = mc.now()
+	if ttl > 0 {
+		e.ExpiresAt = e.CreatedAt.Add(ttl)
+	}
+	return mc.conn.WriteTTL(k, encodeEntry(e), ttl)
+}
+
+// Touch rewrites the entry for k to expire after ttl, keeping its generation and creation time
+func (mc *MetaConn) Touch(k []byte, ttl time.Duration) error {
+	e, err := mc.ReadEntry(k)
+	if err != nil {
+		return err
+	}
+	e.ExpiresAt = time.Time{}
+	if ttl > 0 {
+		e.ExpiresAt = mc.now().Add(ttl)
+	}
+	return mc.conn.WriteTTL(k, encodeEntry(e), ttl)
+}
+
+// Delete removes k from the wrapped Conn, returning ErrNotSupported if it doesn't implement Deleter
+func (mc *MetaConn) Delete(k []byte) error {
+	if d, ok := mc.conn.(Deleter); ok {
+		return d.Delete(k)
+	}
+	return ErrNotSupported
+}
+
+// Flush flushes the wrapped Conn, returning ErrNotSupported if it doesn't implement Flusher
+func (mc *MetaConn) Flush() error {
+	if f, ok := mc.conn.(Flusher); ok {
+		return f.Flush()
+	}
+	return ErrNotSupported
+}
+
+// Range iterates the values of the wrapped Conn, returning ErrNotSupported if it doesn't implement Ranger
+// Values that aren't encoded entries are skipped
+func (mc *MetaConn) Range(fn func(k, v []byte) bool) error {
+	return mc.RangeEntries(func(k []byte, e Entry) bool {
+		return fn(k, e.Value)
+	})
+}
+
+// RangeEntries iterates the entries of the wrapped Conn, returning ErrNotSupported if it doesn't implement Ranger
+// Values that aren't encoded entries are skipped
+func (mc *MetaConn) RangeEntries(fn func(k []byte, e Entry) bool) error {
+	r, ok := mc.conn.(Ranger)
+	if !ok {
+		return ErrNotSupported
+	}
+	return r.Range(func(k, v []byte) bool {
+		e, err := decodeEntry(v)
+		if err != nil {
+			return true
+		}
+		return fn(k, e)
+	})
+}
+
+// Stats provides stats about the wrapped Conn
+func (mc *MetaConn) Stats() (map[string]interface{}, error) {
+	return mc.conn.Stats()
+}
+
+// ResetStats resets the wrapped Conn's counters if it implements StatsResetter
+func (mc *MetaConn) ResetStats() error {
+	return resetStats(mc.conn)
+}
+
+// Entry envelope layout: a magic byte and format version followed by fields, each encoded as
+// a tag byte, a uvarint length and the field bytes. Unknown tags are skipped when decoding
+const (
+	entryMagic   = 0xce
+	entryVersion = 1
+
+	tagValue       = 1
+	tagContentType = 2
+	tagExpiresAt   = 3
+	tagSoftExpires = 4
+	tagGeneration  = 5
+	tagEphemeral   = 6
+	tagCreatedAt   = 7
+	tagVersion     = 8
+)
+
+func appendField(b []byte, tag byte, f []byte) []byte {
+	b = appendUvarint(append(b, tag), uint64(len(f)))
+	return append(b, f...)
+}
+
+func encodeEntry(e Entry) []byte {
+	b := make([]byte, 0, len(e.Value)+len(e.ContentType)+16)
+	b = append(b, entryMagic, entryVersion)
+	b = appendField(b, tagValue, e.Value)
+	if e.ContentType != "" {
+		b = appendField(b, tagContentType, []byte(e.ContentType))
+	}
+	b = appendTime(b, tagExpiresAt, e.ExpiresAt)
+	b = appendTime(b, tagSoftExpires, e.SoftExpiresAt)
+	b = appendUint(b, tagGeneration, e.Generation)
+	if e.Ephemeral {
+		b = appendField(b, tagEphemeral, nil)
+	}
+	b = appendTime(b, tagCreatedAt, e.CreatedAt)
+	b = appendUint(b, tagVersion, e.Version)
+	return b
+}
+
+// appendUint appends a non-zero n as a field of big endian bytes
+func appendUint(b []byte, tag byte, n uint64) []byte {
+	if n == 0 {
+		return b
+	}
+	var f [8]byte
+	binary.BigEndian.PutUint64(f[:], n)
+	return appendField(b, tag, f[:])
+}
+
+// appendTime appends a non-zero t as a field of UnixNano big endian bytes
+func appendTime(b []byte, tag byte, t time.Time) []byte {
+	if t.IsZero() {
+		return b
+	}
+	var n [8]byte
+	binary.BigEndian.PutUint64(n[:], uint64(t.UnixNano()))
+	return appendField(b, tag, n[:])
+}
+
+func decodeTime(f []byte) (time.Time, error) {
+	if len(f) != 8 {
+		return time.Time{}, ErrInvalidEntry
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(f))).UTC(), nil
+}
+
+func decodeEntry(b []byte) (Entry, error) {
+	var e Entry
+	var err error
+	if len(b) < 2 || b[0] != entryMagic || b[1] != entryVersion {
+		return e, ErrInvalidEntry
+	}
+
+	b = b[2:]
+	for len(b) > 0 {
+		tag := b[0]
+		n, sz := binary.Uvarint(b[1:])
+		if sz <= 0 || uint64(len(b)-1-sz) < n {
+			return Entry{}, ErrInvalidEntry
+		}
+		f := b[1+sz : 1+sz+int(n)]
+		b = b[1+sz+int(n):]
+
+		switch tag {
+		case tagValue:
+			e.Value = f
+		case tagContentType:
+			e.ContentType = string(f)
+		case tagExpiresAt:
+			if e.ExpiresAt, err = decodeTime(f); err != nil {
+				return Entry{}, err
+			}
+		case tagSoftExpires:
+			if e.SoftExpiresAt, err = decodeTime(f); err != nil {
+				return Entry{}, err
+			}
+		case tagGeneration:
+			if len(f) != 8 {
+				return Entry{}, ErrInvalidEntry
+			}
+			e.Generation = binary.BigEndian.Uint64(f)
+		case tagVersion:
+			if len(f) != 8 {
+				return Entry{}, ErrInvalidEntry
+			}
+			e.Version = binary.BigEndian.Uint64(f)
+		case tagEphemeral:
+			e.Ephemeral = true
+		case tagCreatedAt:
+			if e.CreatedAt, err = decodeTime(f); err != nil {
+				return Entry{}, err
+			}
+		}
+	}
+
+	return e, nil
+}
+
+// isEphemeral reports whether b is an encoded Entry flagged Ephemeral
+func isEphemeral(b []byte) bool {
+	e, err := decodeEntry(b)
+	return err == nil && e.Ephemeral
+}
+
+// SetEphemeral writes data to the cache with an explicit TTL, flagged so it never leaves the process:
+// Snapshot omits it and a TieredConn only stores it in its near tier. It reads like any other entry
+// The Conn must implement EntryConn (e.g. wrap it with NewMetaConn)
+func (oc *OmniCache) SetEphemeral(k, v []byte, ttl time.Duration) error {
+	ec, ok := oc.Conn.(EntryConn)
+	if !ok {
+		return ErrNotSupported
+	}
+	ttl, err := oc.checkTTL(ttl)
+	if err != nil {
+		return err
+	}
+	if err := oc.checkWrite(k, v); err != nil {
+		return err
+	}
+
+	defer oc.observeWrite()
+	defer oc.locks.lock(k).Unlock()
+	oc.written(k)
+	return ec.WriteEntryTTL(k, Entry{Value: v, Ephemeral: true}, ttl)
+}
+
+// SetVersioned stores v with ttl only if version is greater than the version stored for k, reporting
+// false when the write is rejected as stale, so a slow writer can't overwrite a fresher value. Missing,
+// expired and unversioned entries are always replaced. The check and write are atomic with respect
+// to other writes made through this OmniCache. The Conn must implement EntryConn (e.g. a MetaConn)
+func (oc *OmniCache) SetVersioned(k, v []byte, version uint64, ttl time.Duration) (bool, error) {
+	ec, ok := oc.Conn.(EntryConn)
+	if !ok {
+		return false, ErrNotSupported
+	}
+	ttl, err := oc.checkTTL(ttl)
+	if err != nil {
+		return false, err
+	}
+	if err := oc.checkWrite(k, v); err != nil {
+		return false, err
+	}
+
+	defer oc.observeWrite()
+	defer oc.locks.lock(k).Unlock()
+	if e, err := ec.ReadEntry(k); err == nil && version <= e.Version {
+		return false, nil
+	}
+	oc.written(k)
+	if err := ec.WriteEntryTTL(k, Entry{Value: copyBytes(v), Version: version}, ttl); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// SetWithType writes data to the cache with an explicit TTL, tagged with its content type
+// The Conn must implement EntryConn (e.g. wrap it with NewMetaConn)
+func (oc *OmniCache) SetWithType(k, v []byte, contentType string, ttl time.Duration) error {
+	ec, ok := oc.Conn.(EntryConn)
+	if !ok {
+		return ErrNotSupported
+	}
+	ttl, err := oc.checkTTL(ttl)
+	if err != nil {
+		return err
+	}
+	if err := oc.checkWrite(k, v); err != nil {
+		return err
+	}
+
+	defer oc.observeWrite()
+	defer oc.locks.lock(k).Unlock()
+	oc.written(k)
+	return ec.WriteEntryTTL(k, Entry{Value: v, ContentType: contentType}, ttl)
+}
+
+// GetWithType retrieves data for a key along with its content type, returning ErrNotFound if it's missing or expired
+// Entries written without a type, or read from a Conn that doesn't implement EntryConn, report an empty type
+func (oc *OmniCache) GetWithType(k []byte) ([]byte, string, error) {
+	if err := oc.checkKey(k); err != nil {
+		return nil, "", err
+	}
+	ec, ok := oc.Conn.(EntryConn)
+	if !ok {
+		v, err := oc.Conn.Read(k)
+		if err != nil {
+			return nil, "", notFound(err)
+		}
+		return copyBytes(v), "", nil
+	}
+
+	e, err := ec.ReadEntry(k)
+	if err != nil {
+		return nil, "", notFound(err)
+	}
+	return copyBytes(e.Value), e.ContentType, nil
+}
+
+// GetIfChanged retrieves data for a key only if its generation differs from sinceGen, returning the
+// current generation either way. An unchanged entry reports changed unset and a nil value, so callers
+// holding the value can serve it without another copy. A missing or expired key returns ErrNotFound
+// The Conn must implement EntryConn (e.g. a MetaConn)
+func (oc *OmniCache) GetIfChanged(k []byte, sinceGen uint64) (value []byte, gen uint64, changed bool, err error) {
+	if err := oc.checkKey(k); err != nil {
+		return nil, 0, false, err
+	}
+	ec, ok := oc.Conn.(EntryConn)
+	if !ok {
+		return nil, 0, false, ErrNotSupported
+	}
+
+	e, err := ec.ReadEntry(k)
+	if err != nil {
+		return nil, 0, false, notFound(err)
+	}
+	if e.Generation == sinceGen {
+		return nil, e.Generation, false, nil
+	}
+	return copyBytes(e.Value), e.Generation, true, nil
+}
+
+// GetFresh retrieves data for a key only if it was written within maxAge, whatever its TTL
+// An older value is reported as ErrNotFound, like a missing one, but left in place for callers accepting it
+// The Conn must implement EntryConn (e.g. a MetaConn)
+func (oc *OmniCache) GetFresh(k []byte, maxAge time.Duration) ([]byte, error) {
+	if err := oc.checkKey(k); err != nil {
+		return nil, err
+	}
+	ec, ok := oc.Conn.(EntryConn)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+
+	e, err := ec.ReadEntry(k)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	if e.CreatedAt.IsZero() || oc.now().Sub(e.CreatedAt) > maxAge {
+		return nil, ErrNotFound
+	}
+	return copyBytes(e.Value), nil
+}
@@ -0,0 +1,237 @@
+package omnicache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEntryEncoding(t *testing.T) {
+	e := Entry{Value: []byte{1, 2, 3}, ContentType: "application/json", ExpiresAt: time.Unix(0, 2e18).UTC(), SoftExpiresAt: time.Unix(0, 1e18).UTC(), Generation: 42, CreatedAt: time.Unix(0, 5e17).UTC(), Ephemeral: true}
+	d, err := decodeEntry(encodeEntry(e))
+	assert.Nil(t, err)
+	assert.Equal(t, e, d)
+
+	// empty values round trip
+	d, err = decodeEntry(encodeEntry(Entry{}))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(d.Value))
+
+	// raw and truncated values are rejected
+	_, err = decodeEntry([]byte("raw"))
+	assert.Equal(t, ErrInvalidEntry, err)
+	b := encodeEntry(e)
+	_, err = decodeEntry(b[:len(b)-1])
+	assert.Equal(t, ErrInvalidEntry, err)
+}
+
+func TestSetWithType(t *testing.T) {
+	oc := New(NewMetaConn(createConn()))
+	defer oc.Close()
+
+	// typed value
+	err := oc.SetWithType([]byte("doc"), []byte(`{"a":1}`), "application/json", time.Minute)
+	assert.Nil(t, err)
+	b, ct, err := oc.GetWithType([]byte("doc"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte(`{"a":1}`), b)
+	assert.Equal(t, "application/json", ct)
+
+	// plain Get is unaffected by metadata
+	b, err = oc.Get([]byte("doc"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte(`{"a":1}`), b)
+
+	// untyped value
+	err = oc.Set([]byte("plain"), []byte{1})
+	assert.Nil(t, err)
+	b, ct, err = oc.GetWithType([]byte("plain"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{1}, b)
+	assert.Equal(t, "", ct)
+
+	_, _, err = oc.GetWithType([]byte("missing"))
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestSetWithTypeWritten(t *testing.T) {
+	m := newMapConn()
+	oc := New(NewMetaConn(m), WithNegativeCache(time.Minute, 10), WithPeakTracking())
+	defer oc.Close()
+
+	// a typed write clears the negative like Set
+	calls := 0
+	_, err := oc.Fetch([]byte("doc"), notFoundBackfill{calls: &calls})
+	assert.Equal(t, ErrNotFound, err)
+	oc.SetWithType([]byte("doc"), []byte{1}, "image/png", time.Minute)
+	v, err := oc.Fetch([]byte("doc"), notFoundBackfill{calls: &calls})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{1}, v)
+
+	// and is observed for peaks
+	m.Flush()
+	s, err := oc.Stats()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), s["PeakKeyCount"])
+}
+
+func TestSetWithTypeNotSupported(t *testing.T) {
+	oc := New(createConn())
+	defer oc.Close()
+
+	err := oc.SetWithType([]byte("doc"), []byte{1}, "image/png", time.Minute)
+	assert.Equal(t, ErrNotSupported, err)
+
+	oc.Set([]byte("plain"), []byte{1})
+	b, ct, err := oc.GetWithType([]byte("plain"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{1}, b)
+	assert.Equal(t, "", ct)
+	_, _, err = oc.GetWithType([]byte("missing"))
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestGetIfChanged(t *testing.T) {
+	oc := New(NewMetaConn(createConn()))
+	defer oc.Close()
+
+	oc.Set([]byte("page"), []byte("v1"))
+	b, gen, changed, err := oc.GetIfChanged([]byte("page"), 0)
+	assert.Nil(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, []byte("v1"), b)
+
+	// unchanged since gen
+	b, same, changed, err := oc.GetIfChanged([]byte("page"), gen)
+	assert.Nil(t, err)
+	assert.False(t, changed)
+	assert.Nil(t, b)
+	assert.Equal(t, gen, same)
+
+	// overwritten, even with the same value
+	oc.Set([]byte("page"), []byte("v1"))
+	oc.Set([]byte("page"), []byte("v2"))
+	b, next, changed, err := oc.GetIfChanged([]byte("page"), gen)
+	assert.Nil(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, []byte("v2"), b)
+	assert.True(t, next > gen)
+
+	// changed values are copies
+	b[0] = 'x'
+	b, _, _, _ = oc.GetIfChanged([]byte("page"), gen)
+	assert.Equal(t, []byte("v2"), b)
+
+	// cache miss
+	_, _, _, err = oc.GetIfChanged([]byte("missing"), gen)
+	assert.Equal(t, ErrNotFound, err)
+
+	oc = New(createConn())
+	defer oc.Close()
+	_, _, _, err = oc.GetIfChanged([]byte("page"), 0)
+	assert.Equal(t, ErrNotSupported, err)
+}
+
+func TestGetFresh(t *testing.T) {
+	oc := New(NewMetaConn(newMapConn()))
+	defer oc.Close()
+
+	oc.SetWithTTL([]byte("quote"), []byte{1}, time.Minute)
+	b, err := oc.GetFresh([]byte("quote"), 20*time.Millisecond)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{1}, b)
+
+	// too old for this caller, still there for others
+	time.Sleep(30 * time.Millisecond)
+	_, err = oc.GetFresh([]byte("quote"), 20*time.Millisecond)
+	assert.Equal(t, ErrNotFound, err)
+	b, err = oc.GetFresh([]byte("quote"), time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{1}, b)
+	_, err = oc.Get([]byte("quote"))
+	assert.Nil(t, err)
+
+	// values are copies, and missing keys are reported as stale ones
+	b[0] = 2
+	b, _ = oc.GetFresh([]byte("quote"), time.Second)
+	assert.Equal(t, []byte{1}, b)
+	_, err = oc.GetFresh([]byte("missing"), time.Second)
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestMetaReadsClosed(t *testing.T) {
+	oc := New(NewMetaConn(newMapConn()))
+	_, _, err := oc.GetWithType(nil)
+	assert.Equal(t, ErrEmptyKey, err)
+	_, _, _, err = oc.GetIfChanged(nil, 0)
+	assert.Equal(t, ErrEmptyKey, err)
+	_, err = oc.GetFresh(nil, time.Minute)
+	assert.Equal(t, ErrEmptyKey, err)
+
+	oc.Set([]byte("k"), []byte{1})
+	oc.Close()
+	_, _, err = oc.GetWithType([]byte("k"))
+	assert.Equal(t, ErrClosed, err)
+	_, _, _, err = oc.GetIfChanged([]byte("k"), 0)
+	assert.Equal(t, ErrClosed, err)
+	_, err = oc.GetFresh([]byte("k"), time.Minute)
+	assert.Equal(t, ErrClosed, err)
+}
+
+func TestSetVersioned(t *testing.T) {
+	oc := New(NewMetaConn(newMapConn()))
+	defer oc.Close()
+
+	key := []byte("report")
+	stored, err := oc.SetVersioned(key, []byte("v2"), 2, time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, stored)
+
+	// stale and equal versions are rejected
+	stored, err = oc.SetVersioned(key, []byte("v1"), 1, time.Minute)
+	assert.Nil(t, err)
+	assert.False(t, stored)
+	stored, _ = oc.SetVersioned(key, []byte("v2 again"), 2, time.Minute)
+	assert.False(t, stored)
+	b, _ := oc.Get(key)
+	assert.Equal(t, []byte("v2"), b)
+
+	stored, _ = oc.SetVersioned(key, []byte("v3"), 3, time.Minute)
+	assert.True(t, stored)
+	e, err := oc.Conn.(EntryConn).ReadEntry(key)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(3), e.Version)
+	assert.Equal(t, []byte("v3"), e.Value)
+
+	_, err = New(newMapConn()).SetVersioned(key, []byte("v1"), 1, time.Minute)
+	assert.Equal(t, ErrNotSupported, err)
+}
+
+func TestMetaConnClock(t *testing.T) {
+	m, clock := newFakeMapConn()
+	oc := New(NewMetaConn(m), WithClock(clock))
+	defer oc.Close()
+
+	// stamps and checks follow the same clock
+	oc.SetWithTTL([]byte("k"), []byte{1}, time.Minute)
+	clock.Advance(20 * time.Second)
+	ttl, err := oc.TTL([]byte("k"))
+	assert.Nil(t, err)
+	assert.Equal(t, 40*time.Second, ttl)
+	_, ttl, err = oc.GetWithTTL([]byte("k"))
+	assert.Nil(t, err)
+	assert.Equal(t, 40*time.Second, ttl)
+
+	_, err = oc.GetFresh([]byte("k"), 10*time.Second)
+	assert.Equal(t, ErrNotFound, err)
+	b, err := oc.GetFresh([]byte("k"), 30*time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{1}, b)
+
+	touched, err := oc.TouchIfBelow([]byte("k"), 50*time.Second, time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, touched)
+	ttl, _ = oc.TTL([]byte("k"))
+	assert.Equal(t, time.Minute, ttl)
+}
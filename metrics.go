@@ -0,0 +1,111 @@
+package omnicache
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Metric maps a Stats key to a Prometheus metric, shared by WriteMetricsText and exporters such as
+// promcollector so they report the same set
+type Metric struct {
+	Stat string
+	Name string
+	Help string
+	// Type is "gauge" or "counter"
+	Type string
+}
+
+var metrics = []Metric{
+	{"KeyCount", "omnicache_keys", "Number of keys in the cache.", "gauge"},
+	{"Hits", "omnicache_hits_total", "Number of reads that found a live key.", "counter"},
+	{"Misses", "omnicache_misses_total", "Number of reads that found no live key.", "counter"},
+	{"BytesStored", "omnicache_bytes", "Bytes of value data stored in the cache.", "gauge"},
+	{"Evictions", "omnicache_evictions_total", "Number of entries evicted to make room.", "counter"},
+}
+
+// Metrics returns the metrics reported from Stats
+func Metrics() []Metric {
+	return append([]Metric(nil), metrics...)
+}
+
+// Value returns the metric's value in s, a result of Stats, or false if the Conn doesn't report it
+func (m Metric) Value(s map[string]interface{}) (float64, bool) {
+	return statFloat(s[m.Stat])
+}
+
+// WriteMetricsText writes the cache stats to w in the Prometheus text exposition format
+// Stats the Conn doesn't report are omitted
+func (oc *OmniCache) WriteMetricsText(w io.Writer) error {
+	s, err := oc.Stats()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range metrics {
+		v, ok := m.Value(s)
+		if !ok {
+			continue
+		}
+		_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %s\n",
+			m.Name, m.Help, m.Name, m.Type, m.Name, strconv.FormatFloat(v, 'g', -1, 64))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// StatsDocument is the JSON shape of StatsJSON. Stats the Conn doesn't report are zero
+type StatsDocument struct {
+	KeyCount    uint64 `json:"key_count"`
+	Hits        uint64 `json:"hits"`
+	Misses      uint64 `json:"misses"`
+	BytesStored uint64 `json:"bytes_stored"`
+	Evictions   uint64 `json:"evictions"`
+}
+
+// StatsJSON returns the cache stats as a JSON encoded StatsDocument, e.g. for a structured logger, with
+// the same field names and integer types whichever Conn reports them
+func (oc *OmniCache) StatsJSON() ([]byte, error) {
+	s, err := oc.Stats()
+	if err != nil {
+		return nil, err
+	}
+	stat := func(key string) uint64 {
+		v, _ := statFloat(s[key])
+		return uint64(v)
+	}
+	return json.Marshal(StatsDocument{
+		KeyCount:    stat("KeyCount"),
+		Hits:        stat("Hits"),
+		Misses:      stat("Misses"),
+		BytesStored: stat("BytesStored"),
+		Evictions:   stat("Evictions"),
+	})
+}
+
+// statFloat converts a numeric Stats value to a float64
+func statFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
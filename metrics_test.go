@@ -0,0 +1,118 @@
+package omnicache
+
+import (
+	"bufio"
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/panoplymedia/cache"
+	"github.com/stretchr/testify/assert"
+)
+
+// statsConn reports fixed stats for the wrapped Conn
+type statsConn struct {
+	cache.Conn
+	stats map[string]interface{}
+}
+
+func (sc statsConn) Stats() (map[string]interface{}, error) {
+	return sc.stats, nil
+}
+
+// parseMetricsText parses Prometheus text exposition output into metric values,
+// failing the test on malformed lines or samples without a preceding HELP and TYPE
+func parseMetricsText(t *testing.T, b []byte) map[string]float64 {
+	ret := map[string]float64{}
+	described := map[string]int{}
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) >= 3 && fields[0] == "#" && (fields[1] == "HELP" || fields[1] == "TYPE") {
+			described[fields[2]]++
+			continue
+		}
+		if !assert.Len(t, fields, 2, sc.Text()) {
+			continue
+		}
+		assert.Equal(t, 2, described[fields[0]], fields[0])
+		v, err := strconv.ParseFloat(fields[1], 64)
+		assert.Nil(t, err)
+		ret[fields[0]] = v
+	}
+	return ret
+}
+
+func TestWriteMetricsText(t *testing.T) {
+	c := statsConn{
+		Conn: createConn(),
+		stats: map[string]interface{}{
+			"KeyCount":    uint64(3),
+			"Hits":        uint64(10),
+			"Misses":      uint64(4),
+			"BytesStored": uint64(512),
+			"Evictions":   uint64(1),
+		},
+	}
+	oc := New(c)
+	defer oc.Close()
+
+	var buf bytes.Buffer
+	err := oc.WriteMetricsText(&buf)
+	assert.Nil(t, err)
+	assert.Contains(t, buf.String(), "# TYPE omnicache_hits_total counter\n")
+	assert.Equal(t, map[string]float64{
+		"omnicache_keys":            3,
+		"omnicache_hits_total":      10,
+		"omnicache_misses_total":    4,
+		"omnicache_bytes":           512,
+		"omnicache_evictions_total": 1,
+	}, parseMetricsText(t, buf.Bytes()))
+}
+
+func TestWriteMetricsTextPartialStats(t *testing.T) {
+	oc := New(createConn())
+	defer oc.Close()
+
+	oc.Set([]byte("my-key"), []byte{1})
+
+	// the memory store only reports KeyCount, hits and misses are counted by the OmniCache
+	var buf bytes.Buffer
+	err := oc.WriteMetricsText(&buf)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]float64{
+		"omnicache_keys":         1,
+		"omnicache_hits_total":   0,
+		"omnicache_misses_total": 0,
+	}, parseMetricsText(t, buf.Bytes()))
+}
+
+func TestMetrics(t *testing.T) {
+	ms := Metrics()
+	assert.Len(t, ms, 5)
+
+	// a copy, so exporters can't change what WriteMetricsText reports
+	ms[0].Name = "changed"
+	assert.Equal(t, "omnicache_keys", Metrics()[0].Name)
+
+	v, ok := ms[0].Value(map[string]interface{}{"KeyCount": uint32(3)})
+	assert.True(t, ok)
+	assert.Equal(t, float64(3), v)
+	_, ok = ms[0].Value(map[string]interface{}{"KeyCount": "3"})
+	assert.False(t, ok)
+}
+
+func TestStatsJSON(t *testing.T) {
+	oc := New(statsConn{createConn(), map[string]interface{}{
+		"KeyCount":    int64(3),
+		"BytesStored": float64(128),
+		"Backend":     "memory",
+	}})
+	defer oc.Close()
+
+	// mixed stat types are normalized and missing ones default to zero
+	b, err := oc.StatsJSON()
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"key_count":3,"hits":0,"misses":0,"bytes_stored":128,"evictions":0}`, string(b))
+}
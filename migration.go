@@ -0,0 +1,129 @@
+package omnicache
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/panoplymedia/cache"
+)
+
+// Codec converts values to and from a stored serialization format
+// Decode must fail for data that isn't in its format
+type Codec interface {
+	Encode(v []byte) ([]byte, error)
+	Decode(b []byte) ([]byte, error)
+}
+
+// MigrationConn is a cache.Conn middleware for moving stored values between serialization formats
+// Writes are encoded with the new Codec, while reads decode with the new Codec and, until the migration
+// window closes, fall back to the old one, so legacy entries keep working while they drain by TTL
+type MigrationConn struct {
+	conn        cache.Conn
+	from, to    Codec
+	until       time.Time
+	legacyReads uint64
+}
+
+// NewMigrationConn wraps c, migrating stored values from the from Codec to the to Codec over window
+// A zero window disables old format reads right away
+func NewMigrationConn(c cache.Conn, from, to Codec, window time.Duration) *MigrationConn {
+	return &MigrationConn{conn: c, from: from, to: to, until: time.Now().Add(window)}
+}
+
+// Close closes the wrapped Conn
+func (mc *MigrationConn) Close() error {
+	return mc.conn.Close()
+}
+
+// Ping checks the wrapped Conn, succeeding trivially if it doesn't implement Pinger
+func (mc *MigrationConn) Ping() error {
+	return ping(mc.conn)
+}
+
+// Write writes v to the wrapped Conn in the new format
+func (mc *MigrationConn) Write(k, v []byte) error {
+	b, err := mc.to.Encode(v)
+	if err != nil {
+		return err
+	}
+	return mc.conn.Write(k, b)
+}
+
+// WriteTTL writes v to the wrapped Conn in the new format with an explicit TTL
+func (mc *MigrationConn) WriteTTL(k, v []byte, ttl time.Duration) error {
+	b, err := mc.to.Encode(v)
+	if err != nil {
+		return err
+	}
+	return mc.conn.WriteTTL(k, b, ttl)
+}
+
+// Read reads k from the wrapped Conn, decoding the new format or, during the window, the old one
+func (mc *MigrationConn) Read(k []byte) ([]byte, error) {
+	b, err := mc.conn.Read(k)
+	if err != nil {
+		return nil, err
+	}
+	return mc.decode(b)
+}
+
+func (mc *MigrationConn) decode(b []byte) ([]byte, error) {
+	v, err := mc.to.Decode(b)
+	if err == nil || !time.Now().Before(mc.until) {
+		return v, err
+	}
+	if v, oerr := mc.from.Decode(b); oerr == nil {
+		atomic.AddUint64(&mc.legacyReads, 1)
+		return v, nil
+	}
+	return nil, err
+}
+
+// Delete removes k from the wrapped Conn, returning ErrNotSupported if it doesn't implement Deleter
+func (mc *MigrationConn) Delete(k []byte) error {
+	if d, ok := mc.conn.(Deleter); ok {
+		return d.Delete(k)
+	}
+	return ErrNotSupported
+}
+
+// Flush flushes the wrapped Conn, returning ErrNotSupported if it doesn't implement Flusher
+func (mc *MigrationConn) Flush() error {
+	if f, ok := mc.conn.(Flusher); ok {
+		return f.Flush()
+	}
+	return ErrNotSupported
+}
+
+// Range iterates the decoded values of the wrapped Conn, returning ErrNotSupported if it doesn't implement Ranger
+// Values that fail to decode are skipped
+func (mc *MigrationConn) Range(fn func(k, v []byte) bool) error {
+	r, ok := mc.conn.(Ranger)
+	if !ok {
+		return ErrNotSupported
+	}
+	return r.Range(func(k, b []byte) bool {
+		v, err := mc.decode(b)
+		if err != nil {
+			return true
+		}
+		return fn(k, v)
+	})
+}
+
+// Stats provides stats about the wrapped Conn, adding "LegacyReads", the number of reads decoded
+// with the old Codec, to show how far the migration has drained
+func (mc *MigrationConn) Stats() (map[string]interface{}, error) {
+	s, err := mc.conn.Stats()
+	if err != nil {
+		return s, err
+	}
+	s["LegacyReads"] = atomic.LoadUint64(&mc.legacyReads)
+	return s, nil
+}
+
+// ResetStats zeroes "LegacyReads" and resets the wrapped Conn's counters if it implements StatsResetter
+func (mc *MigrationConn) ResetStats() error {
+	atomic.StoreUint64(&mc.legacyReads, 0)
+	return resetStats(mc.conn)
+}
@@ -0,0 +1,66 @@
+package omnicache
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// rawCodec stores values as is
+type rawCodec struct{}
+
+func (rawCodec) Encode(v []byte) ([]byte, error) { return v, nil }
+func (rawCodec) Decode(b []byte) ([]byte, error) { return b, nil }
+
+// prefixCodec stores values behind a format marker
+type prefixCodec struct{}
+
+var prefixMarker = []byte("v2:")
+
+func (prefixCodec) Encode(v []byte) ([]byte, error) {
+	return append(append([]byte(nil), prefixMarker...), v...), nil
+}
+
+func (prefixCodec) Decode(b []byte) ([]byte, error) {
+	if !bytes.HasPrefix(b, prefixMarker) {
+		return nil, errors.New("not v2")
+	}
+	return b[len(prefixMarker):], nil
+}
+
+func TestMigrationConn(t *testing.T) {
+	m := newMapConn()
+	m.Write([]byte("legacy"), []byte("old"))
+
+	mc := NewMigrationConn(m, rawCodec{}, prefixCodec{}, time.Hour)
+	oc := New(mc)
+	defer oc.Close()
+
+	// new writes use the new format
+	oc.Set([]byte("fresh"), []byte("new"))
+	b, err := m.Read([]byte("fresh"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2:new"), b)
+	b, err = oc.Get([]byte("fresh"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new"), b)
+
+	// old entries fall back to the old format
+	b, err = oc.Get([]byte("legacy"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("old"), b)
+	s, err := oc.Stats()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), s["LegacyReads"])
+
+	// after the window
+	mc = NewMigrationConn(m, rawCodec{}, prefixCodec{}, 0)
+	_, err = mc.Read([]byte("legacy"))
+	assert.Errorf(t, err, "not v2")
+	b, err = mc.Read([]byte("fresh"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new"), b)
+}
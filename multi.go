@@ -0,0 +1,185 @@
+package omnicache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/panoplymedia/cache"
+)
+
+// ValueTTL is a value returned by a batch backfill along with the TTL it should be cached for
+type ValueTTL struct {
+	Value []byte
+	TTL   time.Duration
+}
+
+// BatchBackfillTTL is an interface implementing CacheMissMulti that is called once
+// with every key missing from the cache when fetching data via `FetchMultiTTL`
+// Keys omitted from the returned map are treated as not found
+type BatchBackfillTTL interface {
+	CacheMissMulti(keys []string) (map[string]ValueTTL, error)
+}
+
+// GetMulti retrieves data for several keys at once; missing and expired keys are absent from the result
+// Conns implementing MultiReader serve the whole batch, e.g. taking each shard's lock once
+// It returns ErrClosed after Close, or ErrEmptyKey if any key is empty, without reading any
+func (oc *OmniCache) GetMulti(keys [][]byte) (map[string][]byte, error) {
+	return oc.readMulti(keys)
+}
+
+// readMulti reads copies of keys from the Conn, counting hits and misses
+func (oc *OmniCache) readMulti(keys [][]byte) (map[string][]byte, error) {
+	for _, k := range keys {
+		if err := oc.checkKey(k); err != nil {
+			return nil, err
+		}
+	}
+	var ret map[string][]byte
+	if mr, ok := oc.Conn.(MultiReader); ok {
+		ret = mr.ReadMulti(keys)
+	} else {
+		ret = readEach(oc.Conn, keys)
+	}
+	for k, v := range ret {
+		ret[k] = copyBytes(v)
+	}
+	atomic.AddUint64(&oc.reads.hits, uint64(len(ret)))
+	atomic.AddUint64(&oc.reads.misses, uint64(len(keys)-len(ret)))
+	return ret, nil
+}
+
+// readEach reads keys from c one at a time
+func readEach(c cache.Conn, keys [][]byte) map[string][]byte {
+	ret := make(map[string][]byte, len(keys))
+	for _, k := range keys {
+		if v, err := c.Read(k); err == nil {
+			ret[string(k)] = v
+		}
+	}
+	return ret
+}
+
+// FetchMulti gets data from the cache for the specified keys
+// All missing keys are passed to a single call of loader, e.g. to batch them into one query, and each
+// value it returns is stored with Set under the same rules as Fetch: nothing is stored if loader returns
+// ErrDontCache, empty values need WithCacheEmptyValues, keys it omits are negatively cached and the call
+// takes a WithMaxConcurrentBackfills slot. Keys that are neither cached nor loaded are absent from the result
+func (oc *OmniCache) FetchMulti(keys [][]byte, loader func(missingKeys [][]byte) (map[string][]byte, error)) (map[string][]byte, error) {
+	ret, err := oc.readMulti(keys)
+	if err != nil {
+		return nil, err
+	}
+	var missing [][]byte
+	for _, k := range keys {
+		if _, ok := ret[string(k)]; ok {
+			continue
+		}
+		key := oc.sharedKey(k)
+		if oc.negative.has(key, oc.now()) {
+			continue
+		}
+		if v, ok := oc.debounce.get(key, oc.now()); ok {
+			ret[string(k)] = v
+			continue
+		}
+		missing = append(missing, k)
+	}
+
+	if len(missing) == 0 {
+		return ret, nil
+	}
+
+	// the first miss to backfill loads the whole batch, and each is stored as Fetch would store it
+	batch := &batchBackfill{load: func() (map[string][]byte, error) {
+		return loader(missing)
+	}}
+	for _, k := range missing {
+		k := k
+		v, err := oc.fill(context.Background(), k, batch, 0, func(v []byte) error {
+			return oc.Set(k, v)
+		})
+		if err == ErrNotFound || err == errNotLoaded {
+			continue
+		}
+		if err != nil {
+			return ret, err
+		}
+		ret[string(k)] = v
+	}
+
+	return ret, nil
+}
+
+// errNotLoaded is returned by a batchBackfill for keys its ErrDontCache batch omitted
+var errNotLoaded = errors.New("omnicache: key not loaded")
+
+// batchBackfill is a BackfillCache serving each key from a single call to load, made by the first CacheMiss
+type batchBackfill struct {
+	once sync.Once
+	load func() (map[string][]byte, error)
+	vals map[string][]byte
+	err  error
+}
+
+func (bb *batchBackfill) CacheMiss(key string) ([]byte, error) {
+	bb.once.Do(func() {
+		bb.vals, bb.err = bb.load()
+	})
+	if bb.err != nil && bb.err != ErrDontCache {
+		return nil, bb.err
+	}
+	v, ok := bb.vals[key]
+	if !ok && bb.err == ErrDontCache {
+		// omitted from a batch that mustn't be cached, so not negatively cached either
+		return nil, errNotLoaded
+	}
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return v, bb.err
+}
+
+// FetchMultiTTL gets data from the cache for the specified keys
+// All missing keys are backfilled with a single call to BatchBackfillTTL.CacheMissMulti and each
+// result is stored with its own TTL. Keys that are neither cached nor backfilled are absent from the result
+func (oc *OmniCache) FetchMultiTTL(keys [][]byte, b BatchBackfillTTL) (map[string][]byte, error) {
+	ret, err := oc.readMulti(keys)
+	if err != nil {
+		return nil, err
+	}
+	var missing []string
+	for _, k := range keys {
+		if _, ok := ret[string(k)]; !ok {
+			missing = append(missing, string(k))
+		}
+	}
+
+	if len(missing) == 0 {
+		return ret, nil
+	}
+
+	filled, err := b.CacheMissMulti(missing)
+	if err != nil {
+		return ret, err
+	}
+
+	for _, k := range missing {
+		vt, ok := filled[k]
+		if !ok {
+			continue
+		}
+		ttl, err := oc.checkTTL(vt.TTL)
+		if err != nil {
+			return ret, err
+		}
+		if err := oc.writeTTL([]byte(k), vt.Value, ttl); err != nil {
+			return ret, err
+		}
+		ret[k] = vt.Value
+	}
+
+	return ret, nil
+}
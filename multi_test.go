@@ -0,0 +1,198 @@
+package omnicache
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// ttlBatch backfills keys with the TTLs it holds, counting calls
+type ttlBatch struct {
+	ttls  map[string]time.Duration
+	calls *int
+}
+
+func (tb ttlBatch) CacheMissMulti(keys []string) (map[string]ValueTTL, error) {
+	*tb.calls++
+	ret := map[string]ValueTTL{}
+	for _, k := range keys {
+		if ttl, ok := tb.ttls[k]; ok {
+			ret[k] = ValueTTL{Value: []byte(k), TTL: ttl}
+		}
+	}
+	return ret, nil
+}
+
+func TestFetchMultiTTL(t *testing.T) {
+	c, clock := newFakeMapConn()
+	oc := New(c)
+	defer oc.Close()
+
+	calls := 0
+	b := ttlBatch{
+		ttls: map[string]time.Duration{
+			"short": 100 * time.Millisecond,
+			"long":  time.Minute,
+		},
+		calls: &calls,
+	}
+	keys := [][]byte{[]byte("short"), []byte("long"), []byte("absent")}
+
+	// cache miss, single batched backfill
+	m, err := oc.FetchMultiTTL(keys, b)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, map[string][]byte{"short": []byte("short"), "long": []byte("long")}, m)
+
+	// cache hit for both, no backfill
+	m, err = oc.FetchMultiTTL(keys[:2], b)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, calls)
+	assert.Len(t, m, 2)
+
+	// each key expires on its own schedule
+	clock.Advance(150 * time.Millisecond)
+	_, err = oc.Get([]byte("short"))
+	assert.Errorf(t, err, "Key not found")
+	v, err := oc.Get([]byte("long"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("long"), v)
+}
+
+func TestGetMulti(t *testing.T) {
+	a, b := newMapConn(), newMapConn()
+	oc := New(NewRoutingConn(a, b))
+	defer oc.Close()
+
+	var keys [][]byte
+	for i := 0; i < 50; i++ {
+		k := []byte(fmt.Sprintf("rec:%d", i))
+		keys = append(keys, k)
+		if i%5 != 0 {
+			oc.Set(k, []byte{byte(i)})
+		}
+	}
+	oc.SetWithTTL([]byte("rec:1"), []byte{1}, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+
+	// missing and expired keys are absent
+	got, err := oc.GetMulti(keys)
+	assert.Nil(t, err)
+	assert.Len(t, got, 39)
+	assert.Equal(t, []byte{2}, got["rec:2"])
+	_, ok := got["rec:1"]
+	assert.False(t, ok)
+
+	// one batch per shard
+	assert.Equal(t, 1, a.batches)
+	assert.Equal(t, 1, b.batches)
+
+	// without MultiReader
+	oc = New(createConn())
+	defer oc.Close()
+	oc.Set([]byte("x"), []byte{1})
+	got, err = oc.GetMulti([][]byte{[]byte("x"), []byte("y")})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]byte{"x": {1}}, got)
+}
+
+func TestFetchMulti(t *testing.T) {
+	oc := New(newMapConn())
+	defer oc.Close()
+
+	oc.Set([]byte("a"), []byte("cached"))
+	var loaded [][]byte
+	loader := func(missing [][]byte) (map[string][]byte, error) {
+		loaded = append(loaded, missing...)
+		return map[string][]byte{"b": []byte("loaded")}, nil
+	}
+
+	// hits are resolved first and misses loaded in one call
+	got, err := oc.FetchMulti([][]byte{[]byte("a"), []byte("b"), []byte("absent")}, loader)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]byte{"a": []byte("cached"), "b": []byte("loaded")}, got)
+	assert.Equal(t, [][]byte{[]byte("b"), []byte("absent")}, loaded)
+
+	// loaded values were stored
+	loaded = nil
+	got, err = oc.FetchMulti([][]byte{[]byte("a"), []byte("b")}, loader)
+	assert.Nil(t, err)
+	assert.Len(t, got, 2)
+	assert.Nil(t, loaded)
+
+	_, err = oc.FetchMulti([][]byte{[]byte("c")}, func(missing [][]byte) (map[string][]byte, error) {
+		return nil, errors.New("boom")
+	})
+	assert.EqualError(t, err, "boom")
+}
+
+func TestFetchMultiRules(t *testing.T) {
+	oc := New(newMapConn(), WithNegativeCache(time.Minute, 10))
+	defer oc.Close()
+
+	calls := 0
+	loader := func(missing [][]byte) (map[string][]byte, error) {
+		calls++
+		return map[string][]byte{"empty": {}, "v": []byte("v")}, nil
+	}
+	got, err := oc.FetchMulti([][]byte{[]byte("empty"), []byte("v"), []byte("absent")}, loader)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]byte{"empty": {}, "v": []byte("v")}, got)
+
+	// empty values are served but not stored, and omitted keys are negatively cached
+	_, err = oc.Get([]byte("empty"))
+	assert.Errorf(t, err, "Key not found")
+	got, err = oc.FetchMulti([][]byte{[]byte("v"), []byte("absent")}, loader)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]byte{"v": []byte("v")}, got)
+	assert.Equal(t, 1, calls)
+
+	// an ErrDontCache batch is served but nothing is stored, negatives included
+	dontCache := func(missing [][]byte) (map[string][]byte, error) {
+		calls++
+		return map[string][]byte{"d": []byte("d")}, ErrDontCache
+	}
+	got, err = oc.FetchMulti([][]byte{[]byte("d"), []byte("gone")}, dontCache)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]byte{"d": []byte("d")}, got)
+	_, err = oc.Get([]byte("d"))
+	assert.Errorf(t, err, "Key not found")
+	oc.FetchMulti([][]byte{[]byte("gone")}, dontCache)
+	assert.Equal(t, 3, calls)
+}
+
+func TestFetchMultiBackfillSlots(t *testing.T) {
+	oc := New(newMapConn(), WithMaxConcurrentBackfills(1))
+	defer oc.Close()
+
+	release := make(chan struct{})
+	go oc.Fetch([]byte("held"), backfillFunc(func(key string) ([]byte, error) {
+		<-release
+		return []byte(key), nil
+	}))
+	time.Sleep(10 * time.Millisecond)
+
+	// the batch waits for the only slot
+	var loaded int32
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		oc.FetchMulti([][]byte{[]byte("a"), []byte("b")}, func(missing [][]byte) (map[string][]byte, error) {
+			atomic.AddInt32(&loaded, 1)
+			return map[string][]byte{"a": {1}, "b": {2}}, nil
+		})
+	}()
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&loaded))
+
+	close(release)
+	<-done
+	assert.Equal(t, int32(1), loaded)
+	got, err := oc.GetMulti([][]byte{[]byte("a"), []byte("b")})
+	assert.Nil(t, err)
+	assert.Len(t, got, 2)
+}
@@ -0,0 +1,390 @@
+package omnicache
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/panoplymedia/cache"
+)
+
+// Namespace returns a view of the cache that transparently prefixes every key with prefix
+// The view shares the underlying Conn, so stats and the connection lifecycle aren't duplicated,
+// and closing it doesn't close the shared connection. It also shares the parent's key locks,
+// backfills and in-process state, so SetNX, Update and Fetch coalescing stay atomic across views
+// and the parent. The view starts with the parent's configuration, which opts can override for
+// the namespace alone (e.g. WithMinTTL)
+func (oc *OmniCache) Namespace(prefix []byte, opts ...Option) *OmniCache {
+	full := append(append([]byte(nil), oc.prefix...), prefix...)
+	ns := *oc
+	ns.Conn = newPrefixConn(oc.Conn, prefix)
+	ns.prefix = full
+	ns.locks = keyLocks{lockTable: oc.locks.lockTable, prefix: full}
+	ns.closed = &closeState{parent: oc.closed}
+	ns.prewarm = &prewarmState{}
+	ns.inflight = &inflightBackfills{}
+	for _, opt := range opts {
+		opt(&ns)
+	}
+	return &ns
+}
+
+// prefixConn is a cache.Conn prefixing every key before passing it to a shared Conn
+// The atomic writes (ReadWriter, Updater, AbsentWriter, CompareDeleter) are forwarded when the shared
+// Conn implements them, and otherwise made of the reads and writes OmniCache would fall back to, under
+// the key lock OmniCache holds for them
+type prefixConn struct {
+	conn   cache.Conn
+	prefix []byte
+}
+
+// prefixTTLConn is a prefixConn over a TTLReader
+type prefixTTLConn struct {
+	*prefixConn
+	prefixTTL
+}
+
+// prefixEntryConn is a prefixConn over an EntryConn
+type prefixEntryConn struct {
+	*prefixConn
+	prefixEntries
+}
+
+// prefixTTLEntryConn is a prefixConn over a TTLReader and EntryConn
+type prefixTTLEntryConn struct {
+	*prefixConn
+	prefixTTL
+	prefixEntries
+}
+
+// newPrefixConn wraps c, implementing TTLReader and EntryConn (with the interfaces going along
+// with them) only if c does, so OmniCache falls back as it would for c itself
+func newPrefixConn(c cache.Conn, prefix []byte) cache.Conn {
+	pc := &prefixConn{conn: c, prefix: append([]byte(nil), prefix...)}
+	_, ttl := c.(TTLReader)
+	_, entries := c.(EntryConn)
+	switch {
+	case ttl && entries:
+		return &prefixTTLEntryConn{pc, prefixTTL{pc}, prefixEntries{pc}}
+	case ttl:
+		return &prefixTTLConn{pc, prefixTTL{pc}}
+	case entries:
+		return &prefixEntryConn{pc, prefixEntries{pc}}
+	}
+	return pc
+}
+
+func (pc *prefixConn) key(k []byte) []byte {
+	return append(append(make([]byte, 0, len(pc.prefix)+len(k)), pc.prefix...), k...)
+}
+
+// Close leaves the shared Conn open
+func (pc *prefixConn) Close() error {
+	return nil
+}
+
+// Ping checks the shared Conn
+func (pc *prefixConn) Ping() error {
+	return ping(pc.conn)
+}
+
+func (pc *prefixConn) Write(k, v []byte) error {
+	return pc.conn.Write(pc.key(k), v)
+}
+
+func (pc *prefixConn) WriteTTL(k, v []byte, ttl time.Duration) error {
+	return pc.conn.WriteTTL(pc.key(k), v, ttl)
+}
+
+func (pc *prefixConn) Read(k []byte) ([]byte, error) {
+	return pc.conn.Read(pc.key(k))
+}
+
+func (pc *prefixConn) Exists(k []byte) bool {
+	if e, ok := pc.conn.(Exister); ok {
+		return e.Exists(pc.key(k))
+	}
+	_, err := pc.conn.Read(pc.key(k))
+	return err == nil
+}
+
+// ReadMulti reads the prefixed keys in a single ReadMulti when the shared Conn implements MultiReader
+func (pc *prefixConn) ReadMulti(keys [][]byte) map[string][]byte {
+	pks := make([][]byte, len(keys))
+	for i, k := range keys {
+		pks[i] = pc.key(k)
+	}
+	var got map[string][]byte
+	if mr, ok := pc.conn.(MultiReader); ok {
+		got = mr.ReadMulti(pks)
+	} else {
+		got = readEach(pc.conn, pks)
+	}
+
+	ret := make(map[string][]byte, len(got))
+	for k, v := range got {
+		ret[k[len(pc.prefix):]] = v
+	}
+	return ret
+}
+
+func (pc *prefixConn) Delete(k []byte) error {
+	if d, ok := pc.conn.(Deleter); ok {
+		return d.Delete(pc.key(k))
+	}
+	return ErrNotSupported
+}
+
+// Flush deletes the namespace's keys, leaving the rest of the shared Conn alone
+// The shared Conn must implement Ranger and Deleter
+func (pc *prefixConn) Flush() error {
+	d, ok := pc.conn.(Deleter)
+	if !ok {
+		return ErrNotSupported
+	}
+	var keys [][]byte
+	err := pc.Range(func(k, _ []byte) bool {
+		keys = append(keys, pc.key(k))
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if err := d.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Range iterates the namespace's entries with the prefix stripped from their keys
+func (pc *prefixConn) Range(fn func(k, v []byte) bool) error {
+	r, ok := pc.conn.(Ranger)
+	if !ok {
+		return ErrNotSupported
+	}
+	return r.Range(func(k, v []byte) bool {
+		if !bytes.HasPrefix(k, pc.prefix) {
+			return true
+		}
+		return fn(k[len(pc.prefix):], v)
+	})
+}
+
+// Stats provides stats about the shared Conn
+func (pc *prefixConn) Stats() (map[string]interface{}, error) {
+	return pc.conn.Stats()
+}
+
+func (pc *prefixConn) ReadWrite(k, v []byte, ttl time.Duration) ([]byte, bool, error) {
+	if rw, ok := pc.conn.(ReadWriter); ok {
+		return rw.ReadWrite(pc.key(k), v, ttl)
+	}
+	old, rerr := pc.conn.Read(pc.key(k))
+	if err := pc.conn.WriteTTL(pc.key(k), v, ttl); err != nil {
+		return nil, false, err
+	}
+	if rerr != nil {
+		return nil, false, nil
+	}
+	return old, true, nil
+}
+
+func (pc *prefixConn) Update(k []byte, fn func(current []byte, found bool) ([]byte, error), ttl time.Duration) error {
+	if u, ok := pc.conn.(Updater); ok {
+		return u.Update(pc.key(k), fn, ttl)
+	}
+	current, rerr := pc.conn.Read(pc.key(k))
+	v, err := fn(current, rerr == nil)
+	if err != nil {
+		return err
+	}
+	return pc.conn.WriteTTL(pc.key(k), v, ttl)
+}
+
+func (pc *prefixConn) WriteIfAbsent(k, v []byte, ttl time.Duration) (bool, error) {
+	if aw, ok := pc.conn.(AbsentWriter); ok {
+		return aw.WriteIfAbsent(pc.key(k), v, ttl)
+	}
+	if _, err := pc.conn.Read(pc.key(k)); err == nil {
+		return false, nil
+	}
+	if err := pc.conn.WriteTTL(pc.key(k), v, ttl); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// CompareAndDelete returns ErrNotSupported if the shared Conn implements neither CompareDeleter nor Deleter
+func (pc *prefixConn) CompareAndDelete(k, expected []byte) (bool, error) {
+	if cd, ok := pc.conn.(CompareDeleter); ok {
+		return cd.CompareAndDelete(pc.key(k), expected)
+	}
+	d, ok := pc.conn.(Deleter)
+	if !ok {
+		return false, ErrNotSupported
+	}
+	v, err := pc.conn.Read(pc.key(k))
+	if err != nil || !bytes.Equal(v, expected) {
+		return false, nil
+	}
+	if err := d.Delete(pc.key(k)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Touch falls back to rewriting the entry with WriteEntryTTL over an EntryConn, as OmniCache.Touch
+// would, returning ErrNotSupported if the shared Conn implements neither Toucher nor EntryConn
+func (pc *prefixConn) Touch(k []byte, ttl time.Duration) error {
+	if t, ok := pc.conn.(Toucher); ok {
+		return t.Touch(pc.key(k), ttl)
+	}
+	ec, ok := pc.conn.(EntryConn)
+	if !ok {
+		return ErrNotSupported
+	}
+	e, err := ec.ReadEntry(pc.key(k))
+	if err != nil {
+		return err
+	}
+	return ec.WriteEntryTTL(pc.key(k), e, ttl)
+}
+
+// DeletePrefix deletes the namespace's keys starting with prefix, with the shared Conn's DeletePrefix if
+// it implements PrefixDeleter, or else by ranging over it and deleting each, as OmniCache.DeletePrefix would
+func (pc *prefixConn) DeletePrefix(prefix []byte) (int, error) {
+	if pd, ok := pc.conn.(PrefixDeleter); ok {
+		return pd.DeletePrefix(pc.key(prefix))
+	}
+	d, ok := pc.conn.(Deleter)
+	if !ok {
+		return 0, ErrNotSupported
+	}
+	var keys [][]byte
+	err := pc.Range(func(k, _ []byte) bool {
+		if bytes.HasPrefix(k, prefix) {
+			keys = append(keys, pc.key(k))
+		}
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+	for i, k := range keys {
+		if err := d.Delete(k); err != nil {
+			return i, err
+		}
+	}
+	return len(keys), nil
+}
+
+// WriteMulti writes the prefixed entries in a single WriteMulti when the shared Conn implements MultiWriter,
+// one WriteTTL at a time otherwise
+func (pc *prefixConn) WriteMulti(entries map[string][]byte, ttl time.Duration) error {
+	if mw, ok := pc.conn.(MultiWriter); ok {
+		pe := make(map[string][]byte, len(entries))
+		for k, v := range entries {
+			pe[string(pc.prefix)+k] = v
+		}
+		return mw.WriteMulti(pe, ttl)
+	}
+	for k, v := range entries {
+		if err := pc.WriteTTL([]byte(k), v, ttl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteTTLPriority returns ErrNotSupported if the shared Conn doesn't implement PriorityWriter
+func (pc *prefixConn) WriteTTLPriority(k, v []byte, ttl time.Duration, p Priority) error {
+	if pw, ok := pc.conn.(PriorityWriter); ok {
+		return pw.WriteTTLPriority(pc.key(k), v, ttl, p)
+	}
+	return ErrNotSupported
+}
+
+// KeyStats returns ErrNotSupported if the shared Conn doesn't implement KeyStatser
+func (pc *prefixConn) KeyStats(k []byte) (hits, misses uint64, err error) {
+	if ks, ok := pc.conn.(KeyStatser); ok {
+		return ks.KeyStats(pc.key(k))
+	}
+	return 0, 0, ErrNotSupported
+}
+
+// Compact compacts the whole shared Conn, returning ErrNotSupported if it doesn't implement Compacter
+func (pc *prefixConn) Compact() error {
+	if c, ok := pc.conn.(Compacter); ok {
+		return c.Compact()
+	}
+	return ErrNotSupported
+}
+
+// prefixTTL forwards TTLReader to a prefixConn's shared Conn, along with ValueTTLReader and
+// KeepTTLWriter, which are made of Read, TTL and WriteTTL when the shared Conn lacks them
+type prefixTTL struct {
+	pc *prefixConn
+}
+
+func (t prefixTTL) TTL(k []byte) (time.Duration, bool) {
+	return t.pc.conn.(TTLReader).TTL(t.pc.key(k))
+}
+
+func (t prefixTTL) ReadWithTTL(k []byte) ([]byte, time.Duration, bool) {
+	if vr, ok := t.pc.conn.(ValueTTLReader); ok {
+		return vr.ReadWithTTL(t.pc.key(k))
+	}
+	v, err := t.pc.conn.Read(t.pc.key(k))
+	if err != nil {
+		return nil, 0, false
+	}
+	ttl, ok := t.TTL(k)
+	return v, ttl, ok
+}
+
+func (t prefixTTL) WriteKeepTTL(k, v []byte) (bool, error) {
+	if kw, ok := t.pc.conn.(KeepTTLWriter); ok {
+		return kw.WriteKeepTTL(t.pc.key(k), v)
+	}
+	ttl, ok := t.TTL(k)
+	if !ok {
+		return false, nil
+	}
+	if ttl == NoExpiry {
+		ttl = 0
+	}
+	return true, t.pc.conn.WriteTTL(t.pc.key(k), v, ttl)
+}
+
+// prefixEntries forwards EntryConn to a prefixConn's shared Conn, along with EntryRanger,
+// returning ErrNotSupported from RangeEntries if the shared Conn doesn't implement it
+type prefixEntries struct {
+	pc *prefixConn
+}
+
+func (e prefixEntries) ReadEntry(k []byte) (Entry, error) {
+	return e.pc.conn.(EntryConn).ReadEntry(e.pc.key(k))
+}
+
+func (e prefixEntries) WriteEntry(k []byte, en Entry) error {
+	return e.pc.conn.(EntryConn).WriteEntry(e.pc.key(k), en)
+}
+
+func (e prefixEntries) WriteEntryTTL(k []byte, en Entry, ttl time.Duration) error {
+	return e.pc.conn.(EntryConn).WriteEntryTTL(e.pc.key(k), en, ttl)
+}
+
+// RangeEntries iterates the namespace's entries with the prefix stripped from their keys
+func (e prefixEntries) RangeEntries(fn func(k []byte, en Entry) bool) error {
+	er, ok := e.pc.conn.(EntryRanger)
+	if !ok {
+		return ErrNotSupported
+	}
+	return er.RangeEntries(func(k []byte, en Entry) bool {
+		if !bytes.HasPrefix(k, e.pc.prefix) {
+			return true
+		}
+		return fn(k[len(e.pc.prefix):], en)
+	})
+}
@@ -0,0 +1,250 @@
+package omnicache
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNamespace(t *testing.T) {
+	m := newMapConn()
+	oc := New(m)
+	defer oc.Close()
+
+	users := oc.Namespace([]byte("users:"))
+	jobs := oc.Namespace([]byte("jobs:"))
+
+	// the same key doesn't collide across namespaces
+	users.Set([]byte("1"), []byte("alice"))
+	jobs.Set([]byte("1"), []byte("build"))
+	b, err := users.Get([]byte("1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("alice"), b)
+	b, err = jobs.Get([]byte("1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("build"), b)
+
+	// keys are prefixed in the shared Conn
+	b, err = oc.Get([]byte("users:1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("alice"), b)
+
+	// stats are shared and closing a view leaves the Conn open
+	s, err := users.Stats()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), s["KeyCount"])
+	assert.Nil(t, users.Close())
+	_, err = jobs.Get([]byte("1"))
+	assert.Nil(t, err)
+}
+
+func TestNamespaceFetchDelete(t *testing.T) {
+	m := newMapConn()
+	oc := New(m)
+	defer oc.Close()
+
+	users := oc.Namespace([]byte("users:"))
+	oc.Set([]byte("1"), []byte("unprefixed"))
+
+	// cache miss backfills the prefixed key
+	b, err := users.Fetch([]byte("1"), doubler{Value: 1})
+	assert.Nil(t, err)
+	d, _ := decodeDoubler(b)
+	assert.Equal(t, 2, d.Value)
+	ok, _ := oc.Has([]byte("users:1"))
+	assert.True(t, ok)
+
+	got, err := users.GetMulti([][]byte{[]byte("1"), []byte("2")})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]byte{"1": b}, got)
+	assert.Equal(t, 1, m.batches)
+
+	// deleting only touches the namespace
+	assert.Nil(t, users.Delete([]byte("1")))
+	ok, _ = users.Has([]byte("1"))
+	assert.False(t, ok)
+	b, err = oc.Get([]byte("1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("unprefixed"), b)
+}
+
+func TestNamespaceMinTTL(t *testing.T) {
+	m, clock := newFakeMapConn()
+	oc := New(m)
+	defer oc.Close()
+
+	regulated := oc.Namespace([]byte("reg:"), WithMinTTL(time.Hour, RejectTTL))
+	clamped := oc.Namespace([]byte("clamp:"), WithMinTTL(time.Hour, ClampTTL))
+	fast := oc.Namespace([]byte("fast:"))
+
+	err := regulated.SetWithTTL([]byte("k"), []byte{1}, time.Second)
+	assert.Equal(t, ErrTTLTooSmall, err)
+	err = clamped.SetWithTTL([]byte("k"), []byte{1}, time.Millisecond)
+	assert.Nil(t, err)
+	err = fast.SetWithTTL([]byte("k"), []byte{1}, time.Millisecond)
+	assert.Nil(t, err)
+
+	// the floors don't leak to the parent
+	err = oc.SetWithTTL([]byte("k"), []byte{1}, time.Millisecond)
+	assert.Nil(t, err)
+
+	clock.Advance(5 * time.Millisecond)
+	_, err = clamped.Get([]byte("k"))
+	assert.Nil(t, err)
+	_, err = fast.Get([]byte("k"))
+	assert.Errorf(t, err, "Key not found")
+}
+
+func TestNamespaceFlush(t *testing.T) {
+	oc := New(newMapConn())
+	defer oc.Close()
+
+	users := oc.Namespace([]byte("users:"))
+	users.Set([]byte("1"), []byte{1})
+	oc.Set([]byte("other"), []byte{1})
+
+	// only the namespace's keys are removed
+	assert.Nil(t, users.Flush())
+	ok, _ := users.Has([]byte("1"))
+	assert.False(t, ok)
+	ok, _ = oc.Has([]byte("other"))
+	assert.True(t, ok)
+}
+
+func TestNamespaceSharesState(t *testing.T) {
+	oc := New(newMapConn())
+	defer oc.Close()
+
+	// views of a prefix and their parent lock and coalesce the same keys
+	var wins, calls int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if ok, err := oc.Namespace([]byte("job:")).SetNX([]byte("a"), []byte("1"), time.Minute); err == nil && ok {
+				atomic.AddInt32(&wins, 1)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			oc.Namespace([]byte("job:")).Fetch([]byte("b"), countingBackfill{calls: &calls})
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		oc.Fetch([]byte("job:b"), countingBackfill{calls: &calls})
+	}()
+	wg.Wait()
+	assert.Equal(t, int32(1), wins)
+	assert.Equal(t, int32(1), calls)
+	ok, err := oc.SetNX([]byte("job:a"), []byte("2"), time.Minute)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// closing the parent closes its views, but not the other way around
+	view := oc.Namespace([]byte("job:"))
+	assert.Nil(t, view.Namespace([]byte("x:")).Close())
+	assert.Nil(t, view.Set([]byte("c"), []byte("3")))
+	oc.Close()
+	assert.Equal(t, ErrClosed, view.Set([]byte("c"), []byte("3")))
+}
+
+func TestNamespaceCapabilities(t *testing.T) {
+	// a view over a MetaConn keeps entry metadata
+	oc := New(NewMetaConn(newMapConn()))
+	defer oc.Close()
+	view := oc.Namespace([]byte("img:"))
+	assert.Nil(t, view.SetWithType([]byte("1"), []byte("png"), "image/png", time.Minute))
+	b, ct, err := view.GetWithType([]byte("1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("png"), b)
+	assert.Equal(t, "image/png", ct)
+	_, ct, err = oc.GetWithType([]byte("img:1"))
+	assert.Nil(t, err)
+	assert.Equal(t, "image/png", ct)
+	ttl, err := view.TTL([]byte("1"))
+	assert.Nil(t, err)
+	assert.True(t, ttl > 0 && ttl <= time.Minute)
+
+	// a view over a TTLReader reports and keeps TTLs
+	c := &casConn{mapConn: newMapConn()}
+	oc = New(c)
+	defer oc.Close()
+	view = oc.Namespace([]byte("job:"))
+	view.SetWithTTL([]byte("1"), []byte("a"), time.Minute)
+	ttl, err = view.TTL([]byte("1"))
+	assert.Nil(t, err)
+	assert.True(t, ttl > 0 && ttl <= time.Minute)
+	assert.Nil(t, view.SetKeepTTL([]byte("1"), []byte("b")))
+	b, ttl, err = view.GetWithTTL([]byte("1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("b"), b)
+	assert.True(t, ttl > 0 && ttl <= time.Minute)
+	assert.Equal(t, ErrNotFound, view.SetKeepTTL([]byte("2"), []byte("b")))
+
+	// atomic writes use the shared Conn's, or fall back to plain reads and writes
+	deleted, err := view.CompareAndDelete([]byte("1"), []byte("b"))
+	assert.Nil(t, err)
+	assert.True(t, deleted)
+	assert.Equal(t, 1, c.calls)
+	ok, err := view.SetNX([]byte("1"), []byte("c"), time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	old, existed, err := view.GetSet([]byte("1"), []byte("d"), time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, existed)
+	assert.Equal(t, []byte("c"), old)
+	b, err = oc.Get([]byte("job:1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("d"), b)
+}
+
+func TestNamespaceMoreCapabilities(t *testing.T) {
+	// touching a view's key over a MetaConn keeps its generation
+	oc := New(NewMetaConn(newMapConn()))
+	defer oc.Close()
+	view := oc.Namespace([]byte("s:"))
+	view.SetWithTTL([]byte("1"), []byte("a"), time.Minute)
+	_, gen, _, err := view.GetIfChanged([]byte("1"), 0)
+	assert.Nil(t, err)
+	assert.Nil(t, view.Touch([]byte("1"), time.Hour))
+	_, _, changed, err := view.GetIfChanged([]byte("1"), gen)
+	assert.Nil(t, err)
+	assert.False(t, changed)
+
+	// batches, prefix deletes and compaction reach the shared Conn
+	m := newMapConn()
+	oc = New(m)
+	defer oc.Close()
+	view = oc.Namespace([]byte("w:"))
+	assert.Nil(t, view.Warm(map[string][]byte{"a1": {1}, "a2": {2}, "b": {3}}, time.Minute))
+	assert.Equal(t, 1, m.writeBatches)
+	oc.Set([]byte("a3"), []byte{4})
+	n, err := view.DeletePrefix([]byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	assert.True(t, m.Exists([]byte("w:b")))
+	assert.True(t, m.Exists([]byte("a3")))
+	assert.Nil(t, view.Touch([]byte("b"), time.Hour))
+	assert.Nil(t, view.Compact())
+
+	// so do priorities and key stats
+	lc, _ := NewLRUConn(newMapConn(), 10)
+	oc = New(lc)
+	defer oc.Close()
+	view = oc.Namespace([]byte("p:"))
+	assert.Nil(t, view.SetWithPriority([]byte("1"), []byte{1}, time.Minute, PriorityHigh))
+	ks := New(NewKeyStatsConn(newMapConn(), 10))
+	defer ks.Close()
+	ksView := ks.Namespace([]byte("k:"))
+	ksView.Set([]byte("1"), []byte{1})
+	ksView.Get([]byte("1"))
+	hits, _, err := ksView.KeyStats([]byte("1"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), hits)
+}
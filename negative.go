@@ -0,0 +1,141 @@
+package omnicache
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned by Get and the other reads for a missing or expired key, and by a BackfillCache
+// to signal the key doesn't exist upstream. With WithNegativeCache, Fetch remembers the latter and returns
+// ErrNotFound without backfilling again
+var ErrNotFound = errors.New("omnicache: key not found")
+
+// negativeCache is an in-process LRU of keys known not to exist, capped separately
+// from the Conn so a flood of nonexistent keys can't evict real values
+type negativeCache struct {
+	ttl time.Duration
+	max int
+
+	mu        sync.Mutex
+	ll        *list.List
+	items     map[string]*list.Element
+	hits      uint64
+	misses    uint64
+	evictions uint64
+}
+
+type negativeEntry struct {
+	key       string
+	expiresAt time.Time
+}
+
+func newNegativeCache(ttl time.Duration, maxEntries int) *negativeCache {
+	return &negativeCache{
+		ttl:   ttl,
+		max:   maxEntries,
+		ll:    list.New(),
+		items: map[string]*list.Element{},
+	}
+}
+
+// has reports whether key is negatively cached at now, counting a negative hit or miss
+func (nc *negativeCache) has(key string, now time.Time) bool {
+	if nc == nil {
+		return false
+	}
+
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	el, ok := nc.items[key]
+	if ok && now.After(el.Value.(*negativeEntry).expiresAt) {
+		nc.remove(el)
+		ok = false
+	}
+	if !ok {
+		nc.misses++
+		return false
+	}
+	nc.hits++
+	nc.ll.MoveToFront(el)
+	return true
+}
+
+// add negatively caches key from now for ttl, or the cache's ttl if zero, evicting the oldest negatives past the cap
+func (nc *negativeCache) add(key string, now time.Time, ttl time.Duration) {
+	if nc == nil {
+		return
+	}
+	if ttl == 0 {
+		ttl = nc.ttl
+	}
+
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	if el, ok := nc.items[key]; ok {
+		el.Value.(*negativeEntry).expiresAt = now.Add(ttl)
+		nc.ll.MoveToFront(el)
+		return
+	}
+	nc.items[key] = nc.ll.PushFront(&negativeEntry{key: key, expiresAt: now.Add(ttl)})
+	for nc.ll.Len() > nc.max {
+		nc.remove(nc.ll.Back())
+		nc.evictions++
+	}
+}
+
+// forget drops key, e.g. once a real value is written for it
+func (nc *negativeCache) forget(key string) {
+	if nc == nil {
+		return
+	}
+
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	if el, ok := nc.items[key]; ok {
+		nc.remove(el)
+	}
+}
+
+// clear drops every key
+func (nc *negativeCache) clear() {
+	if nc == nil {
+		return
+	}
+
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	nc.ll.Init()
+	nc.items = map[string]*list.Element{}
+}
+
+func (nc *negativeCache) remove(el *list.Element) {
+	nc.ll.Remove(el)
+	delete(nc.items, el.Value.(*negativeEntry).key)
+}
+
+// resetStats zeroes the hit, miss and eviction counters
+func (nc *negativeCache) resetStats() {
+	if nc == nil {
+		return
+	}
+
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	nc.hits, nc.misses, nc.evictions = 0, 0, 0
+}
+
+// stats adds the negative cache's stats to s
+func (nc *negativeCache) stats(s map[string]interface{}) {
+	if nc == nil {
+		return
+	}
+
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	s["NegativeKeyCount"] = uint64(nc.ll.Len())
+	s["NegativeHits"] = nc.hits
+	s["NegativeMisses"] = nc.misses
+	s["NegativeEvictions"] = nc.evictions
+}
@@ -0,0 +1,117 @@
+package omnicache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// notFoundBackfill reports every key as missing upstream, counting calls
+type notFoundBackfill struct {
+	calls *int
+}
+
+func (n notFoundBackfill) CacheMiss(key string) ([]byte, error) {
+	*n.calls++
+	return nil, ErrNotFound
+}
+
+func TestNegativeCache(t *testing.T) {
+	oc := New(createConn(), WithNegativeCache(time.Minute, 10))
+	defer oc.Close()
+
+	calls := 0
+	b := notFoundBackfill{calls: &calls}
+
+	// the first miss backfills, later ones short circuit
+	_, err := oc.Fetch([]byte("nope"), b)
+	assert.Equal(t, ErrNotFound, err)
+	_, err = oc.Fetch([]byte("nope"), b)
+	assert.Equal(t, ErrNotFound, err)
+	assert.Equal(t, 1, calls)
+
+	// writing a real value clears the negative
+	oc.Set([]byte("nope"), []byte{1})
+	v, err := oc.Fetch([]byte("nope"), b)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{1}, v)
+}
+
+func TestNegativeCacheExpiry(t *testing.T) {
+	oc := New(createConn(), WithNegativeCache(20*time.Millisecond, 10))
+	defer oc.Close()
+
+	calls := 0
+	b := notFoundBackfill{calls: &calls}
+	oc.Fetch([]byte("nope"), b)
+	time.Sleep(30 * time.Millisecond)
+	oc.Fetch([]byte("nope"), b)
+	assert.Equal(t, 2, calls)
+}
+
+func TestFetchNegative(t *testing.T) {
+	clock := &fakeClock{t: time.Now()}
+	oc := New(newMapConn(), WithNegativeCache(time.Hour, 10), WithClock(clock))
+	defer oc.Close()
+
+	calls := 0
+	b := notFoundBackfill{calls: &calls}
+
+	// remembered for negTTL rather than the WithNegativeCache ttl
+	_, err := oc.FetchNegative([]byte("nope"), b, time.Minute)
+	assert.Equal(t, ErrNotFound, err)
+	_, err = oc.Fetch([]byte("nope"), b)
+	assert.Equal(t, ErrNotFound, err)
+	assert.Equal(t, 1, calls)
+	clock.Advance(2 * time.Minute)
+	oc.Fetch([]byte("nope"), b)
+	assert.Equal(t, 2, calls)
+
+	// an empty value isn't a negative
+	oc.Set([]byte("empty"), []byte{})
+	v, err := oc.FetchNegative([]byte("empty"), b, time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{}, v)
+
+	_, err = New(newMapConn()).FetchNegative([]byte("nope"), b, time.Minute)
+	assert.Equal(t, ErrNotSupported, err)
+}
+
+func TestNegativeCacheCap(t *testing.T) {
+	oc := New(createConn(), WithNegativeCache(time.Minute, 10))
+	defer oc.Close()
+
+	for i := 0; i < 5; i++ {
+		oc.SetWithTTL([]byte(fmt.Sprintf("real-%d", i)), []byte{byte(i)}, time.Minute)
+	}
+
+	// flood with nonexistent keys
+	calls := 0
+	b := notFoundBackfill{calls: &calls}
+	for i := 0; i < 100; i++ {
+		oc.Fetch([]byte(fmt.Sprintf("scan-%d", i)), b)
+	}
+
+	s, err := oc.Stats()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(10), s["NegativeKeyCount"])
+	assert.Equal(t, uint64(90), s["NegativeEvictions"])
+	assert.Equal(t, uint64(5), s["KeyCount"])
+
+	// real entries are untouched
+	for i := 0; i < 5; i++ {
+		v, err := oc.Get([]byte(fmt.Sprintf("real-%d", i)))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte{byte(i)}, v)
+	}
+
+	// the oldest negatives were evicted, the newest are still held
+	oc.Fetch([]byte("scan-0"), b)
+	oc.Fetch([]byte("scan-99"), b)
+	assert.Equal(t, 101, calls)
+	s, err = oc.Stats()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), s["NegativeHits"])
+}
@@ -0,0 +1,200 @@
+package omnicache
+
+import "time"
+
+// Option configures optional OmniCache behavior in New
+type Option func(*OmniCache)
+
+// WithDefaultBackfillTimeout bounds how long Fetch and FetchWithTTL wait for BackfillCache.CacheMiss
+// before returning ErrBackfillTimeout. A zero duration (the default) waits indefinitely
+func WithDefaultBackfillTimeout(d time.Duration) Option {
+	return func(oc *OmniCache) {
+		oc.backfillTimeout = d
+	}
+}
+
+// WithMaxConcurrentBackfills limits the number of BackfillCache.CacheMiss calls running at once across
+// all keys to n, so a burst of misses can't overwhelm the upstream. Fetch and its variants wait for a
+// free slot, giving up like a backfill would once the backfill timeout elapses or their context is done.
+// A call that was given up on holds its slot until it returns. Zero, the default, is unlimited
+func WithMaxConcurrentBackfills(n int) Option {
+	return func(oc *OmniCache) {
+		oc.backfillSlots = nil
+		if n > 0 {
+			oc.backfillSlots = make(chan struct{}, n)
+		}
+	}
+}
+
+// WithShardFunc makes the OmniCache pick the key lock shard of a key with fn, which must return an index
+// in [0, LockShards), rather than by hashing the whole key. Keys fn maps together share a lock, e.g. hashing
+// only "user:1234" of "user:1234:profile" lets WithShardForKey update all of a user's keys atomically.
+// An index out of range panics. The Conn's own sharding is unaffected
+func WithShardFunc(fn func(key string) int) Option {
+	return func(oc *OmniCache) {
+		oc.locks.lockTable = &lockTable{shard: fn}
+	}
+}
+
+// WithDefaultTTL makes Set, Fetch and the other writes without a TTL of their own store with ttl
+// instead of never expiring. An explicit TTL (SetWithTTL, FetchWithTTL, ...) always wins over it,
+// and it is subject to WithMinTTL, WithMaxTTL and WithTTLJitter like one. Zero, the default, never expires
+func WithDefaultTTL(ttl time.Duration) Option {
+	return func(oc *OmniCache) {
+		oc.defaultTTL = ttl
+	}
+}
+
+// Clock tells the time, so tests can control it
+type Clock interface {
+	Now() time.Time
+}
+
+// WithClock makes the OmniCache tell the time with c rather than the wall clock, for the expiry it
+// tracks itself (e.g. negative caching, debouncing, hot key windows and soft expiry). A Conn implementing
+// ClockSetter (e.g. a MetaConn) is given c for the times it stamps on entries; other expiry kept by the
+// Conn follows the Conn's own clock
+func WithClock(c Clock) Option {
+	return func(oc *OmniCache) {
+		oc.clock = c.Now
+	}
+}
+
+// WithMinTTL sets a floor for explicit TTLs passed to SetWithTTL, FetchWithTTL and FetchMultiTTL
+// A positive TTL below d is handled according to policy, a zero TTL (no expiry) is still allowed
+func WithMinTTL(d time.Duration, policy MinTTLPolicy) Option {
+	return func(oc *OmniCache) {
+		oc.minTTL = d
+		oc.minTTLPolicy = policy
+	}
+}
+
+// WithMaxTTL sets a ceiling for explicit TTLs passed to SetWithTTL, FetchWithTTL and FetchMultiTTL,
+// clamping longer TTLs down to d. A zero TTL (no expiry) is handled according to policy
+func WithMaxTTL(d time.Duration, policy NoExpiryPolicy) Option {
+	return func(oc *OmniCache) {
+		oc.maxTTL = d
+		oc.noExpiryPolicy = policy
+	}
+}
+
+// WithTTLJitter moves every positive explicit TTL by a random amount of up to fraction of it either way
+// (e.g. 0.1 for ±10%), so keys written together don't all expire at once. The jittered TTL stays within
+// WithMinTTL and WithMaxTTL, and a zero TTL (no expiry) isn't jittered. Zero, the default, disables jitter
+func WithTTLJitter(fraction float64) Option {
+	return func(oc *OmniCache) {
+		oc.ttlJitter = fraction
+	}
+}
+
+// WithSnapshotTTLMode selects how Snapshot records entry expiry, RelativeTTL by default
+func WithSnapshotTTLMode(m TTLMode) Option {
+	return func(oc *OmniCache) {
+		oc.snapshotMode = m
+	}
+}
+
+// WithNegativeCache makes Fetch remember keys whose backfill returned ErrNotFound for ttl,
+// returning ErrNotFound for them without calling the backfill again. Negatives are held in
+// process in an LRU of at most maxEntries keys, separate from the Conn, and reported in Stats
+// as NegativeKeyCount, NegativeHits, NegativeMisses and NegativeEvictions
+func WithNegativeCache(ttl time.Duration, maxEntries int) Option {
+	return func(oc *OmniCache) {
+		oc.negative = newNegativeCache(ttl, maxEntries)
+	}
+}
+
+// WithSerializer sets the Serializer FetchInto and FetchTyped encode values with, GobSerializer by default
+func WithSerializer(s Serializer) Option {
+	return func(oc *OmniCache) {
+		oc.serializer = s
+	}
+}
+
+// WithMaxValueBytes rejects writes through the OmniCache of values longer than n bytes with ErrValueTooLarge,
+// e.g. to stop a serialization bug from filling memory with one huge blob. Zero, the default, is unlimited
+func WithMaxValueBytes(n int) Option {
+	return func(oc *OmniCache) {
+		oc.maxValueBytes = n
+	}
+}
+
+// WithCacheEmptyValues makes Fetch and its variants store an empty value returned by BackfillCache.CacheMiss
+// By default an empty value is returned without being stored, so a backfill that fails by returning nothing
+// isn't served from the cache for a whole TTL
+func WithCacheEmptyValues() Option {
+	return func(oc *OmniCache) {
+		oc.cacheEmpty = true
+	}
+}
+
+// WithWriteValidator makes every write through the OmniCache (Set, SetWithTTL, SetWithType, the
+// stores of Fetch and its variants, Restore, ...) call validator first, rejecting the write with
+// the error it returns so nothing is stored
+func WithWriteValidator(validator func(key string, val []byte) error) Option {
+	return func(oc *OmniCache) {
+		oc.validator = validator
+	}
+}
+
+// WithHotKeys tracks per-key write counts in a count-min sketch, reporting keys written more than
+// maxWrites times within a window through HotKeys. With throttle, writes to a key past maxWrites in
+// the current window are rejected with ErrHotKeyThrottled
+func WithHotKeys(maxWrites uint32, window time.Duration, throttle bool) Option {
+	return func(oc *OmniCache) {
+		oc.hot = newHotKeys(maxWrites, window, throttle)
+	}
+}
+
+// WithBackfillDebounce makes Fetch and FetchWithTTL reuse a backfill result for misses on the same key
+// arriving within window of the backfill completing, e.g. when the stored value expired or was evicted
+// right away, acting as a tiny TTL floor on backfill results
+func WithBackfillDebounce(window time.Duration) Option {
+	return func(oc *OmniCache) {
+		oc.debounce = newDebouncer(window)
+	}
+}
+
+// WithPeakTracking records the highest KeyCount and BytesStored the Conn reports, checked after every
+// write through the OmniCache and whenever Stats is called, and reports them in Stats as "PeakKeyCount"
+// and "PeakBytesStored" until ResetPeaks. It costs a Conn.Stats call per write
+func WithPeakTracking() Option {
+	return func(oc *OmniCache) {
+		oc.peaks = &peakWatch{}
+	}
+}
+
+// WithOnEvict calls fn whenever an entry leaves the cache: when deleted through the OmniCache (Delete,
+// DeletePrefix, CompareAndDelete), and when expired or evicted by a Conn implementing EvictNotifier (e.g. an LRUConn).
+// Overwrites and Flush don't notify. fn runs on a single background goroutine so a slow handler can't stall
+// the cache; events arriving while over 1024 are queued are dropped and counted in Stats as "EvictEventsDropped"
+func WithOnEvict(fn func(key string, value []byte, reason EvictReason)) Option {
+	return func(oc *OmniCache) {
+		oc.evict = newEvictNotifier(fn)
+	}
+}
+
+// WithSweepInterval runs Compact every interval in the background, so expired entries that are never
+// read again are reclaimed, until Close. It has no effect unless the Conn implements Compacter, whose
+// Compact should lock one shard at a time to avoid blocking the whole cache. Zero or less disables sweeping
+func WithSweepInterval(interval time.Duration) Option {
+	return func(oc *OmniCache) {
+		oc.sweep = nil
+		if interval > 0 {
+			oc.sweep = &sweeper{interval: interval, stop: make(chan struct{}), done: make(chan struct{})}
+		}
+	}
+}
+
+// WithRefreshOnExpire makes the Conn's janitor hand expired entries to b for regeneration instead
+// of reaping them. Each key is regenerated at most once at a time in the background, with at most
+// concurrency backfills running, and stored with Set. Keys that fail to regenerate are deleted.
+// It has no effect unless the Conn implements ExpireHooker
+func WithRefreshOnExpire(b BackfillCache, concurrency int) Option {
+	return func(oc *OmniCache) {
+		if concurrency < 1 {
+			concurrency = 1
+		}
+		oc.expire = &expireRefresher{backfill: b, sem: make(chan struct{}, concurrency)}
+	}
+}
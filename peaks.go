@@ -0,0 +1,57 @@
+package omnicache
+
+import "sync/atomic"
+
+// peakWatch holds the highest KeyCount and BytesStored seen since the last reset
+type peakWatch struct {
+	keys  uint64
+	bytes uint64
+}
+
+// observe raises the peaks to the values in s
+func (pw *peakWatch) observe(s map[string]interface{}) {
+	if pw == nil {
+		return
+	}
+	if n, ok := statFloat(s["KeyCount"]); ok {
+		atomicMax(&pw.keys, uint64(n))
+	}
+	if n, ok := statFloat(s["BytesStored"]); ok {
+		atomicMax(&pw.bytes, uint64(n))
+	}
+}
+
+// stats adds the peaks to s
+func (pw *peakWatch) stats(s map[string]interface{}) {
+	if pw == nil {
+		return
+	}
+	s["PeakKeyCount"] = atomic.LoadUint64(&pw.keys)
+	s["PeakBytesStored"] = atomic.LoadUint64(&pw.bytes)
+}
+
+// atomicMax raises *addr to v without locking
+func atomicMax(addr *uint64, v uint64) {
+	for {
+		cur := atomic.LoadUint64(addr)
+		if v <= cur || atomic.CompareAndSwapUint64(addr, cur, v) {
+			return
+		}
+	}
+}
+
+// ResetPeaks starts a new measurement interval for WithPeakTracking, lowering the peaks to the current values
+func (oc *OmniCache) ResetPeaks() error {
+	pw := oc.peaks
+	if pw == nil {
+		return nil
+	}
+	atomic.StoreUint64(&pw.keys, 0)
+	atomic.StoreUint64(&pw.bytes, 0)
+	s, err := oc.Conn.Stats()
+	if err != nil {
+		return err
+	}
+	pw.observe(s)
+	return nil
+}
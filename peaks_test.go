@@ -0,0 +1,55 @@
+package omnicache
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPeakTracking(t *testing.T) {
+	u := &usageConn{mapConn: newMapConn()}
+	oc := New(u, WithPeakTracking())
+	defer oc.Close()
+
+	for i := 0; i < 10; i++ {
+		oc.Set([]byte(fmt.Sprintf("k%d", i)), make([]byte, 8))
+	}
+	// shrink behind the OmniCache's back
+	u.keys, u.bytes = 4, 32
+
+	s, err := oc.Stats()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(4), s["KeyCount"])
+	assert.Equal(t, uint64(10), s["PeakKeyCount"])
+	assert.Equal(t, uint64(80), s["PeakBytesStored"])
+
+	// a new interval starts from the current values
+	assert.Nil(t, oc.ResetPeaks())
+	s, err = oc.Stats()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(4), s["PeakKeyCount"])
+	assert.Equal(t, uint64(32), s["PeakBytesStored"])
+
+	// without WithPeakTracking
+	oc = New(newMapConn())
+	s, err = oc.Stats()
+	assert.Nil(t, err)
+	assert.Nil(t, s["PeakKeyCount"])
+}
+
+func TestPeakTrackingGetSet(t *testing.T) {
+	m := newMapConn()
+	oc := New(m, WithPeakTracking())
+	defer oc.Close()
+
+	for i := 0; i < 3; i++ {
+		oc.GetSet([]byte(fmt.Sprintf("k%d", i)), []byte{1}, 0)
+	}
+	m.Flush()
+
+	s, err := oc.Stats()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), s["KeyCount"])
+	assert.Equal(t, uint64(3), s["PeakKeyCount"])
+}
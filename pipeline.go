@@ -0,0 +1,130 @@
+package omnicache
+
+import (
+	"fmt"
+	"time"
+)
+
+// Pipeline buffers writes and deletes to apply together with Exec, e.g. for a batch import
+// It isn't safe for concurrent use
+type Pipeline struct {
+	oc  *OmniCache
+	ops []pipelineOp
+}
+
+type pipelineOp struct {
+	k, v   []byte
+	ttl    time.Duration
+	hasTTL bool
+	del    bool
+}
+
+// PipelineError is returned by Exec when some operations were rejected
+// Errors holds the error of each operation in the order they were queued, nil for those applied
+type PipelineError struct {
+	Errors []error
+}
+
+func (pe *PipelineError) Error() string {
+	n := 0
+	for _, err := range pe.Errors {
+		if err != nil {
+			n++
+		}
+	}
+	return fmt.Sprintf("omnicache: %d of %d pipeline operations failed", n, len(pe.Errors))
+}
+
+// Pipeline returns an empty Pipeline applying its operations to the cache
+func (oc *OmniCache) Pipeline() *Pipeline {
+	return &Pipeline{oc: oc}
+}
+
+// Set queues a write of v to k, as Set writes it. v is copied, so the caller may reuse it afterwards
+func (p *Pipeline) Set(k, v []byte) {
+	p.ops = append(p.ops, pipelineOp{k: copyBytes(k), v: copyBytes(v)})
+}
+
+// SetWithTTL queues a write of v to k with an explicit TTL
+func (p *Pipeline) SetWithTTL(k, v []byte, ttl time.Duration) {
+	p.ops = append(p.ops, pipelineOp{k: copyBytes(k), v: copyBytes(v), ttl: ttl, hasTTL: true})
+}
+
+// Delete queues a delete of k
+func (p *Pipeline) Delete(k []byte) {
+	p.ops = append(p.ops, pipelineOp{k: copyBytes(k), del: true})
+}
+
+// Exec applies the queued operations and empties the Pipeline. Operations are vetted like the
+// OmniCache methods they mirror, and rejected ones are skipped while the others are applied,
+// returning a *PipelineError. The rest are applied grouped by key lock, each taken once, in the
+// order they were queued for any one key
+func (p *Pipeline) Exec() error {
+	oc := p.oc
+	ops := p.ops
+	p.ops = nil
+
+	errs := make([]error, len(ops))
+	failed := false
+	var groups [lockStripes][]int
+	for i := range ops {
+		if errs[i] = oc.vetOp(&ops[i]); errs[i] != nil {
+			failed = true
+			continue
+		}
+		s := oc.locks.stripe(ops[i].k)
+		groups[s] = append(groups[s], i)
+	}
+
+	defer oc.observeWrite()
+	for s, group := range groups {
+		if len(group) == 0 {
+			continue
+		}
+		oc.locks.mu[s].Lock()
+		for _, i := range group {
+			if errs[i] = oc.applyOp(ops[i]); errs[i] != nil {
+				failed = true
+			}
+		}
+		oc.locks.mu[s].Unlock()
+	}
+
+	if failed {
+		return &PipelineError{Errors: errs}
+	}
+	return nil
+}
+
+// vetOp checks op as its OmniCache method would, resolving its TTL
+func (oc *OmniCache) vetOp(op *pipelineOp) error {
+	if op.del {
+		if _, ok := oc.Conn.(Deleter); !ok {
+			return ErrNotSupported
+		}
+		return oc.checkKey(op.k)
+	}
+	if !op.hasTTL && oc.defaultTTL > 0 {
+		op.ttl, op.hasTTL = oc.defaultTTL, true
+	}
+	if op.hasTTL {
+		ttl, err := oc.checkTTL(op.ttl)
+		if err != nil {
+			return err
+		}
+		op.ttl = ttl
+	}
+	return oc.checkWrite(op.k, op.v)
+}
+
+// applyOp applies a vetted op with its key's lock held
+func (oc *OmniCache) applyOp(op pipelineOp) error {
+	if op.del {
+		return oc.deleteHeld(oc.Conn.(Deleter), op.k)
+	}
+	oc.written(op.k)
+	if op.hasTTL {
+		return oc.Conn.WriteTTL(op.k, op.v, op.ttl)
+	}
+	return oc.Conn.Write(op.k, op.v)
+}
@@ -0,0 +1,48 @@
+package omnicache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPipeline(t *testing.T) {
+	oc := New(newMapConn(), WithMaxValueBytes(2))
+	defer oc.Close()
+
+	oc.Set([]byte("stale"), []byte{1})
+
+	p := oc.Pipeline()
+	p.Set([]byte("a"), []byte{1})
+	p.SetWithTTL([]byte("b"), []byte{2}, time.Minute)
+	p.Set([]byte("too-large"), []byte{1, 2, 3})
+	p.Delete([]byte("stale"))
+	// later operations on a key win
+	p.Set([]byte("a"), []byte{3})
+
+	err := p.Exec()
+	pe, ok := err.(*PipelineError)
+	assert.True(t, ok)
+	assert.Equal(t, []error{nil, nil, ErrValueTooLarge, nil, nil}, pe.Errors)
+	assert.Equal(t, "omnicache: 1 of 5 pipeline operations failed", err.Error())
+
+	b, _ := oc.Get([]byte("a"))
+	assert.Equal(t, []byte{3}, b)
+	b, _ = oc.Get([]byte("b"))
+	assert.Equal(t, []byte{2}, b)
+	_, err = oc.Get([]byte("too-large"))
+	assert.Equal(t, ErrNotFound, err)
+	_, err = oc.Get([]byte("stale"))
+	assert.Equal(t, ErrNotFound, err)
+
+	// Exec empties the pipeline
+	assert.Nil(t, p.Exec())
+
+	// deletes need a Deleter
+	p = New(createConn()).Pipeline()
+	p.Set([]byte("a"), []byte{1})
+	p.Delete([]byte("a"))
+	err = p.Exec()
+	assert.Equal(t, []error{nil, ErrNotSupported}, err.(*PipelineError).Errors)
+}
@@ -0,0 +1,150 @@
+package omnicache
+
+import (
+	"container/heap"
+	"container/list"
+)
+
+// EvictionPolicy picks which key an LRUConn evicts when it's over capacity
+// The LRUConn serializes its calls, so implementations needn't be safe for concurrent use
+type EvictionPolicy interface {
+	// OnAdd records a key newly written
+	OnAdd(key string)
+	// OnAccess records a read or overwrite of a key
+	OnAccess(key string)
+	// OnRemove forgets a key that was deleted, expired or evicted; unknown keys are ignored
+	OnRemove(key string)
+	// Evict removes and returns the key to evict, or false if the policy holds no keys
+	Evict() (key string, ok bool)
+}
+
+// listPolicy evicts from the back of a list, moving accessed keys to the front when lru is set
+type listPolicy struct {
+	lru   bool
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+// NewLRUPolicy creates an EvictionPolicy evicting the least recently used key
+func NewLRUPolicy() EvictionPolicy {
+	return &listPolicy{lru: true, ll: list.New(), items: map[string]*list.Element{}}
+}
+
+// NewFIFOPolicy creates an EvictionPolicy evicting the key added first, however often it's accessed
+func NewFIFOPolicy() EvictionPolicy {
+	return &listPolicy{ll: list.New(), items: map[string]*list.Element{}}
+}
+
+func (p *listPolicy) OnAdd(key string) {
+	if _, ok := p.items[key]; !ok {
+		p.items[key] = p.ll.PushFront(key)
+	}
+}
+
+func (p *listPolicy) OnAccess(key string) {
+	if el, ok := p.items[key]; ok && p.lru {
+		p.ll.MoveToFront(el)
+	}
+}
+
+func (p *listPolicy) OnRemove(key string) {
+	if el, ok := p.items[key]; ok {
+		p.ll.Remove(el)
+		delete(p.items, key)
+	}
+}
+
+func (p *listPolicy) Evict() (string, bool) {
+	el := p.ll.Back()
+	if el == nil {
+		return "", false
+	}
+	key := el.Value.(string)
+	p.ll.Remove(el)
+	delete(p.items, key)
+	return key, true
+}
+
+// lfuPolicy evicts the least frequently accessed key, the oldest one among ties
+type lfuPolicy struct {
+	seq   uint64
+	heap  lfuHeap
+	items map[string]*lfuItem
+}
+
+type lfuItem struct {
+	key   string
+	count uint64
+	seq   uint64
+	index int
+}
+
+// NewLFUPolicy creates an EvictionPolicy evicting the least frequently accessed key
+func NewLFUPolicy() EvictionPolicy {
+	return &lfuPolicy{items: map[string]*lfuItem{}}
+}
+
+func (p *lfuPolicy) OnAdd(key string) {
+	if _, ok := p.items[key]; ok {
+		return
+	}
+	p.seq++
+	item := &lfuItem{key: key, count: 1, seq: p.seq}
+	p.items[key] = item
+	heap.Push(&p.heap, item)
+}
+
+func (p *lfuPolicy) OnAccess(key string) {
+	if item, ok := p.items[key]; ok {
+		item.count++
+		heap.Fix(&p.heap, item.index)
+	}
+}
+
+func (p *lfuPolicy) OnRemove(key string) {
+	if item, ok := p.items[key]; ok {
+		heap.Remove(&p.heap, item.index)
+		delete(p.items, key)
+	}
+}
+
+func (p *lfuPolicy) Evict() (string, bool) {
+	if len(p.heap) == 0 {
+		return "", false
+	}
+	item := heap.Pop(&p.heap).(*lfuItem)
+	delete(p.items, item.key)
+	return item.key, true
+}
+
+// lfuHeap is a container/heap of lfuItems, least frequently accessed first
+type lfuHeap []*lfuItem
+
+func (h lfuHeap) Len() int { return len(h) }
+
+func (h lfuHeap) Less(i, j int) bool {
+	if h[i].count != h[j].count {
+		return h[i].count < h[j].count
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h lfuHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *lfuHeap) Push(x interface{}) {
+	item := x.(*lfuItem)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *lfuHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return item
+}
@@ -0,0 +1,52 @@
+package omnicache
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvictionPolicies(t *testing.T) {
+	for name, tc := range map[string]struct {
+		policy  EvictionPolicy
+		evicted string
+	}{
+		// b was read first and most, a last
+		"LRU":  {NewLRUPolicy(), "b"},
+		"LFU":  {NewLFUPolicy(), "a"},
+		"FIFO": {NewFIFOPolicy(), "a"},
+	} {
+		lc, err := NewLRUConn(newMapConn(), 3, WithEvictionPolicy(tc.policy))
+		assert.Nil(t, err)
+		oc := New(lc)
+
+		for _, k := range []string{"a", "b", "c"} {
+			oc.Set([]byte(k), []byte(k))
+		}
+		for _, k := range []string{"b", "b", "c", "a"} {
+			oc.Get([]byte(k))
+		}
+
+		oc.Set([]byte("d"), []byte("d"))
+		keys, _ := oc.Keys()
+		assert.Len(t, keys, 3, name)
+		_, err = oc.Get([]byte(tc.evicted))
+		assert.Equal(t, ErrNotFound, err, name)
+		oc.Close()
+	}
+}
+
+func TestEvictionPolicyRemove(t *testing.T) {
+	for _, p := range []EvictionPolicy{NewLRUPolicy(), NewLFUPolicy(), NewFIFOPolicy()} {
+		p.OnAdd("a")
+		p.OnAdd("b")
+		p.OnRemove("a")
+		p.OnRemove("missing")
+
+		key, ok := p.Evict()
+		assert.True(t, ok)
+		assert.Equal(t, "b", key)
+		_, ok = p.Evict()
+		assert.False(t, ok)
+	}
+}
@@ -0,0 +1,240 @@
+package omnicache
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// prewarmState tracks background Prewarm calls
+type prewarmState struct {
+	wg        sync.WaitGroup
+	remaining int64
+	started   int32
+
+	mu   sync.Mutex
+	errs map[string]error
+}
+
+// Prewarm fetches keys in the background through b, deduplicating keys and running at most
+// concurrency backfills at once. It returns immediately; use AwaitPrewarm to wait for completion,
+// or the "PrewarmRemaining" stat to follow progress. A failing key doesn't abort the others
+func (oc *OmniCache) Prewarm(keys [][]byte, b BackfillCache, concurrency int) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	seen := make(map[string]struct{}, len(keys))
+	var unique [][]byte
+	for _, k := range keys {
+		if _, ok := seen[string(k)]; ok {
+			continue
+		}
+		seen[string(k)] = struct{}{}
+		unique = append(unique, k)
+	}
+
+	p := oc.prewarm
+	atomic.StoreInt32(&p.started, 1)
+	atomic.AddInt64(&p.remaining, int64(len(unique)))
+	p.wg.Add(len(unique))
+
+	sem := make(chan struct{}, concurrency)
+	go func() {
+		for _, k := range unique {
+			sem <- struct{}{}
+			go func(k []byte) {
+				defer func() {
+					<-sem
+					atomic.AddInt64(&p.remaining, -1)
+					p.wg.Done()
+				}()
+				if _, err := oc.Fetch(k, b); err != nil {
+					p.mu.Lock()
+					if p.errs == nil {
+						p.errs = map[string]error{}
+					}
+					p.errs[string(k)] = err
+					p.mu.Unlock()
+				}
+			}(k)
+		}
+	}()
+}
+
+// AwaitPrewarm blocks until every key passed to Prewarm has been backfilled,
+// returning the errors of keys that failed
+func (oc *OmniCache) AwaitPrewarm() map[string]error {
+	p := oc.prewarm
+	p.wg.Wait()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	errs := make(map[string]error, len(p.errs))
+	for k, err := range p.errs {
+		errs[k] = err
+	}
+	return errs
+}
+
+// WarmResult tallies a WarmList run
+type WarmResult struct {
+	Succeeded int
+	Failed    int
+	// Skipped counts keys that were already in the cache
+	Skipped int
+	// Errors holds the backfill or write error of each failed key
+	Errors map[string]error
+}
+
+// WarmOption configures optional WarmList behavior
+type WarmOption func(*warmConfig)
+
+type warmConfig struct {
+	force    bool
+	progress func(WarmResult)
+}
+
+// WarmForce makes WarmList backfill keys that are already in the cache too
+func WarmForce() WarmOption {
+	return func(c *warmConfig) {
+		c.force = true
+	}
+}
+
+// WarmProgress makes WarmList call fn after each key with the running tally, Errors omitted
+// Calls are serialized, so fn sees the counts grow one key at a time
+func WarmProgress(fn func(progress WarmResult)) WarmOption {
+	return func(c *warmConfig) {
+		c.progress = fn
+	}
+}
+
+// WarmList backfills keys through b and stores them as Fetch would, running at most concurrency backfills
+// at once and skipping keys already in the cache unless WarmForce is given. Empty keys fail with ErrEmptyKey,
+// and negatively cached keys with ErrNotFound. It blocks until every key is done, tallying the outcome
+// (see WarmProgress to follow it). Once ctx is done no further keys are started, running backfills give
+// up with ctx's error, and the partial result is returned with ctx's error
+func (oc *OmniCache) WarmList(ctx context.Context, keys [][]byte, b BackfillCache, concurrency int, opts ...WarmOption) (WarmResult, error) {
+	var cfg warmConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	res := WarmResult{Errors: map[string]error{}}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+	tally := func(k []byte, skipped bool, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case skipped:
+			res.Skipped++
+		case err != nil:
+			res.Failed++
+			res.Errors[string(k)] = err
+		default:
+			res.Succeeded++
+		}
+		if cfg.progress != nil {
+			progress := res
+			progress.Errors = nil
+			cfg.progress(progress)
+		}
+	}
+
+loop:
+	for _, k := range keys {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			break loop
+		}
+		wg.Add(1)
+		go func(k []byte) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			store := func(v []byte) error {
+				return oc.Set(k, v)
+			}
+			if !cfg.force {
+				_, hit, err := oc.fetch(ctx, k, b, 0, store)
+				tally(k, hit, err)
+				return
+			}
+			err := oc.checkKey(k)
+			if err == nil {
+				_, err = oc.fill(ctx, k, b, 0, store)
+			}
+			tally(k, false, err)
+		}(k)
+	}
+	wg.Wait()
+
+	return res, ctx.Err()
+}
+
+// Warm stores a batch of entries with ttl, e.g. reference data loaded at startup, overwriting keys that
+// already exist. Every entry is vetted before any is written, so a rejected entry writes nothing. Conns
+// implementing MultiWriter get the whole batch in a single WriteMulti with every key lock held; otherwise
+// entries are grouped by key lock, each taken once. WriteMulti takes a single TTL, so with WithTTLJitter
+// entries are written by key lock instead, each with its own jittered TTL
+func (oc *OmniCache) Warm(entries map[string][]byte, ttl time.Duration) error {
+	ttl, err := oc.boundTTL(ttl)
+	if err != nil {
+		return err
+	}
+	batch := make(map[string][]byte, len(entries))
+	for key, v := range entries {
+		if err := oc.checkWrite([]byte(key), v); err != nil {
+			return err
+		}
+		batch[key] = copyBytes(v)
+	}
+
+	defer oc.observeWrite()
+	if mw, ok := oc.Conn.(MultiWriter); ok && oc.ttlJitter <= 0 {
+		oc.locks.lockAll()
+		defer oc.locks.unlockAll()
+		for key := range batch {
+			oc.written([]byte(key))
+		}
+		return mw.WriteMulti(batch, ttl)
+	}
+
+	var groups [lockStripes][][]byte
+	for key := range batch {
+		k := []byte(key)
+		s := oc.locks.stripe(k)
+		groups[s] = append(groups[s], k)
+	}
+	for s, keys := range groups {
+		if err := oc.warmGroup(s, keys, batch, ttl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// warmGroup writes keys, all guarded by stripe s, from batch under the stripe's lock
+func (oc *OmniCache) warmGroup(s int, keys [][]byte, batch map[string][]byte, ttl time.Duration) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	oc.locks.mu[s].Lock()
+	defer oc.locks.mu[s].Unlock()
+	for _, k := range keys {
+		oc.written(k)
+		if err := oc.Conn.WriteTTL(k, batch[string(k)], oc.jitter(ttl)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
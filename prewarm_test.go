@@ -0,0 +1,207 @@
+package omnicache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/panoplymedia/cache"
+	"github.com/stretchr/testify/assert"
+)
+
+// slowBackfill echoes keys back after a delay, failing for "bad"
+type slowBackfill struct {
+	calls *int32
+}
+
+func (s slowBackfill) CacheMiss(key string) ([]byte, error) {
+	atomic.AddInt32(s.calls, 1)
+	time.Sleep(20 * time.Millisecond)
+	if key == "bad" {
+		return nil, errors.New("upstream failed")
+	}
+	return []byte(key), nil
+}
+
+func TestPrewarm(t *testing.T) {
+	c := newMapConn()
+	oc := New(c)
+	defer oc.Close()
+
+	var calls int32
+	keys := [][]byte{[]byte("a"), []byte("b"), []byte("c"), []byte("a"), []byte("bad")}
+
+	start := time.Now()
+	oc.Prewarm(keys, slowBackfill{calls: &calls}, 2)
+	assert.True(t, time.Since(start) < 20*time.Millisecond)
+
+	s, err := oc.Stats()
+	assert.Nil(t, err)
+	assert.True(t, s["PrewarmRemaining"].(int64) > 0)
+
+	errs := oc.AwaitPrewarm()
+	assert.Len(t, errs, 1)
+	assert.NotNil(t, errs["bad"])
+
+	// duplicates were only backfilled once
+	assert.Equal(t, int32(4), atomic.LoadInt32(&calls))
+
+	for _, k := range []string{"a", "b", "c"} {
+		b, err := oc.Get([]byte(k))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte(k), b)
+	}
+
+	s, err = oc.Stats()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), s["PrewarmRemaining"])
+}
+
+func TestWarmList(t *testing.T) {
+	oc := New(newMapConn())
+	defer oc.Close()
+	oc.Set([]byte("warm"), []byte("cached"))
+
+	var calls int32
+	keys := [][]byte{[]byte("warm"), []byte("a"), []byte("b"), []byte("bad")}
+	res, err := oc.WarmList(context.Background(), keys, slowBackfill{calls: &calls}, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, res.Succeeded)
+	assert.Equal(t, 1, res.Failed)
+	assert.Equal(t, 1, res.Skipped)
+	assert.Len(t, res.Errors, 1)
+	assert.NotNil(t, res.Errors["bad"])
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+	b, err := oc.Get([]byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a"), b)
+
+	// forced
+	res, err = oc.WarmList(context.Background(), keys[:1], slowBackfill{calls: &calls}, 2, WarmForce())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, res.Succeeded)
+	b, _ = oc.Get([]byte("warm"))
+	assert.Equal(t, []byte("warm"), b)
+
+	// cancelled
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	res, err = oc.WarmList(ctx, [][]byte{[]byte("x"), []byte("y")}, slowBackfill{calls: &calls}, 1)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, 1, res.Failed)
+	assert.Equal(t, context.DeadlineExceeded, res.Errors["x"])
+	assert.Equal(t, 0, res.Succeeded)
+}
+
+func TestWarmListFetchRules(t *testing.T) {
+	oc := New(newMapConn(), WithNegativeCache(time.Minute, 10))
+	defer oc.Close()
+
+	misses := 0
+	_, err := oc.Fetch([]byte("gone"), notFoundBackfill{calls: &misses})
+	assert.Equal(t, ErrNotFound, err)
+
+	// empty and negatively cached keys fail without a backfill, even when forced
+	keys := [][]byte{nil, []byte("gone"), []byte("a")}
+	for _, opts := range [][]WarmOption{nil, {WarmForce()}} {
+		var calls int32
+		var progress []WarmResult
+		opts = append(opts, WarmProgress(func(p WarmResult) {
+			progress = append(progress, p)
+		}))
+		res, err := oc.WarmList(context.Background(), keys, slowBackfill{calls: &calls}, 1, opts...)
+		assert.Nil(t, err)
+		assert.Equal(t, ErrEmptyKey, res.Errors[""])
+		assert.Equal(t, ErrNotFound, res.Errors["gone"])
+		assert.Equal(t, 2, res.Failed)
+		assert.Equal(t, 1, res.Succeeded)
+		assert.Equal(t, int32(1), calls)
+
+		// reported after every key
+		assert.Len(t, progress, 3)
+		for i, p := range progress {
+			assert.Equal(t, i+1, p.Succeeded+p.Failed+p.Skipped)
+			assert.Nil(t, p.Errors)
+		}
+	}
+}
+
+func TestWarm(t *testing.T) {
+	m := newMapConn()
+	for _, c := range []cache.Conn{m, createConn()} {
+		oc := New(c)
+
+		oc.Set([]byte("existing"), []byte("old"))
+		entries := map[string][]byte{"existing": []byte("new")}
+		for i := 0; i < 1000; i++ {
+			entries[fmt.Sprint(i)] = []byte{byte(i)}
+		}
+		err := oc.Warm(entries, time.Minute)
+		assert.Nil(t, err)
+
+		// overwrites are counted once
+		s, _ := oc.Stats()
+		assert.Equal(t, uint64(1001), s["KeyCount"])
+		b, _ := oc.Get([]byte("existing"))
+		assert.Equal(t, []byte("new"), b)
+		oc.Close()
+	}
+
+	// a single batch
+	assert.Equal(t, 1, m.writeBatches)
+
+	// a rejected entry writes nothing
+	m = newMapConn()
+	oc := New(m, WithMaxValueBytes(1))
+	defer oc.Close()
+	err := oc.Warm(map[string][]byte{"a": {1}, "b": {1, 2}}, time.Minute)
+	assert.Equal(t, ErrValueTooLarge, err)
+	assert.Len(t, m.dat, 0)
+}
+
+func TestWarmJitter(t *testing.T) {
+	m := newMapConn()
+	oc := New(m, WithTTLJitter(0.5))
+	defer oc.Close()
+
+	entries := map[string][]byte{}
+	for i := 0; i < 20; i++ {
+		entries[fmt.Sprint(i)] = []byte{byte(i)}
+	}
+	assert.Nil(t, oc.Warm(entries, time.Hour))
+
+	// every entry gets its own jittered TTL rather than the batch sharing one
+	assert.Equal(t, 0, m.writeBatches)
+	ttls := map[time.Duration]bool{}
+	for k := range entries {
+		ttl, ok := m.TTL([]byte(k))
+		assert.True(t, ok)
+		ttls[ttl.Round(time.Second)] = true
+	}
+	assert.True(t, len(ttls) > 1)
+}
+
+func BenchmarkWarm(b *testing.B) {
+	entries := map[string][]byte{}
+	for i := 0; i < 10000; i++ {
+		entries[fmt.Sprint(i)] = []byte("value")
+	}
+	oc := New(newMapConn())
+	defer oc.Close()
+
+	b.Run("Warm", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			oc.Warm(entries, time.Minute)
+		}
+	})
+	b.Run("SetWithTTL", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for k, v := range entries {
+				oc.SetWithTTL([]byte(k), v, time.Minute)
+			}
+		}
+	})
+}
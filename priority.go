@@ -0,0 +1,56 @@
+package omnicache
+
+import "time"
+
+// Priority biases which entries capacity eviction drops first
+type Priority int
+
+const (
+	// PriorityLow entries are evicted first, e.g. values that are cheap to recompute
+	PriorityLow Priority = iota - 1
+	// PriorityNormal is the priority of entries written without one
+	PriorityNormal
+	// PriorityHigh entries are evicted last, e.g. values that are expensive to recompute
+	PriorityHigh
+)
+
+const priorityBands = 3
+
+// band returns the index of p's eviction band, lowest priority first
+func (p Priority) band() int {
+	switch {
+	case p < PriorityNormal:
+		return 0
+	case p > PriorityNormal:
+		return 2
+	}
+	return 1
+}
+
+// PriorityWriter is implemented by a cache.Conn that can take an eviction priority for an entry
+type PriorityWriter interface {
+	WriteTTLPriority(k, v []byte, ttl time.Duration, p Priority) error
+}
+
+// SetWithPriority writes data to the cache with an explicit TTL and eviction priority
+// Capacity eviction drops lower priority entries before higher priority ones, using recency only
+// within a priority. The Conn must implement PriorityWriter (e.g. an LRUConn)
+func (oc *OmniCache) SetWithPriority(k, v []byte, ttl time.Duration, p Priority) error {
+	pw, ok := oc.Conn.(PriorityWriter)
+	if !ok {
+		return ErrNotSupported
+	}
+	ttl, err := oc.checkTTL(ttl)
+	if err != nil {
+		return err
+	}
+	if err := oc.checkWrite(k, v); err != nil {
+		return err
+	}
+	v = copyBytes(v)
+
+	defer oc.observeWrite()
+	defer oc.locks.lock(k).Unlock()
+	oc.written(k)
+	return pw.WriteTTLPriority(k, v, ttl, p)
+}
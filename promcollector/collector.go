@@ -0,0 +1,57 @@
+// Package promcollector exposes OmniCache stats as Prometheus metrics, kept apart so the core package
+// doesn't depend on the Prometheus client
+package promcollector
+
+import (
+	"github.com/panoplymedia/local-cache"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// metric is an omnicache.Metric with its Prometheus descriptor
+type metric struct {
+	omnicache.Metric
+	desc *prometheus.Desc
+	typ  prometheus.ValueType
+}
+
+// Collector is a prometheus.Collector reading an OmniCache's Stats on each scrape
+// Stats the Conn doesn't report are omitted rather than reported as errors
+type Collector struct {
+	oc      *omnicache.OmniCache
+	metrics []metric
+}
+
+// New creates a Collector for oc, with constLabels (e.g. the cache's name) added to every metric
+func New(oc *omnicache.OmniCache, constLabels prometheus.Labels) *Collector {
+	var metrics []metric
+	for _, m := range omnicache.Metrics() {
+		typ := prometheus.GaugeValue
+		if m.Type == "counter" {
+			typ = prometheus.CounterValue
+		}
+		metrics = append(metrics, metric{Metric: m, desc: prometheus.NewDesc(m.Name, m.Help, nil, constLabels), typ: typ})
+	}
+	return &Collector{oc: oc, metrics: metrics}
+}
+
+// Describe sends the descriptors of every metric the Collector may report
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range c.metrics {
+		ch <- m.desc
+	}
+}
+
+// Collect reads the cache stats and sends a metric for each one the Conn reports
+// A Stats error is reported as an invalid metric, failing the scrape
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	s, err := c.oc.Stats()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(c.metrics[0].desc, err)
+		return
+	}
+	for _, m := range c.metrics {
+		if v, ok := m.Value(s); ok {
+			ch <- prometheus.MustNewConstMetric(m.desc, m.typ, v)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package promcollector
+
+import (
+	"testing"
+	"time"
+
+	"github.com/panoplymedia/local-cache"
+	"github.com/panoplymedia/omni-cache-memorystore"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollector(t *testing.T) {
+	memCache, _ := memorystorecache.NewCache(time.Second)
+	conn, _ := memCache.Open("")
+	oc := omnicache.New(conn)
+	defer oc.Close()
+
+	oc.Set([]byte("k"), []byte{1})
+	oc.Get([]byte("k"))
+
+	c := New(oc, prometheus.Labels{"cache": "test"})
+	prometheus.NewPedanticRegistry().MustRegister(c)
+
+	// the memorystore Conn doesn't report bytes stored or evictions
+	assert.Equal(t, 3, testutil.CollectAndCount(c))
+	assert.Equal(t, 1, testutil.CollectAndCount(c, "omnicache_keys"))
+	assert.Equal(t, 0, testutil.CollectAndCount(c, "omnicache_evictions_total"))
+}
@@ -0,0 +1,189 @@
+package omnicache
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/panoplymedia/cache"
+)
+
+// Recorded operation names
+const (
+	OpRead     = "Read"
+	OpWrite    = "Write"
+	OpWriteTTL = "WriteTTL"
+	OpDelete   = "Delete"
+)
+
+// Operation is a single cache operation captured by a RecordingConn
+type Operation struct {
+	Time  time.Time     `json:"time"`
+	Op    string        `json:"op"`
+	Key   []byte        `json:"key"`
+	Value []byte        `json:"value,omitempty"`
+	TTL   time.Duration `json:"ttl,omitempty"`
+	Err   string        `json:"err,omitempty"`
+}
+
+// RecordingConn is a cache.Conn middleware that records every Read, Write, WriteTTL and Delete
+// made against the wrapped Conn, along with its outcome, so the sequence can be replayed later
+type RecordingConn struct {
+	conn cache.Conn
+	mu   sync.Mutex
+	ops  []Operation
+}
+
+// NewRecordingConn wraps c with a RecordingConn
+func NewRecordingConn(c cache.Conn) *RecordingConn {
+	return &RecordingConn{conn: c}
+}
+
+func (rc *RecordingConn) record(op Operation, err error) {
+	op.Time = time.Now().UTC()
+	// copy so callers reusing buffers can't rewrite history
+	op.Key = append([]byte(nil), op.Key...)
+	if op.Value != nil {
+		op.Value = append([]byte(nil), op.Value...)
+	}
+	if err != nil {
+		op.Err = err.Error()
+	}
+	rc.mu.Lock()
+	rc.ops = append(rc.ops, op)
+	rc.mu.Unlock()
+}
+
+// Close closes the wrapped Conn
+func (rc *RecordingConn) Close() error {
+	return rc.conn.Close()
+}
+
+// Ping checks the wrapped Conn, succeeding trivially if it doesn't implement Pinger
+func (rc *RecordingConn) Ping() error {
+	return ping(rc.conn)
+}
+
+// Write writes to the wrapped Conn and records the operation
+func (rc *RecordingConn) Write(k, v []byte) error {
+	err := rc.conn.Write(k, v)
+	rc.record(Operation{Op: OpWrite, Key: k, Value: v}, err)
+	return err
+}
+
+// WriteTTL writes to the wrapped Conn with an explicit TTL and records the operation
+func (rc *RecordingConn) WriteTTL(k, v []byte, ttl time.Duration) error {
+	err := rc.conn.WriteTTL(k, v, ttl)
+	rc.record(Operation{Op: OpWriteTTL, Key: k, Value: v, TTL: ttl}, err)
+	return err
+}
+
+// Read reads from the wrapped Conn and records the operation with the value read
+func (rc *RecordingConn) Read(k []byte) ([]byte, error) {
+	v, err := rc.conn.Read(k)
+	rc.record(Operation{Op: OpRead, Key: k, Value: v}, err)
+	return v, err
+}
+
+// Delete removes k from the wrapped Conn and records the operation,
+// returning ErrNotSupported if it doesn't implement Deleter
+func (rc *RecordingConn) Delete(k []byte) error {
+	d, ok := rc.conn.(Deleter)
+	if !ok {
+		return ErrNotSupported
+	}
+	err := d.Delete(k)
+	rc.record(Operation{Op: OpDelete, Key: k}, err)
+	return err
+}
+
+// Flush flushes the wrapped Conn (not recorded), returning ErrNotSupported if it doesn't implement Flusher
+func (rc *RecordingConn) Flush() error {
+	if f, ok := rc.conn.(Flusher); ok {
+		return f.Flush()
+	}
+	return ErrNotSupported
+}
+
+// Stats provides stats about the wrapped Conn (not recorded)
+func (rc *RecordingConn) Stats() (map[string]interface{}, error) {
+	return rc.conn.Stats()
+}
+
+// Operations returns a copy of the operations recorded so far
+func (rc *RecordingConn) Operations() []Operation {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	ops := make([]Operation, len(rc.ops))
+	copy(ops, rc.ops)
+	return ops
+}
+
+// WriteLog writes the recorded operations to w as newline delimited JSON
+func (rc *RecordingConn) WriteLog(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	for _, op := range rc.Operations() {
+		if err := enc.Encode(op); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadLog reads operations previously written with WriteLog
+func ReadLog(r io.Reader) ([]Operation, error) {
+	var ops []Operation
+	dec := json.NewDecoder(r)
+	for {
+		var op Operation
+		err := dec.Decode(&op)
+		if err == io.EOF {
+			return ops, nil
+		}
+		if err != nil {
+			return ops, err
+		}
+		ops = append(ops, op)
+	}
+}
+
+// Replay reads an operation log from r and re-issues each operation, in order, against c
+// An error is returned for the first operation whose outcome differs from the recorded one
+func Replay(r io.Reader, c cache.Conn) error {
+	ops, err := ReadLog(r)
+	if err != nil {
+		return err
+	}
+
+	for i, op := range ops {
+		var v []byte
+		switch op.Op {
+		case OpRead:
+			v, err = c.Read(op.Key)
+		case OpWrite:
+			err = c.Write(op.Key, op.Value)
+		case OpWriteTTL:
+			err = c.WriteTTL(op.Key, op.Value, op.TTL)
+		case OpDelete:
+			d, ok := c.(Deleter)
+			if !ok {
+				return fmt.Errorf("replay: %s %q at %d: %v", op.Op, op.Key, i, ErrNotSupported)
+			}
+			err = d.Delete(op.Key)
+		default:
+			return fmt.Errorf("replay: unknown operation %q at %d", op.Op, i)
+		}
+
+		if (err != nil) != (op.Err != "") {
+			return fmt.Errorf("replay: %s %q at %d returned error %v, recorded %q", op.Op, op.Key, i, err, op.Err)
+		}
+		if op.Op == OpRead && err == nil && !bytes.Equal(v, op.Value) {
+			return fmt.Errorf("replay: %s %q at %d returned %v, recorded %v", op.Op, op.Key, i, v, op.Value)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,74 @@
+package omnicache
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordingConn(t *testing.T) {
+	rc := NewRecordingConn(createConn())
+	oc := New(rc)
+	defer oc.Close()
+
+	// mixed sequence of operations
+	assert.Nil(t, oc.Set([]byte("a"), []byte{1}))
+	assert.Nil(t, oc.SetWithTTL([]byte("b"), []byte{2}, time.Minute))
+	_, err := oc.Get([]byte("missing"))
+	assert.NotNil(t, err)
+	assert.Nil(t, oc.Set([]byte("a"), []byte{3}))
+	b, err := oc.Get([]byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{3}, b)
+
+	ops := rc.Operations()
+	assert.Len(t, ops, 5)
+	assert.Equal(t, OpWriteTTL, ops[1].Op)
+	assert.Equal(t, time.Minute, ops[1].TTL)
+	assert.NotEqual(t, "", ops[2].Err)
+
+	var log bytes.Buffer
+	err = rc.WriteLog(&log)
+	assert.Nil(t, err)
+
+	// replay against a fresh cache
+	fresh := createConn()
+	defer fresh.Close()
+	err = Replay(&log, fresh)
+	assert.Nil(t, err)
+
+	b, err = fresh.Read([]byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{3}, b)
+	b, err = fresh.Read([]byte("b"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{2}, b)
+	_, err = fresh.Read([]byte("missing"))
+	assert.NotNil(t, err)
+}
+
+func TestReplayDiverged(t *testing.T) {
+	rc := NewRecordingConn(createConn())
+	defer rc.Close()
+
+	rc.Write([]byte("a"), []byte{1})
+	rc.Read([]byte("a"))
+
+	var log bytes.Buffer
+	err := rc.WriteLog(&log)
+	assert.Nil(t, err)
+
+	// drop the write so the recorded read can't be reproduced
+	ops, err := ReadLog(&log)
+	assert.Nil(t, err)
+	assert.Len(t, ops, 2)
+	var readOnly bytes.Buffer
+	only := NewRecordingConn(createConn())
+	only.ops = ops[1:]
+	only.WriteLog(&readOnly)
+
+	err = Replay(&readOnly, createConn())
+	assert.Errorf(t, err, "replay: Read")
+}
@@ -0,0 +1,126 @@
+package omnicache
+
+import (
+	"context"
+	"time"
+
+	"github.com/panoplymedia/cache"
+)
+
+// RetryPolicy configures a RetryConn
+type RetryPolicy struct {
+	// MaxAttempts is the total number of tries per operation, including the first
+	MaxAttempts int
+	// Backoff is the wait before the first retry, doubling for each retry after that
+	Backoff time.Duration
+	// Retryable reports whether an error is transient. When nil, errors with a
+	// Temporary() bool method returning true are retried; anything else, including
+	// a backend's not-found error, is returned immediately
+	Retryable func(error) bool
+}
+
+// RetryConn is a cache.Conn middleware that retries transient errors from the wrapped Conn
+type RetryConn struct {
+	conn   cache.Conn
+	policy RetryPolicy
+	ctx    context.Context
+}
+
+// NewRetryConn wraps c, retrying operations according to p
+func NewRetryConn(c cache.Conn, p RetryPolicy) *RetryConn {
+	if p.MaxAttempts < 1 {
+		p.MaxAttempts = 1
+	}
+	if p.Retryable == nil {
+		p.Retryable = isTemporary
+	}
+	return &RetryConn{conn: c, policy: p, ctx: context.Background()}
+}
+
+func isTemporary(err error) bool {
+	t, ok := err.(interface {
+		Temporary() bool
+	})
+	return ok && t.Temporary()
+}
+
+// WithContext returns a copy of the RetryConn that stops retrying once ctx is done
+func (rc *RetryConn) WithContext(ctx context.Context) *RetryConn {
+	c := *rc
+	c.ctx = ctx
+	return &c
+}
+
+// do runs op until it succeeds, fails with a non-retryable error, runs out of attempts
+// or the context is done, returning the last error
+func (rc *RetryConn) do(op func() error) error {
+	var err error
+	wait := rc.policy.Backoff
+	for attempt := 1; ; attempt++ {
+		err = op()
+		if err == nil || attempt >= rc.policy.MaxAttempts || !rc.policy.Retryable(err) {
+			return err
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-rc.ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		wait *= 2
+	}
+}
+
+// Close closes the wrapped Conn
+func (rc *RetryConn) Close() error {
+	return rc.conn.Close()
+}
+
+// Ping checks the wrapped Conn, succeeding trivially if it doesn't implement Pinger
+func (rc *RetryConn) Ping() error {
+	return ping(rc.conn)
+}
+
+// Write writes to the wrapped Conn, retrying transient errors
+func (rc *RetryConn) Write(k, v []byte) error {
+	return rc.do(func() error {
+		return rc.conn.Write(k, v)
+	})
+}
+
+// WriteTTL writes to the wrapped Conn with an explicit TTL, retrying transient errors
+func (rc *RetryConn) WriteTTL(k, v []byte, ttl time.Duration) error {
+	return rc.do(func() error {
+		return rc.conn.WriteTTL(k, v, ttl)
+	})
+}
+
+// Read reads from the wrapped Conn, retrying transient errors
+func (rc *RetryConn) Read(k []byte) ([]byte, error) {
+	var v []byte
+	err := rc.do(func() error {
+		var err error
+		v, err = rc.conn.Read(k)
+		return err
+	})
+	return v, err
+}
+
+// Delete removes k from the wrapped Conn, retrying transient errors
+// ErrNotSupported is returned if the wrapped Conn doesn't implement Deleter
+func (rc *RetryConn) Delete(k []byte) error {
+	d, ok := rc.conn.(Deleter)
+	if !ok {
+		return ErrNotSupported
+	}
+	return rc.do(func() error {
+		return d.Delete(k)
+	})
+}
+
+// Stats provides stats about the wrapped Conn
+func (rc *RetryConn) Stats() (map[string]interface{}, error) {
+	return rc.conn.Stats()
+}
@@ -0,0 +1,99 @@
+package omnicache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type temporaryError struct{}
+
+func (temporaryError) Error() string   { return "connection reset" }
+func (temporaryError) Temporary() bool { return true }
+
+// flakyConn fails the first failures operations with a temporary error
+type flakyConn struct {
+	*mapConn
+	failures int
+	calls    int
+}
+
+func (f *flakyConn) fail() error {
+	f.calls++
+	if f.calls <= f.failures {
+		return temporaryError{}
+	}
+	return nil
+}
+
+func (f *flakyConn) WriteTTL(k, v []byte, ttl time.Duration) error {
+	if err := f.fail(); err != nil {
+		return err
+	}
+	return f.mapConn.WriteTTL(k, v, ttl)
+}
+
+func (f *flakyConn) Read(k []byte) ([]byte, error) {
+	if err := f.fail(); err != nil {
+		return nil, err
+	}
+	return f.mapConn.Read(k)
+}
+
+func TestRetryConn(t *testing.T) {
+	f := &flakyConn{mapConn: newMapConn(), failures: 2}
+	rc := NewRetryConn(f, RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond})
+	defer rc.Close()
+
+	// fails twice then succeeds
+	err := rc.WriteTTL([]byte("k"), []byte{1}, time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, f.calls)
+
+	b, err := rc.Read([]byte("k"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{1}, b)
+
+	// not found isn't retried
+	f.calls, f.failures = 0, 0
+	_, err = rc.Read([]byte("missing"))
+	assert.Errorf(t, err, "Key not found")
+	assert.Equal(t, 1, f.calls)
+}
+
+func TestRetryConnExhausted(t *testing.T) {
+	f := &flakyConn{mapConn: newMapConn(), failures: 5}
+	rc := NewRetryConn(f, RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond})
+
+	err := rc.WriteTTL([]byte("k"), []byte{1}, time.Minute)
+	assert.Equal(t, temporaryError{}, err)
+	assert.Equal(t, 3, f.calls)
+}
+
+func TestRetryConnContext(t *testing.T) {
+	f := &flakyConn{mapConn: newMapConn(), failures: 5}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	rc := NewRetryConn(f, RetryPolicy{MaxAttempts: 5, Backoff: time.Second}).WithContext(ctx)
+
+	// the deadline cuts the backoff short
+	start := time.Now()
+	_, err := rc.Read([]byte("k"))
+	assert.Equal(t, temporaryError{}, err)
+	assert.True(t, time.Since(start) < time.Second)
+	assert.Equal(t, 1, f.calls)
+}
+
+func TestRetryConnPredicate(t *testing.T) {
+	f := &flakyConn{mapConn: newMapConn(), failures: 1}
+	rc := NewRetryConn(f, RetryPolicy{
+		MaxAttempts: 3,
+		Retryable:   func(err error) bool { return err != temporaryError{} },
+	})
+
+	_, err := rc.Read([]byte("k"))
+	assert.Equal(t, temporaryError{}, err)
+	assert.Equal(t, 1, f.calls)
+}
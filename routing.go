@@ -0,0 +1,210 @@
+package omnicache
+
+import (
+	"hash/fnv"
+	"time"
+
+	"github.com/panoplymedia/cache"
+)
+
+// RoutingConn is a cache.Conn that spreads keys across several fully independent child Conns
+// (each with its own locks, janitor and limits) by hashing the key
+type RoutingConn struct {
+	conns []cache.Conn
+}
+
+// NewRoutingConn creates a RoutingConn over conns, which must not be empty
+func NewRoutingConn(conns ...cache.Conn) *RoutingConn {
+	if len(conns) == 0 {
+		panic("omnicache: RoutingConn needs at least one conn")
+	}
+	return &RoutingConn{conns: conns}
+}
+
+// route returns the child Conn owning k
+func (rc *RoutingConn) route(k []byte) cache.Conn {
+	h := fnv.New32a()
+	h.Write(k)
+	return rc.conns[h.Sum32()%uint32(len(rc.conns))]
+}
+
+// Close closes every child, returning the first error
+func (rc *RoutingConn) Close() error {
+	var err error
+	for _, c := range rc.conns {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Ping pings every child, returning the first error
+func (rc *RoutingConn) Ping() error {
+	for _, c := range rc.conns {
+		if err := ping(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write writes to the child owning k
+func (rc *RoutingConn) Write(k, v []byte) error {
+	return rc.route(k).Write(k, v)
+}
+
+// WriteTTL writes to the child owning k with an explicit TTL
+func (rc *RoutingConn) WriteTTL(k, v []byte, ttl time.Duration) error {
+	return rc.route(k).WriteTTL(k, v, ttl)
+}
+
+// Read reads from the child owning k
+func (rc *RoutingConn) Read(k []byte) ([]byte, error) {
+	return rc.route(k).Read(k)
+}
+
+// Delete removes k from the child owning it, returning ErrNotSupported if it doesn't implement Deleter
+func (rc *RoutingConn) Delete(k []byte) error {
+	if d, ok := rc.route(k).(Deleter); ok {
+		return d.Delete(k)
+	}
+	return ErrNotSupported
+}
+
+// Flush flushes every child, returning ErrNotSupported if any child doesn't implement Flusher
+func (rc *RoutingConn) Flush() error {
+	for _, c := range rc.conns {
+		if _, ok := c.(Flusher); !ok {
+			return ErrNotSupported
+		}
+	}
+	for _, c := range rc.conns {
+		if err := c.(Flusher).Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Compact compacts every child, returning ErrNotSupported if any child doesn't implement Compacter
+func (rc *RoutingConn) Compact() error {
+	for _, c := range rc.conns {
+		if _, ok := c.(Compacter); !ok {
+			return ErrNotSupported
+		}
+	}
+	for _, c := range rc.conns {
+		if err := c.(Compacter).Compact(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Range iterates every child in turn, returning ErrNotSupported if any child doesn't implement Ranger
+func (rc *RoutingConn) Range(fn func(k, v []byte) bool) error {
+	for _, c := range rc.conns {
+		if _, ok := c.(Ranger); !ok {
+			return ErrNotSupported
+		}
+	}
+
+	more := true
+	for _, c := range rc.conns {
+		err := c.(Ranger).Range(func(k, v []byte) bool {
+			more = fn(k, v)
+			return more
+		})
+		if err != nil || !more {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadMulti groups keys by child, reading each group with a single ReadMulti on children implementing MultiReader
+func (rc *RoutingConn) ReadMulti(keys [][]byte) map[string][]byte {
+	groups := map[cache.Conn][][]byte{}
+	for _, k := range keys {
+		c := rc.route(k)
+		groups[c] = append(groups[c], k)
+	}
+
+	ret := make(map[string][]byte, len(keys))
+	for c, ks := range groups {
+		var got map[string][]byte
+		if mr, ok := c.(MultiReader); ok {
+			got = mr.ReadMulti(ks)
+		} else {
+			got = readEach(c, ks)
+		}
+		for k, v := range got {
+			ret[k] = v
+		}
+	}
+	return ret
+}
+
+// ResetStats resets the counters of every child implementing StatsResetter, returning the first error
+func (rc *RoutingConn) ResetStats() error {
+	var err error
+	for _, c := range rc.conns {
+		if rerr := resetStats(c); err == nil {
+			err = rerr
+		}
+	}
+	return err
+}
+
+// Stats sums the numeric stats of every child
+func (rc *RoutingConn) Stats() (map[string]interface{}, error) {
+	ret := map[string]interface{}{}
+	for _, c := range rc.conns {
+		s, err := c.Stats()
+		if err != nil {
+			return ret, err
+		}
+		for k, v := range s {
+			ret[k] = addStat(ret[k], v)
+		}
+	}
+	return ret, nil
+}
+
+// ShardKeyCounts returns the KeyCount stat of each child, in the order they were given to NewRoutingConn
+func (rc *RoutingConn) ShardKeyCounts() ([]uint64, error) {
+	ret := make([]uint64, len(rc.conns))
+	for i, c := range rc.conns {
+		s, err := c.Stats()
+		if err != nil {
+			return nil, err
+		}
+		n, _ := statFloat(s["KeyCount"])
+		ret[i] = uint64(n)
+	}
+	return ret, nil
+}
+
+// addStat adds two stats values of the same numeric type, keeping the latest value otherwise
+func addStat(a, b interface{}) interface{} {
+	switch bv := b.(type) {
+	case uint64:
+		if av, ok := a.(uint64); ok {
+			return av + bv
+		}
+	case int64:
+		if av, ok := a.(int64); ok {
+			return av + bv
+		}
+	case int:
+		if av, ok := a.(int); ok {
+			return av + bv
+		}
+	case float64:
+		if av, ok := a.(float64); ok {
+			return av + bv
+		}
+	}
+	return b
+}
@@ -0,0 +1,69 @@
+package omnicache
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoutingConn(t *testing.T) {
+	children := []*mapConn{newMapConn(), newMapConn(), newMapConn()}
+	rc := NewRoutingConn(children[0], children[1], children[2])
+	oc := New(rc)
+	defer oc.Close()
+
+	for i := 0; i < 300; i++ {
+		err := oc.Set([]byte(fmt.Sprintf("key-%d", i)), []byte{byte(i)})
+		assert.Nil(t, err)
+	}
+
+	// keys are spread evenly and each lives in exactly one child
+	for _, c := range children {
+		s, err := c.Stats()
+		assert.Nil(t, err)
+		n := s["KeyCount"].(uint64)
+		assert.True(t, n > 60 && n < 140, fmt.Sprintf("uneven child: %d keys", n))
+	}
+
+	b, err := oc.Get([]byte("key-42"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{42}, b)
+
+	s, err := oc.Stats()
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"KeyCount": uint64(300), "Hits": uint64(1), "Misses": uint64(0)}, s)
+
+	// flush fans out to every child
+	err = rc.Flush()
+	assert.Nil(t, err)
+	s, err = oc.Stats()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), s["KeyCount"])
+}
+
+func TestRoutingConnFlushNotSupported(t *testing.T) {
+	rc := NewRoutingConn(newMapConn(), createConn())
+	defer rc.Close()
+
+	assert.Equal(t, ErrNotSupported, rc.Flush())
+}
+
+func TestShardStats(t *testing.T) {
+	children := []*mapConn{newMapConn(), newMapConn()}
+	rc := NewRoutingConn(children[0], children[1])
+	oc := New(rc)
+	defer oc.Close()
+
+	for i := 0; i < 100; i++ {
+		oc.Set([]byte(fmt.Sprint(i)), []byte{1})
+	}
+
+	counts, err := oc.ShardStats()
+	assert.Nil(t, err)
+	assert.Equal(t, []uint64{uint64(len(children[0].dat)), uint64(len(children[1].dat))}, counts)
+	assert.Equal(t, uint64(100), counts[0]+counts[1])
+
+	_, err = New(newMapConn()).ShardStats()
+	assert.Equal(t, ErrNotSupported, err)
+}
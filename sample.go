@@ -0,0 +1,57 @@
+package omnicache
+
+import (
+	"math/rand"
+	"time"
+)
+
+// EntryInfo describes a sampled entry
+type EntryInfo struct {
+	Key  []byte
+	Size int
+	// TTL is the time remaining until the entry expires, zero if the Conn doesn't record it
+	// (it doesn't implement EntryRanger) or the entry has no recorded expiry
+	TTL time.Duration
+}
+
+// Sample returns up to n live entries chosen uniformly at random, by reservoir sampling over a single
+// pass of the Conn, so every entry is equally likely to be chosen however keys are spread across shards
+// The Conn must implement Ranger, and EntryRanger for remaining TTLs to be reported
+func (oc *OmniCache) Sample(n int) ([]EntryInfo, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+
+	var sample []EntryInfo
+	seen := 0
+	add := func(info EntryInfo) {
+		seen++
+		if len(sample) < n {
+			sample = append(sample, info)
+		} else if i := rand.Intn(seen); i < n {
+			sample[i] = info
+		}
+	}
+	if er, ok := oc.Conn.(EntryRanger); ok {
+		now := oc.now()
+		err := er.RangeEntries(func(k []byte, e Entry) bool {
+			info := EntryInfo{Key: append([]byte(nil), k...), Size: len(e.Value)}
+			if !e.ExpiresAt.IsZero() {
+				info.TTL = e.ExpiresAt.Sub(now)
+			}
+			add(info)
+			return true
+		})
+		return sample, err
+	}
+
+	r, ok := oc.Conn.(Ranger)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	err := r.Range(func(k, v []byte) bool {
+		add(EntryInfo{Key: append([]byte(nil), k...), Size: len(v)})
+		return true
+	})
+	return sample, err
+}
@@ -0,0 +1,57 @@
+package omnicache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSample(t *testing.T) {
+	big, small := newMapConn(), newMapConn()
+	rc := NewRoutingConn(big, small)
+	oc := New(NewMetaConn(rc))
+	defer oc.Close()
+
+	// 900 keys on one shard, 100 on the other
+	var onBig, onSmall int
+	for i := 0; onBig < 900 || onSmall < 100; i++ {
+		k := []byte(fmt.Sprintf("k%d", i))
+		if rc.route(k) == big && onBig < 900 {
+			onBig++
+		} else if rc.route(k) == small && onSmall < 100 {
+			onSmall++
+		} else {
+			continue
+		}
+		oc.SetWithTTL(k, []byte("value"), time.Hour)
+	}
+
+	fromSmall := 0
+	for i := 0; i < 200; i++ {
+		s, err := oc.Sample(50)
+		assert.Nil(t, err)
+		assert.Len(t, s, 50)
+		for _, info := range s {
+			if rc.route(info.Key) == small {
+				fromSmall++
+			}
+		}
+	}
+	assert.InDelta(t, 0.1, float64(fromSmall)/(200*50), 0.02)
+
+	s, err := oc.Sample(1)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, s[0].Size)
+	assert.InDelta(t, float64(time.Hour), float64(s[0].TTL), float64(time.Minute))
+
+	// fewer entries than requested
+	oc = New(newMapConn())
+	defer oc.Close()
+	oc.Set([]byte("a"), []byte{1})
+	s, err = oc.Sample(10)
+	assert.Nil(t, err)
+	assert.Len(t, s, 1)
+	assert.Equal(t, time.Duration(0), s[0].TTL)
+}
@@ -0,0 +1,98 @@
+package omnicache
+
+import "strings"
+
+// Scan returns a copy of every live entry whose key match reports true, e.g. for an admin tool
+// finding entries by a pattern. It visits every entry, O(n) over the cache, so it's meant for admin
+// and debugging rather than the hot path. The Conn must implement Ranger
+func (oc *OmniCache) Scan(match func(key string) bool) (map[string][]byte, error) {
+	ret := map[string][]byte{}
+	err := oc.rangeConn(func(k, v []byte) bool {
+		if key := string(k); match(key) {
+			ret[key] = copyBytes(v)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// ScanPrefix is the same as Scan, matching the keys starting with prefix
+func (oc *OmniCache) ScanPrefix(prefix []byte) (map[string][]byte, error) {
+	p := string(prefix)
+	return oc.Scan(func(key string) bool {
+		return strings.HasPrefix(key, p)
+	})
+}
+
+// ScanRewrite calls fn for each entry with its generation, without holding any lock while fn runs
+// When fn returns rewrite set, v replaces the entry's value (keeping its content type and remaining
+// TTL) only if the entry's generation is unchanged since it was given to fn; otherwise the rewrite is
+// skipped as stale. Keys are gathered before the scan starts, so keys written during it aren't visited
+// The Conn must implement EntryConn and EntryRanger (e.g. a MetaConn over a Ranger)
+func (oc *OmniCache) ScanRewrite(fn func(k, v []byte, gen uint64) (nv []byte, rewrite bool)) (rewritten, skipped int, err error) {
+	ec, ok := oc.Conn.(EntryConn)
+	if !ok {
+		return 0, 0, ErrNotSupported
+	}
+	er, ok := oc.Conn.(EntryRanger)
+	if !ok {
+		return 0, 0, ErrNotSupported
+	}
+
+	var keys [][]byte
+	err = er.RangeEntries(func(k []byte, _ Entry) bool {
+		keys = append(keys, append([]byte(nil), k...))
+		return true
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, k := range keys {
+		e, err := ec.ReadEntry(k)
+		if err != nil {
+			// gone since the keys were gathered
+			continue
+		}
+		nv, rewrite := fn(k, e.Value, e.Generation)
+		if !rewrite {
+			continue
+		}
+
+		ok, err := oc.rewriteIfGen(ec, k, nv, e.Generation)
+		if err != nil {
+			return rewritten, skipped, err
+		}
+		if ok {
+			rewritten++
+		} else {
+			skipped++
+		}
+	}
+	return rewritten, skipped, nil
+}
+
+// rewriteIfGen replaces the value of k if its entry is still at generation gen
+func (oc *OmniCache) rewriteIfGen(ec EntryConn, k, v []byte, gen uint64) (bool, error) {
+	if err := oc.checkWrite(k, v); err != nil {
+		return false, err
+	}
+	defer oc.locks.lock(k).Unlock()
+	e, err := ec.ReadEntry(k)
+	if err != nil || e.Generation != gen {
+		return false, nil
+	}
+
+	e.Value = v
+	if e.ExpiresAt.IsZero() {
+		return true, ec.WriteEntry(k, e)
+	}
+	ttl := e.ExpiresAt.Sub(oc.now())
+	if ttl <= 0 {
+		return false, nil
+	}
+	return true, ec.WriteEntryTTL(k, e, ttl)
+}
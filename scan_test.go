@@ -0,0 +1,73 @@
+package omnicache
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScan(t *testing.T) {
+	m, clock := newFakeMapConn()
+	oc := New(m)
+	defer oc.Close()
+
+	oc.Set([]byte("job:1:pending"), []byte{1})
+	oc.Set([]byte("job:2:done"), []byte{2})
+	oc.Set([]byte("user:1"), []byte{3})
+	oc.SetWithTTL([]byte("job:3:pending"), []byte{4}, time.Millisecond)
+	clock.Advance(5 * time.Millisecond)
+
+	// live matches only
+	ret, err := oc.Scan(func(key string) bool {
+		return strings.HasPrefix(key, "job:") && strings.HasSuffix(key, ":pending")
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]byte{"job:1:pending": {1}}, ret)
+
+	// values are copies
+	ret["job:1:pending"][0] = 9
+	b, _ := oc.Get([]byte("job:1:pending"))
+	assert.Equal(t, []byte{1}, b)
+
+	ret, err = oc.ScanPrefix([]byte("job:"))
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]byte{"job:1:pending": {1}, "job:2:done": {2}}, ret)
+
+	_, err = New(createConn()).ScanPrefix([]byte("job:"))
+	assert.Equal(t, ErrNotSupported, err)
+}
+
+func TestScanRewrite(t *testing.T) {
+	oc := New(NewMetaConn(newMapConn()))
+	defer oc.Close()
+
+	oc.Set([]byte("a"), []byte("old"))
+	oc.Set([]byte("b"), []byte("old"))
+	oc.Set([]byte("c"), []byte("keep"))
+
+	// "b" is overwritten while the scan computes its rewrite
+	rewritten, skipped, err := oc.ScanRewrite(func(k, v []byte, gen uint64) ([]byte, bool) {
+		if string(k) == "b" {
+			oc.Set([]byte("b"), []byte("concurrent"))
+		}
+		return []byte("new"), bytes.Equal(v, []byte("old"))
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, rewritten)
+	assert.Equal(t, 1, skipped)
+
+	v, _ := oc.Get([]byte("a"))
+	assert.Equal(t, []byte("new"), v)
+	v, _ = oc.Get([]byte("b"))
+	assert.Equal(t, []byte("concurrent"), v)
+	v, _ = oc.Get([]byte("c"))
+	assert.Equal(t, []byte("keep"), v)
+
+	oc = New(newMapConn())
+	defer oc.Close()
+	_, _, err = oc.ScanRewrite(func(k, v []byte, gen uint64) ([]byte, bool) { return nil, false })
+	assert.Equal(t, ErrNotSupported, err)
+}
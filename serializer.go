@@ -0,0 +1,60 @@
+package omnicache
+
+import (
+	"bytes"
+	"encoding/gob"
+)
+
+// Serializer converts the values FetchInto and FetchTyped store to and from bytes
+type Serializer interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(b []byte, v interface{}) error
+}
+
+// GobSerializer is a Serializer using encoding/gob, the default
+type GobSerializer struct{}
+
+// Marshal gob encodes v
+func (GobSerializer) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+// Unmarshal gob decodes b into v, which must be a pointer
+func (GobSerializer) Unmarshal(b []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(b)).Decode(v)
+}
+
+// serializerOrDefault returns the WithSerializer Serializer, or a GobSerializer
+func (oc *OmniCache) serializerOrDefault() Serializer {
+	if oc.serializer != nil {
+		return oc.serializer
+	}
+	return GobSerializer{}
+}
+
+// backfillFunc adapts a function to a BackfillCache
+type backfillFunc func(key string) ([]byte, error)
+
+func (f backfillFunc) CacheMiss(key string) ([]byte, error) {
+	return f(key)
+}
+
+// FetchInto is Fetch for values of any type, decoding the cached value into dest, which must be a pointer
+// On a miss, miss is called and its result encoded with the WithSerializer Serializer (gob by default),
+// stored and decoded into dest
+func (oc *OmniCache) FetchInto(k []byte, dest interface{}, miss func() (interface{}, error)) error {
+	s := oc.serializerOrDefault()
+	b, err := oc.Fetch(k, backfillFunc(func(key string) ([]byte, error) {
+		v, err := miss()
+		if err != nil {
+			return nil, err
+		}
+		return s.Marshal(v)
+	}))
+	if err != nil {
+		return err
+	}
+	return s.Unmarshal(b, dest)
+}
@@ -0,0 +1,63 @@
+package omnicache
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type jsonSerializer struct{}
+
+func (jsonSerializer) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonSerializer) Unmarshal(b []byte, v interface{}) error {
+	return json.Unmarshal(b, v)
+}
+
+func TestFetchInto(t *testing.T) {
+	oc := New(createConn())
+	defer oc.Close()
+
+	calls := 0
+	miss := func() (interface{}, error) {
+		calls++
+		return doubler{Value: 4}, nil
+	}
+
+	// cache miss
+	var d doubler
+	err := oc.FetchInto([]byte("d"), &d, miss)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, d.Value)
+
+	// cache hit
+	d = doubler{}
+	err = oc.FetchInto([]byte("d"), &d, miss)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, d.Value)
+	assert.Equal(t, 1, calls)
+
+	err = oc.FetchInto([]byte("e"), &d, func() (interface{}, error) {
+		return nil, errors.New("boom")
+	})
+	assert.EqualError(t, err, "boom")
+}
+
+func TestFetchIntoSerializer(t *testing.T) {
+	oc := New(createConn(), WithSerializer(jsonSerializer{}))
+	defer oc.Close()
+
+	var d doubler
+	err := oc.FetchInto([]byte("d"), &d, func() (interface{}, error) {
+		return doubler{Value: 3}, nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, d.Value)
+
+	b, _ := oc.Get([]byte("d"))
+	assert.Equal(t, []byte(`{"Value":3}`), b)
+}
@@ -0,0 +1,80 @@
+package omnicache
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrWrongShard is returned by a ShardReaderWriter for a key outside its shard
+var ErrWrongShard = errors.New("omnicache: key belongs to another shard")
+
+// ShardReaderWriter reads and writes the keys of a single shard while its lock is held
+type ShardReaderWriter interface {
+	Read(k []byte) ([]byte, error)
+	Write(k, v []byte) error
+	WriteTTL(k, v []byte, ttl time.Duration) error
+}
+
+// WithShardForKey holds the write lock of the shard k belongs to while fn runs, so fn can read and write
+// several keys of that shard (e.g. co-located via a shared hash tag) atomically with respect to other
+// OmniCache writes. The reader/writer given to fn returns ErrWrongShard for keys of other shards and
+// must not be used after fn returns. fn must not call back into the cache, which would deadlock
+func (oc *OmniCache) WithShardForKey(k []byte, fn func(reader ShardReaderWriter) error) error {
+	s := oc.locks.stripe(k)
+	oc.locks.mu[s].Lock()
+	defer oc.locks.mu[s].Unlock()
+	return fn(&shardRW{oc: oc, stripe: s})
+}
+
+// shardRW is the ShardReaderWriter given to WithShardForKey's fn
+type shardRW struct {
+	oc     *OmniCache
+	stripe int
+}
+
+func (rw *shardRW) check(k []byte) error {
+	if rw.oc.locks.stripe(k) != rw.stripe {
+		return ErrWrongShard
+	}
+	return nil
+}
+
+func (rw *shardRW) Read(k []byte) ([]byte, error) {
+	if err := rw.check(k); err != nil {
+		return nil, err
+	}
+	v, err := rw.oc.Conn.Read(k)
+	if err != nil {
+		return nil, err
+	}
+	return copyBytes(v), nil
+}
+
+func (rw *shardRW) Write(k, v []byte) error {
+	if err := rw.check(k); err != nil {
+		return err
+	}
+	if rw.oc.defaultTTL > 0 {
+		return rw.WriteTTL(k, v, rw.oc.defaultTTL)
+	}
+	if err := rw.oc.checkWrite(k, v); err != nil {
+		return err
+	}
+	rw.oc.written(k)
+	return rw.oc.Conn.Write(k, copyBytes(v))
+}
+
+func (rw *shardRW) WriteTTL(k, v []byte, ttl time.Duration) error {
+	if err := rw.check(k); err != nil {
+		return err
+	}
+	ttl, err := rw.oc.checkTTL(ttl)
+	if err != nil {
+		return err
+	}
+	if err := rw.oc.checkWrite(k, v); err != nil {
+		return err
+	}
+	rw.oc.written(k)
+	return rw.oc.Conn.WriteTTL(k, copyBytes(v), ttl)
+}
@@ -0,0 +1,99 @@
+package omnicache
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithShardForKey(t *testing.T) {
+	oc := New(newMapConn())
+	defer oc.Close()
+
+	// find two keys on the same shard and one on another
+	from := []byte("acct:0")
+	var to, other []byte
+	for i := 1; to == nil || other == nil; i++ {
+		k := []byte(fmt.Sprintf("acct:%d", i))
+		if oc.locks.stripe(k) == oc.locks.stripe(from) {
+			if to == nil {
+				to = k
+			}
+		} else if other == nil {
+			other = k
+		}
+	}
+
+	balance := func(v uint64) []byte {
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint64(b, v)
+		return b
+	}
+	oc.Set(from, balance(1000))
+	oc.Set(to, balance(0))
+
+	// concurrent transfers keep the total intact
+	transfer := func(rw ShardReaderWriter) error {
+		f, err := rw.Read(from)
+		if err != nil {
+			return err
+		}
+		d, err := rw.Read(to)
+		if err != nil {
+			return err
+		}
+		if err := rw.Write(from, balance(binary.BigEndian.Uint64(f)-1)); err != nil {
+			return err
+		}
+		return rw.Write(to, balance(binary.BigEndian.Uint64(d)+1))
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Nil(t, oc.WithShardForKey(from, transfer))
+		}()
+	}
+	wg.Wait()
+
+	f, _ := oc.Get(from)
+	b, _ := oc.Get(to)
+	assert.Equal(t, uint64(900), binary.BigEndian.Uint64(f))
+	assert.Equal(t, uint64(100), binary.BigEndian.Uint64(b))
+
+	// keys outside the shard are refused
+	err := oc.WithShardForKey(from, func(rw ShardReaderWriter) error {
+		return rw.Write(other, []byte{1})
+	})
+	assert.Equal(t, ErrWrongShard, err)
+}
+
+func TestWithShardFunc(t *testing.T) {
+	// shard by the user id of "user:<id>:<field>" keys
+	byUser := func(key string) int {
+		var id int
+		fmt.Sscanf(key, "user:%d:", &id)
+		return id % LockShards
+	}
+	oc := New(newMapConn(), WithShardFunc(byUser))
+	defer oc.Close()
+
+	err := oc.WithShardForKey([]byte("user:1:profile"), func(rw ShardReaderWriter) error {
+		assert.Nil(t, rw.Write([]byte("user:1:profile"), []byte{1}))
+		assert.Nil(t, rw.Write([]byte("user:1:settings"), []byte{2}))
+		return rw.Write([]byte("user:2:profile"), []byte{3})
+	})
+	assert.Equal(t, ErrWrongShard, err)
+	b, _ := oc.Get([]byte("user:1:settings"))
+	assert.Equal(t, []byte{2}, b)
+
+	// out of range shards panic
+	oc = New(newMapConn(), WithShardFunc(func(string) int { return LockShards }))
+	assert.Panics(t, func() {
+		oc.Set([]byte("k"), []byte{1})
+	})
+}
@@ -0,0 +1,76 @@
+package omnicache
+
+import "hash/fnv"
+
+const sketchDepth = 4
+
+// countMinSketch estimates recent access frequencies in fixed memory
+// Counters are halved every 10*width increments so old popularity fades
+type countMinSketch struct {
+	width   uint64
+	rows    [sketchDepth][]uint32
+	adds    uint64
+	resetAt uint64
+}
+
+func newCountMinSketch(width int) *countMinSketch {
+	if width < 1 {
+		width = 1
+	}
+	s := &countMinSketch{width: uint64(width), resetAt: 10 * uint64(width)}
+	for i := range s.rows {
+		s.rows[i] = make([]uint32, width)
+	}
+	return s
+}
+
+// indexes derives one counter index per row from two halves of a 64 bit hash
+func (s *countMinSketch) indexes(key string) [sketchDepth]uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	h1, h2 := sum&0xffffffff, sum>>32
+	var idx [sketchDepth]uint64
+	for i := range idx {
+		idx[i] = (h1 + uint64(i)*h2) % s.width
+	}
+	return idx
+}
+
+func (s *countMinSketch) add(key string) {
+	for i, j := range s.indexes(key) {
+		s.rows[i][j]++
+	}
+	s.adds++
+	if s.adds >= s.resetAt {
+		s.age()
+	}
+}
+
+func (s *countMinSketch) estimate(key string) uint32 {
+	var min uint32
+	for i, j := range s.indexes(key) {
+		if c := s.rows[i][j]; i == 0 || c < min {
+			min = c
+		}
+	}
+	return min
+}
+
+func (s *countMinSketch) reset() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] = 0
+		}
+	}
+	s.adds = 0
+}
+
+func (s *countMinSketch) age() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] >>= 1
+		}
+	}
+	s.adds /= 2
+}
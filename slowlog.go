@@ -0,0 +1,96 @@
+package omnicache
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/panoplymedia/cache"
+)
+
+// Logger is the logging hook used by OmniCache middleware; *log.Logger satisfies it
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// SlowLogConn is a cache.Conn middleware logging Read, Write and WriteTTL calls on the wrapped Conn
+// that take longer than a threshold, with the key and elapsed time. It's aimed at remote backends
+type SlowLogConn struct {
+	conn       cache.Conn
+	logger     Logger
+	threshold  time.Duration
+	sampleRate float64
+}
+
+// NewSlowLogConn wraps c, logging to logger a sampleRate fraction (0 to 1) of the operations
+// slower than threshold, so a struggling backend can't flood the log
+func NewSlowLogConn(c cache.Conn, logger Logger, threshold time.Duration, sampleRate float64) *SlowLogConn {
+	return &SlowLogConn{conn: c, logger: logger, threshold: threshold, sampleRate: sampleRate}
+}
+
+func (sc *SlowLogConn) observe(op string, k []byte, start time.Time) {
+	elapsed := time.Since(start)
+	if elapsed < sc.threshold {
+		return
+	}
+	if sc.sampleRate < 1 && rand.Float64() >= sc.sampleRate {
+		return
+	}
+	sc.logger.Printf("omnicache: slow %s %q took %s", op, k, elapsed)
+}
+
+// Close closes the wrapped Conn
+func (sc *SlowLogConn) Close() error {
+	return sc.conn.Close()
+}
+
+// Ping checks the wrapped Conn, succeeding trivially if it doesn't implement Pinger
+func (sc *SlowLogConn) Ping() error {
+	return ping(sc.conn)
+}
+
+// Write writes to the wrapped Conn
+func (sc *SlowLogConn) Write(k, v []byte) error {
+	defer sc.observe(OpWrite, k, time.Now())
+	return sc.conn.Write(k, v)
+}
+
+// WriteTTL writes to the wrapped Conn with an explicit TTL
+func (sc *SlowLogConn) WriteTTL(k, v []byte, ttl time.Duration) error {
+	defer sc.observe(OpWriteTTL, k, time.Now())
+	return sc.conn.WriteTTL(k, v, ttl)
+}
+
+// Read reads from the wrapped Conn
+func (sc *SlowLogConn) Read(k []byte) ([]byte, error) {
+	defer sc.observe(OpRead, k, time.Now())
+	return sc.conn.Read(k)
+}
+
+// Delete removes k from the wrapped Conn, returning ErrNotSupported if it doesn't implement Deleter
+func (sc *SlowLogConn) Delete(k []byte) error {
+	if d, ok := sc.conn.(Deleter); ok {
+		return d.Delete(k)
+	}
+	return ErrNotSupported
+}
+
+// Flush flushes the wrapped Conn, returning ErrNotSupported if it doesn't implement Flusher
+func (sc *SlowLogConn) Flush() error {
+	if f, ok := sc.conn.(Flusher); ok {
+		return f.Flush()
+	}
+	return ErrNotSupported
+}
+
+// Range iterates the wrapped Conn, returning ErrNotSupported if it doesn't implement Ranger
+func (sc *SlowLogConn) Range(fn func(k, v []byte) bool) error {
+	if r, ok := sc.conn.(Ranger); ok {
+		return r.Range(fn)
+	}
+	return ErrNotSupported
+}
+
+// Stats provides stats about the wrapped Conn
+func (sc *SlowLogConn) Stats() (map[string]interface{}, error) {
+	return sc.conn.Stats()
+}
@@ -0,0 +1,55 @@
+package omnicache
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// slowConn delays operations on keys starting with "slow"
+type slowConn struct {
+	*mapConn
+}
+
+func (s slowConn) delay(k []byte) {
+	if bytes.HasPrefix(k, []byte("slow")) {
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func (s slowConn) Write(k, v []byte) error {
+	s.delay(k)
+	return s.mapConn.Write(k, v)
+}
+
+func (s slowConn) Read(k []byte) ([]byte, error) {
+	s.delay(k)
+	return s.mapConn.Read(k)
+}
+
+func TestSlowLogConn(t *testing.T) {
+	var out bytes.Buffer
+	sc := NewSlowLogConn(slowConn{newMapConn()}, log.New(&out, "", 0), 2*time.Millisecond, 1)
+	oc := New(sc)
+	defer oc.Close()
+
+	oc.Set([]byte("fast"), []byte{1})
+	oc.Get([]byte("fast"))
+	oc.Set([]byte("slow1"), []byte{1})
+	oc.Get([]byte("slow1"))
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Len(t, lines, 2)
+	assert.Contains(t, lines[0], `slow Write "slow1" took`)
+	assert.Contains(t, lines[1], `slow Read "slow1" took`)
+
+	// sampled out
+	out.Reset()
+	sc.sampleRate = 0
+	oc.Get([]byte("slow1"))
+	assert.Equal(t, "", out.String())
+}
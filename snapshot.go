@@ -0,0 +1,193 @@
+package omnicache
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io"
+	"time"
+)
+
+// TTLMode selects how snapshots record entry expiry
+type TTLMode int
+
+const (
+	// RelativeTTL records the TTL remaining at snapshot time, restored as now + remaining
+	// This is safe to ship between hosts with clock skew
+	RelativeTTL TTLMode = iota
+	// AbsoluteTTL records the expiry timestamp, restored verbatim
+	// This preserves exact expiry across restarts on the same host
+	AbsoluteTTL
+)
+
+const snapshotVersion = 1
+
+// snapshotHeader starts every snapshot, declaring how its records' expiry is encoded
+type snapshotHeader struct {
+	Version int
+	Mode    TTLMode
+}
+
+// snapshotRecord is a single snapshotted entry
+// Expires is false for entries without a recorded expiry
+type snapshotRecord struct {
+	Key         []byte
+	Value       []byte
+	ContentType string
+	Expires     bool
+	ExpiresAt   time.Time
+	Remaining   time.Duration
+}
+
+// Snapshot writes every live entry to w as a gob stream that Restore can load
+// When the Conn implements EntryRanger (e.g. a MetaConn) entry metadata and expiry are recorded,
+// according to the WithSnapshotTTLMode mode and ephemeral entries are omitted; otherwise the Conn must implement Ranger and
+// entries are recorded with the expiry reported by TTLReader, if the Conn implements it, or without expiry
+func (oc *OmniCache) Snapshot(w io.Writer) error {
+	enc := gob.NewEncoder(w)
+	if err := enc.Encode(snapshotHeader{Version: snapshotVersion, Mode: oc.snapshotMode}); err != nil {
+		return err
+	}
+
+	var encErr error
+	write := func(rec snapshotRecord) bool {
+		encErr = enc.Encode(rec)
+		return encErr == nil
+	}
+
+	var err error
+	now := oc.now()
+	switch r := oc.Conn.(type) {
+	case EntryRanger:
+		err = r.RangeEntries(func(k []byte, e Entry) bool {
+			if e.Ephemeral {
+				return true
+			}
+			rec := snapshotRecord{Key: k, Value: e.Value, ContentType: e.ContentType}
+			if !e.ExpiresAt.IsZero() {
+				rec.Remaining = e.ExpiresAt.Sub(now)
+				if rec.Remaining <= 0 {
+					return true
+				}
+				rec.Expires = true
+				if oc.snapshotMode == AbsoluteTTL {
+					rec.ExpiresAt = e.ExpiresAt
+					rec.Remaining = 0
+				}
+			}
+			return write(rec)
+		})
+	case Ranger:
+		tr, _ := oc.Conn.(TTLReader)
+		err = r.Range(func(k, v []byte) bool {
+			rec := snapshotRecord{Key: k, Value: v}
+			if tr != nil {
+				ttl, ok := tr.TTL(k)
+				if !ok {
+					return true
+				}
+				if ttl != NoExpiry {
+					rec.Expires = true
+					rec.Remaining = ttl
+					if oc.snapshotMode == AbsoluteTTL {
+						rec.ExpiresAt = now.Add(ttl)
+						rec.Remaining = 0
+					}
+				}
+			}
+			return write(rec)
+		})
+	default:
+		return ErrNotSupported
+	}
+
+	if err != nil {
+		return err
+	}
+	return encErr
+}
+
+// RestoreOption configures optional Restore behavior
+type RestoreOption func(*restoreConfig)
+
+type restoreConfig struct {
+	replace bool
+}
+
+// RestoreReplace makes Restore flush the cache before loading, so it holds only the snapshot's entries
+// The Conn must implement Flusher
+func RestoreReplace() RestoreOption {
+	return func(c *restoreConfig) {
+		c.replace = true
+	}
+}
+
+// Restore loads a snapshot written by Snapshot, honoring the TTL mode the snapshot declares
+// Entries whose expiry has passed are skipped, and entries without a recorded expiry are
+// written with the Conn's default TTL. Entries are merged into the cache, replacing those with the
+// same key, unless RestoreReplace is given
+func (oc *OmniCache) Restore(r io.Reader, opts ...RestoreOption) error {
+	var cfg restoreConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	dec := gob.NewDecoder(r)
+	var h snapshotHeader
+	if err := dec.Decode(&h); err != nil {
+		return err
+	}
+	if h.Version != snapshotVersion {
+		return fmt.Errorf("omnicache: unsupported snapshot version %d", h.Version)
+	}
+	if cfg.replace {
+		if err := oc.Flush(); err != nil {
+			return err
+		}
+	}
+
+	ec, _ := oc.Conn.(EntryConn)
+	for {
+		var rec snapshotRecord
+		err := dec.Decode(&rec)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := oc.restoreRecord(ec, h.Mode, rec); err != nil {
+			return err
+		}
+	}
+}
+
+// restoreRecord writes a snapshotted entry under its key's lock, skipping it if its expiry has passed
+func (oc *OmniCache) restoreRecord(ec EntryConn, mode TTLMode, rec snapshotRecord) error {
+	if err := oc.checkWrite(rec.Key, rec.Value); err != nil {
+		return err
+	}
+	e := Entry{Value: rec.Value, ContentType: rec.ContentType}
+	if !rec.Expires {
+		defer oc.locks.lock(rec.Key).Unlock()
+		oc.written(rec.Key)
+		if ec != nil {
+			return ec.WriteEntry(rec.Key, e)
+		}
+		return oc.Conn.Write(rec.Key, e.Value)
+	}
+
+	ttl := rec.Remaining
+	if mode == AbsoluteTTL {
+		ttl = rec.ExpiresAt.Sub(oc.now())
+	}
+	if ttl <= 0 {
+		return nil
+	}
+	defer oc.locks.lock(rec.Key).Unlock()
+	oc.written(rec.Key)
+	if ec != nil {
+		return ec.WriteEntryTTL(rec.Key, e, ttl)
+	}
+	return oc.Conn.WriteTTL(rec.Key, e.Value, ttl)
+}
@@ -0,0 +1,200 @@
+package omnicache
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	src := New(NewMetaConn(newMapConn()))
+	defer src.Close()
+
+	src.SetWithTTL([]byte("ttl"), []byte{1}, time.Minute)
+	src.SetWithTTL([]byte("forever"), []byte{2}, 0)
+	src.SetWithType([]byte("typed"), []byte("{}"), "application/json", time.Minute)
+	src.SetWithTTL([]byte("expiring"), []byte{3}, 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+
+	var buf bytes.Buffer
+	err := src.Snapshot(&buf)
+	assert.Nil(t, err)
+
+	dst := New(NewMetaConn(newMapConn()))
+	defer dst.Close()
+	err = dst.Restore(&buf)
+	assert.Nil(t, err)
+
+	b, err := dst.Get([]byte("ttl"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{1}, b)
+	b, err = dst.Get([]byte("forever"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{2}, b)
+	b, ct, err := dst.GetWithType([]byte("typed"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("{}"), b)
+	assert.Equal(t, "application/json", ct)
+	_, err = dst.Get([]byte("expiring"))
+	assert.Errorf(t, err, "Key not found")
+
+	// remaining lifetime carried over
+	e, err := dst.Conn.(EntryConn).ReadEntry([]byte("ttl"))
+	assert.Nil(t, err)
+	assert.True(t, time.Until(e.ExpiresAt) > 59*time.Second)
+}
+
+func TestSnapshotTTLReader(t *testing.T) {
+	m, clock := newFakeMapConn()
+	src := New(m)
+	src.SetWithTTL([]byte("ttl"), []byte{1}, time.Minute)
+	src.SetWithTTL([]byte("forever"), []byte{2}, 0)
+	src.SetWithTTL([]byte("expiring"), []byte{3}, 10*time.Millisecond)
+	clock.Advance(20 * time.Millisecond)
+
+	var buf bytes.Buffer
+	assert.Nil(t, src.Snapshot(&buf))
+
+	// remaining TTLs come from the plain Conn's TTLReader
+	dm, _ := newFakeMapConn()
+	dst := New(dm)
+	assert.Nil(t, dst.Restore(&buf))
+	ttl, err := dst.TTL([]byte("ttl"))
+	assert.Nil(t, err)
+	assert.Equal(t, time.Minute-20*time.Millisecond, ttl)
+	ttl, err = dst.TTL([]byte("forever"))
+	assert.Nil(t, err)
+	assert.Equal(t, NoExpiry, ttl)
+	_, err = dst.Get([]byte("expiring"))
+	assert.Errorf(t, err, "Key not found")
+}
+
+func TestRestoreMergeReplace(t *testing.T) {
+	src := New(newMapConn())
+	src.Set([]byte("a"), []byte("snap"))
+	var buf bytes.Buffer
+	assert.Nil(t, src.Snapshot(&buf))
+	snap := buf.Bytes()
+
+	// merged by default, the snapshot winning on shared keys
+	dst := New(newMapConn())
+	dst.Set([]byte("a"), []byte("old"))
+	dst.Set([]byte("b"), []byte("old"))
+	assert.Nil(t, dst.Restore(bytes.NewReader(snap)))
+	b, _ := dst.Get([]byte("a"))
+	assert.Equal(t, []byte("snap"), b)
+	_, err := dst.Get([]byte("b"))
+	assert.Nil(t, err)
+
+	// replaced when asked
+	assert.Nil(t, dst.Restore(bytes.NewReader(snap), RestoreReplace()))
+	keys, _ := dst.Keys()
+	assert.Equal(t, []string{"a"}, keys)
+	s, _ := dst.Stats()
+	assert.Equal(t, uint64(1), s["KeyCount"])
+}
+
+// snapshotSkewed snapshots a one minute entry in mode and restores it on a host whose clock is an hour ahead
+func snapshotSkewed(t *testing.T, mode TTLMode) *OmniCache {
+	src := New(NewMetaConn(newMapConn()), WithSnapshotTTLMode(mode))
+	src.SetWithTTL([]byte("k"), []byte{1}, time.Minute)
+
+	var buf bytes.Buffer
+	err := src.Snapshot(&buf)
+	assert.Nil(t, err)
+
+	dst := New(NewMetaConn(newMapConn()))
+	dst.clock = func() time.Time { return time.Now().Add(time.Hour) }
+	err = dst.Restore(&buf)
+	assert.Nil(t, err)
+	return dst
+}
+
+func TestRestoreAbsoluteTTL(t *testing.T) {
+	// the skewed host sees the absolute expiry as long passed
+	oc := snapshotSkewed(t, AbsoluteTTL)
+	_, err := oc.Get([]byte("k"))
+	assert.Errorf(t, err, "Key not found")
+
+	// same-host restores keep the exact expiry
+	src := New(NewMetaConn(newMapConn()), WithSnapshotTTLMode(AbsoluteTTL))
+	src.SetWithTTL([]byte("k"), []byte{1}, time.Minute)
+	want, _ := src.Conn.(EntryConn).ReadEntry([]byte("k"))
+	var buf bytes.Buffer
+	assert.Nil(t, src.Snapshot(&buf))
+
+	dst := New(NewMetaConn(newMapConn()))
+	assert.Nil(t, dst.Restore(&buf))
+	got, err := dst.Conn.(EntryConn).ReadEntry([]byte("k"))
+	assert.Nil(t, err)
+	assert.True(t, got.ExpiresAt.Sub(want.ExpiresAt) < 10*time.Millisecond)
+}
+
+func TestRestoreRelativeTTL(t *testing.T) {
+	// relative restores preserve the lifetime despite the skew
+	oc := snapshotSkewed(t, RelativeTTL)
+	b, err := oc.Get([]byte("k"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{1}, b)
+}
+
+func TestSnapshotNotSupported(t *testing.T) {
+	oc := New(createConn())
+	defer oc.Close()
+
+	var buf bytes.Buffer
+	assert.Equal(t, ErrNotSupported, oc.Snapshot(&buf))
+}
+
+func TestSetEphemeral(t *testing.T) {
+	near, far := newMapConn(), newMapConn()
+	src := New(NewMetaConn(NewTieredConn(near, far)))
+	defer src.Close()
+
+	src.SetWithTTL([]byte("normal"), []byte{1}, time.Minute)
+	src.SetWithTTL([]byte("scratch"), []byte{0}, time.Minute)
+	err := src.SetEphemeral([]byte("scratch"), []byte{2}, time.Minute)
+	assert.Nil(t, err)
+
+	// readable in process
+	b, err := src.Get([]byte("scratch"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{2}, b)
+
+	// kept out of the far tier, including the value it replaced
+	_, err = far.Read([]byte("normal"))
+	assert.Nil(t, err)
+	_, err = far.Read([]byte("scratch"))
+	assert.Errorf(t, err, "Key not found")
+
+	// omitted from snapshots of a single tier too
+	single := New(NewMetaConn(near))
+	var buf bytes.Buffer
+	err = single.Snapshot(&buf)
+	assert.Nil(t, err)
+	dst := New(NewMetaConn(newMapConn()))
+	defer dst.Close()
+	err = dst.Restore(&buf)
+	assert.Nil(t, err)
+	_, err = dst.Get([]byte("normal"))
+	assert.Nil(t, err)
+	_, err = dst.Get([]byte("scratch"))
+	assert.Errorf(t, err, "Key not found")
+
+	err = New(newMapConn()).SetEphemeral([]byte("scratch"), []byte{2}, time.Minute)
+	assert.Equal(t, ErrNotSupported, err)
+}
+
+func TestSetEphemeralObserved(t *testing.T) {
+	m := newMapConn()
+	oc := New(NewMetaConn(m), WithPeakTracking())
+	defer oc.Close()
+
+	assert.Nil(t, oc.SetEphemeral([]byte("scratch"), []byte{2}, time.Minute))
+	m.Flush()
+	s, err := oc.Stats()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), s["PeakKeyCount"])
+}
@@ -0,0 +1,98 @@
+package omnicache
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// FetchTol gets data from the cache for the specified key, tolerating a value that went stale
+// less than tolerance ago. Such a value is returned immediately while a single background
+// BackfillCache.CacheMiss per key refreshes it; a value stale for longer blocks on the backfill
+// like a miss. Values are stored with ttl and kept for tolerance past it, up to any WithMaxTTL
+// ceiling, so a later FetchTol with a larger tolerance can't see further back than that. Concurrent blocking backfills for the same key are
+// shared as with Fetch. The Conn must implement EntryConn (e.g. a MetaConn)
+func (oc *OmniCache) FetchTol(k []byte, b BackfillCache, ttl, tolerance time.Duration) ([]byte, error) {
+	ec, ok := oc.Conn.(EntryConn)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	ttl, err := oc.checkTTL(ttl)
+	if err != nil {
+		return nil, err
+	}
+	// the hard TTL is held to the same guardrails, without jittering the already jittered ttl again
+	hard := time.Duration(0)
+	if ttl > 0 {
+		if hard, err = oc.boundTTL(ttl + tolerance); err != nil {
+			return nil, err
+		}
+	}
+	if err := oc.checkKey(k); err != nil {
+		return nil, err
+	}
+
+	store := func(k, v []byte) error {
+		defer oc.observeWrite()
+		if err := oc.checkWrite(k, v); err != nil {
+			return err
+		}
+		e := Entry{Value: copyBytes(v)}
+		if ttl > 0 {
+			e.SoftExpiresAt = oc.now().Add(ttl)
+		}
+		defer oc.locks.lock(k).Unlock()
+		oc.written(k)
+		return ec.WriteEntryTTL(k, e, hard)
+	}
+
+	e, err := ec.ReadEntry(k)
+	if err == nil {
+		now := oc.now()
+		if e.SoftExpiresAt.IsZero() || now.Before(e.SoftExpiresAt) {
+			atomic.AddUint64(&oc.reads.hits, 1)
+			return copyBytes(e.Value), nil
+		}
+		if now.Sub(e.SoftExpiresAt) <= tolerance {
+			atomic.AddUint64(&oc.reads.hits, 1)
+			oc.refresh(k, b, store)
+			return copyBytes(e.Value), nil
+		}
+	}
+	atomic.AddUint64(&oc.reads.misses, 1)
+
+	ret, err, _ := oc.flights.do(context.Background(), oc.sharedKey(k), func() ([]byte, error) {
+		ret, err := oc.backfill(k, b)
+		if err == ErrDontCache || (err == nil && len(ret) == 0 && !oc.cacheEmpty) {
+			return ret, nil
+		}
+		if err != nil {
+			return ret, err
+		}
+		return ret, store(k, ret)
+	})
+	return ret, err
+}
+
+// FetchStale is FetchTol under its stale-while-revalidate name: values are served for staleFor past
+// their soft expiry at ttl while a single background backfill per key refreshes them
+func (oc *OmniCache) FetchStale(k []byte, b BackfillCache, ttl, staleFor time.Duration) ([]byte, error) {
+	return oc.FetchTol(k, b, ttl, staleFor)
+}
+
+// refresh backfills k in the background with store, running at most once per key at a time
+// The goroutine works on a copy of k, which the caller may reuse once refresh returns
+func (oc *OmniCache) refresh(k []byte, b BackfillCache, store func(k, v []byte) error) {
+	key := oc.sharedKey(k)
+	if !oc.refreshing.start(key) {
+		return
+	}
+	k = copyBytes(k)
+	go func() {
+		defer oc.refreshing.done(key)
+		// as in Fetch, ErrDontCache and disallowed empty values keep the stale value
+		if ret, err := oc.backfill(k, b); err == nil && (len(ret) > 0 || oc.cacheEmpty) {
+			store(k, ret)
+		}
+	}()
+}
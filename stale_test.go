@@ -0,0 +1,210 @@
+package omnicache
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// counter backfills an incrementing value per call
+type counter struct {
+	calls *int32
+}
+
+func (c counter) CacheMiss(key string) ([]byte, error) {
+	return []byte{byte(atomic.AddInt32(c.calls, 1))}, nil
+}
+
+func TestFetchTolWithinTolerance(t *testing.T) {
+	oc := New(NewMetaConn(newMapConn()))
+	defer oc.Close()
+
+	var calls int32
+	b := counter{calls: &calls}
+	key := []byte("k")
+
+	v, err := oc.FetchTol(key, b, 20*time.Millisecond, time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{1}, v)
+
+	// stale but within tolerance: served immediately, refreshed in the background
+	time.Sleep(30 * time.Millisecond)
+	v, err = oc.FetchTol(key, b, 20*time.Millisecond, time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{1}, v)
+
+	time.Sleep(10 * time.Millisecond)
+	v, err = oc.FetchTol(key, b, 20*time.Millisecond, time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{2}, v)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestFetchTolBeyondTolerance(t *testing.T) {
+	oc := New(NewMetaConn(newMapConn()))
+	defer oc.Close()
+
+	var calls int32
+	b := counter{calls: &calls}
+	key := []byte("k")
+
+	oc.FetchTol(key, b, 20*time.Millisecond, time.Minute)
+	time.Sleep(30 * time.Millisecond)
+
+	// a stricter caller blocks on the backfill instead
+	v, err := oc.FetchTol(key, b, 20*time.Millisecond, 5*time.Millisecond)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{2}, v)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestFetchTolShared(t *testing.T) {
+	oc := New(NewMetaConn(newMapConn()))
+	defer oc.Close()
+
+	// concurrent misses share a single backfill
+	var calls int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			oc.FetchTol([]byte("k"), countingBackfill{calls: &calls}, time.Minute, time.Minute)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	// hits and misses are counted, and hits return a copy
+	oc.ResetStats()
+	oc.FetchTol([]byte("j"), countingBackfill{calls: &calls}, time.Minute, time.Minute)
+	v, err := oc.FetchTol([]byte("k"), countingBackfill{calls: &calls}, time.Minute, time.Minute)
+	assert.Nil(t, err)
+	v[0] = 'x'
+	v, _ = oc.FetchTol([]byte("k"), countingBackfill{calls: &calls}, time.Minute, time.Minute)
+	assert.Equal(t, []byte("k"), v)
+	s, err := oc.Stats()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), s["Hits"])
+	assert.Equal(t, uint64(1), s["Misses"])
+
+	_, err = oc.FetchTol(nil, countingBackfill{calls: &calls}, time.Minute, time.Minute)
+	assert.Equal(t, ErrEmptyKey, err)
+}
+
+func TestFetchTolNotSupported(t *testing.T) {
+	oc := New(createConn())
+	defer oc.Close()
+
+	var calls int32
+	_, err := oc.FetchTol([]byte("k"), counter{calls: &calls}, time.Second, time.Second)
+	assert.Equal(t, ErrNotSupported, err)
+}
+
+// gatedCounter is a counter whose backfills after the first block until release is closed
+type gatedCounter struct {
+	counter
+	release chan struct{}
+}
+
+func (g gatedCounter) CacheMiss(key string) ([]byte, error) {
+	if atomic.LoadInt32(g.calls) > 0 {
+		<-g.release
+	}
+	return g.counter.CacheMiss(key)
+}
+
+func TestFetchStaleSingleRefresh(t *testing.T) {
+	oc := New(NewMetaConn(newMapConn()))
+	defer oc.Close()
+
+	var calls int32
+	b := gatedCounter{counter: counter{calls: &calls}, release: make(chan struct{})}
+	key := []byte("k")
+
+	oc.FetchStale(key, b, 10*time.Millisecond, time.Minute)
+	time.Sleep(20 * time.Millisecond)
+
+	// every stale read is served while a single refresh hangs
+	for i := 0; i < 10; i++ {
+		v, err := oc.FetchStale(key, b, 10*time.Millisecond, time.Minute)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte{1}, v)
+	}
+	close(b.release)
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestFetchStaleKeyReuse(t *testing.T) {
+	oc := New(NewMetaConn(newMapConn()))
+	defer oc.Close()
+
+	var calls int32
+	b := countingBackfill{calls: &calls}
+	oc.FetchStale([]byte("k1"), b, 10*time.Millisecond, time.Minute)
+	time.Sleep(20 * time.Millisecond)
+
+	// the background refresh doesn't see the caller reusing k
+	key := []byte("k1")
+	oc.FetchStale(key, b, 10*time.Millisecond, time.Minute)
+	copy(key, "k2")
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	_, err := oc.Backend().Read([]byte("k2"))
+	assert.NotNil(t, err)
+}
+
+func TestFetchTolStore(t *testing.T) {
+	m, clock := newFakeMapConn()
+	oc := New(NewMetaConn(m), WithMaxTTL(time.Minute, AllowNoExpiry), WithNegativeCache(time.Minute, 10), WithPeakTracking(), WithClock(clock))
+	defer oc.Close()
+
+	misses := 0
+	oc.Fetch([]byte("k"), notFoundBackfill{calls: &misses})
+
+	var calls int32
+	_, err := oc.FetchTol([]byte("k"), counter{calls: &calls}, 30*time.Second, 10*time.Minute)
+	assert.Nil(t, err)
+
+	// the hard TTL is held to WithMaxTTL
+	ttl, ok := m.TTL([]byte("k"))
+	assert.True(t, ok)
+	assert.True(t, ttl <= time.Minute, ttl.String())
+
+	// the store drops the negative and is observed for peaks
+	m.Delete([]byte("k"))
+	oc.Fetch([]byte("k"), notFoundBackfill{calls: &misses})
+	assert.Equal(t, 2, misses)
+	s, err := oc.Stats()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), s["PeakKeyCount"])
+}
+
+func TestFetchTolRefreshEmpty(t *testing.T) {
+	m, clock := newFakeMapConn()
+	oc := New(NewMetaConn(m), WithClock(clock))
+	defer oc.Close()
+
+	var calls int32
+	b := backfillFunc(func(string) ([]byte, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			return []byte("v"), nil
+		}
+		return []byte{}, nil
+	})
+	oc.FetchTol([]byte("k"), b, 30*time.Second, time.Minute)
+	clock.Advance(40 * time.Second)
+
+	// an empty refresh leaves the stale value in place
+	for i := 0; i < 2; i++ {
+		v, err := oc.FetchTol([]byte("k"), b, 30*time.Second, time.Minute)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("v"), v)
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+}
@@ -0,0 +1,79 @@
+package omnicache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"sort"
+)
+
+// entries collects every live key/value pair; the Conn must implement Ranger
+func (oc *OmniCache) entries() (map[string][]byte, error) {
+	r, ok := oc.Conn.(Ranger)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+
+	ret := map[string][]byte{}
+	err := r.Range(func(k, v []byte) bool {
+		ret[string(k)] = v
+		return true
+	})
+	return ret, err
+}
+
+// StateHash returns a stable hash over all live key/value pairs, independent of the order they were written in
+// Two caches holding the same data have the same StateHash. The Conn must implement Ranger
+func (oc *OmniCache) StateHash() (string, error) {
+	entries, err := oc.entries()
+	if err != nil {
+		return "", err
+	}
+
+	keys := make([]string, 0, len(entries))
+	for k := range entries {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	var n [8]byte
+	for _, k := range keys {
+		// length prefixes keep ("ab", "c") distinct from ("a", "bc")
+		binary.BigEndian.PutUint64(n[:], uint64(len(k)))
+		h.Write(n[:])
+		h.Write([]byte(k))
+		binary.BigEndian.PutUint64(n[:], uint64(len(entries[k])))
+		h.Write(n[:])
+		h.Write(entries[k])
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Diff returns the sorted keys whose live values differ between oc and other,
+// including keys present in only one of them. Both Conns must implement Ranger
+func (oc *OmniCache) Diff(other *OmniCache) ([]string, error) {
+	mine, err := oc.entries()
+	if err != nil {
+		return nil, err
+	}
+	theirs, err := other.entries()
+	if err != nil {
+		return nil, err
+	}
+
+	var diff []string
+	for k, v := range mine {
+		if ov, ok := theirs[k]; !ok || !bytes.Equal(v, ov) {
+			diff = append(diff, k)
+		}
+	}
+	for k := range theirs {
+		if _, ok := mine[k]; !ok {
+			diff = append(diff, k)
+		}
+	}
+	sort.Strings(diff)
+	return diff, nil
+}
@@ -0,0 +1,51 @@
+package omnicache
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStateHash(t *testing.T) {
+	a := New(newMapConn())
+	defer a.Close()
+	b := New(newMapConn())
+	defer b.Close()
+
+	// different operation sequences converging on the same state
+	a.Set([]byte("x"), []byte{1})
+	a.Set([]byte("y"), []byte{2})
+	a.Set([]byte("z"), []byte{3})
+
+	b.Set([]byte("z"), []byte{9})
+	b.Set([]byte("y"), []byte{2})
+	b.Set([]byte("x"), []byte{1})
+	b.Set([]byte("z"), []byte{3})
+
+	ha, err := a.StateHash()
+	assert.Nil(t, err)
+	hb, err := b.StateHash()
+	assert.Nil(t, err)
+	assert.Equal(t, ha, hb)
+	diff, err := a.Diff(b)
+	assert.Nil(t, err)
+	assert.Len(t, diff, 0)
+
+	// diverging state
+	b.Set([]byte("y"), []byte{4})
+	b.Set([]byte("w"), []byte{5})
+	hb, err = b.StateHash()
+	assert.Nil(t, err)
+	assert.NotEqual(t, ha, hb)
+	diff, err = a.Diff(b)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"w", "y"}, diff)
+}
+
+func TestStateHashNotSupported(t *testing.T) {
+	oc := New(createConn())
+	defer oc.Close()
+
+	_, err := oc.StateHash()
+	assert.Equal(t, ErrNotSupported, err)
+}
@@ -0,0 +1,56 @@
+package omnicache
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// thresholdWatch tracks KeyCount against thresholds registered with OnThreshold
+type thresholdWatch struct {
+	active int32
+
+	mu         sync.Mutex
+	thresholds []*threshold
+}
+
+type threshold struct {
+	count uint64
+	fn    func(current uint64, rising bool)
+	above bool
+}
+
+// OnThreshold registers fn to be called when KeyCount rises to count or more, and again when it
+// falls back below count less 10% (the hysteresis keeping a count hovering at the threshold from
+// flapping). Each crossing calls fn once, in its own goroutine. KeyCount is checked after writes made
+// through the OmniCache and whenever Stats is called, so shrinking by expiry is noticed on the next of those
+func (oc *OmniCache) OnThreshold(count uint64, fn func(current uint64, rising bool)) {
+	oc.thresholds.mu.Lock()
+	oc.thresholds.thresholds = append(oc.thresholds.thresholds, &threshold{count: count, fn: fn})
+	oc.thresholds.mu.Unlock()
+	atomic.StoreInt32(&oc.thresholds.active, 1)
+}
+
+// observe calls the callbacks of thresholds crossed by the KeyCount in s
+func (tw *thresholdWatch) observe(s map[string]interface{}) {
+	if atomic.LoadInt32(&tw.active) == 0 {
+		return
+	}
+	f, ok := statFloat(s["KeyCount"])
+	if !ok {
+		return
+	}
+	n := uint64(f)
+
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	for _, th := range tw.thresholds {
+		switch {
+		case !th.above && n >= th.count:
+			th.above = true
+			go th.fn(n, true)
+		case th.above && n < th.count-th.count/10:
+			th.above = false
+			go th.fn(n, false)
+		}
+	}
+}
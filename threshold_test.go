@@ -0,0 +1,50 @@
+package omnicache
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOnThreshold(t *testing.T) {
+	c := newMapConn()
+	oc := New(c)
+	defer oc.Close()
+
+	type crossing struct {
+		current uint64
+		rising  bool
+	}
+	var mu sync.Mutex
+	var got []crossing
+	oc.OnThreshold(10, func(current uint64, rising bool) {
+		mu.Lock()
+		got = append(got, crossing{current, rising})
+		mu.Unlock()
+	})
+	crossings := func() []crossing {
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]crossing(nil), got...)
+	}
+
+	// growing well past the threshold fires once
+	for i := 0; i < 15; i++ {
+		oc.Set([]byte(fmt.Sprintf("k%d", i)), []byte{1})
+	}
+	assert.Equal(t, []crossing{{10, true}}, crossings())
+
+	// dipping just below doesn't, falling past the hysteresis does
+	for i := 14; i >= 9; i-- {
+		c.Delete([]byte(fmt.Sprintf("k%d", i)))
+	}
+	oc.Stats()
+	assert.Len(t, crossings(), 1)
+	c.Delete([]byte("k8"))
+	oc.Stats()
+	assert.Equal(t, []crossing{{10, true}, {8, false}}, crossings())
+}
@@ -0,0 +1,193 @@
+package omnicache
+
+import (
+	"bytes"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/panoplymedia/cache"
+)
+
+// TieredConn is a cache.Conn that chains a fast near cache (L1) in front of a slower far cache (L2)
+// Reads check near first and fall through to far on a miss, populating near with near's default TTL.
+// Writes go through to both tiers, except for entries written with SetEphemeral, which only go to near
+type TieredConn struct {
+	near cache.Conn
+	far  cache.Conn
+
+	repairRate float64
+	newer      func(near, far []byte) bool
+	repairs    uint64
+}
+
+// TieredOption configures optional TieredConn behavior in NewTieredConn
+type TieredOption func(*TieredConn)
+
+// WithReadRepair makes a fraction (0 to 1) of near hits also read far, repairing near when far holds a newer value
+// newer reports whether the far value supersedes the near one; when nil any difference is treated as newer
+func WithReadRepair(rate float64, newer func(near, far []byte) bool) TieredOption {
+	return func(tc *TieredConn) {
+		tc.repairRate = rate
+		tc.newer = newer
+	}
+}
+
+// NewTieredConn creates a TieredConn reading through near to far
+func NewTieredConn(near, far cache.Conn, opts ...TieredOption) *TieredConn {
+	tc := &TieredConn{near: near, far: far}
+	for _, opt := range opts {
+		opt(tc)
+	}
+	if tc.newer == nil {
+		tc.newer = func(near, far []byte) bool {
+			return !bytes.Equal(near, far)
+		}
+	}
+	return tc
+}
+
+// Close closes both tiers, returning the first error
+func (tc *TieredConn) Close() error {
+	err := tc.near.Close()
+	if ferr := tc.far.Close(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+// Ping pings both tiers, returning the first error
+func (tc *TieredConn) Ping() error {
+	if err := ping(tc.near); err != nil {
+		return err
+	}
+	return ping(tc.far)
+}
+
+// Write writes to far, then near
+func (tc *TieredConn) Write(k, v []byte) error {
+	if isEphemeral(v) {
+		if err := tc.dropFar(k); err != nil {
+			return err
+		}
+	} else if err := tc.far.Write(k, v); err != nil {
+		return err
+	}
+	return tc.near.Write(k, v)
+}
+
+// WriteTTL writes to far, then near, with an explicit TTL
+func (tc *TieredConn) WriteTTL(k, v []byte, ttl time.Duration) error {
+	if isEphemeral(v) {
+		if err := tc.dropFar(k); err != nil {
+			return err
+		}
+	} else if err := tc.far.WriteTTL(k, v, ttl); err != nil {
+		return err
+	}
+	return tc.near.WriteTTL(k, v, ttl)
+}
+
+// dropFar deletes a value an ephemeral write supersedes from far, so a near miss can't resurrect it
+func (tc *TieredConn) dropFar(k []byte) error {
+	if d, ok := tc.far.(Deleter); ok {
+		return d.Delete(k)
+	}
+	return nil
+}
+
+// Read returns data from near if present, otherwise from far, backfilling near
+func (tc *TieredConn) Read(k []byte) ([]byte, error) {
+	v, err := tc.near.Read(k)
+	if err == nil {
+		if tc.repairRate > 0 && rand.Float64() < tc.repairRate {
+			return tc.repair(k, v), nil
+		}
+		return v, nil
+	}
+
+	v, err = tc.far.Read(k)
+	if err != nil {
+		return v, err
+	}
+	tc.near.Write(k, v)
+	return v, nil
+}
+
+// repair checks far for a newer value than near's v, rewriting near when one is found
+func (tc *TieredConn) repair(k, v []byte) []byte {
+	fv, err := tc.far.Read(k)
+	if err != nil || !tc.newer(v, fv) {
+		return v
+	}
+	if tc.near.Write(k, fv) == nil {
+		atomic.AddUint64(&tc.repairs, 1)
+	}
+	return fv
+}
+
+// Delete removes k from far, then near, returning ErrNotSupported if either tier doesn't implement Deleter
+func (tc *TieredConn) Delete(k []byte) error {
+	fd, ok := tc.far.(Deleter)
+	if !ok {
+		return ErrNotSupported
+	}
+	nd, ok := tc.near.(Deleter)
+	if !ok {
+		return ErrNotSupported
+	}
+	if err := fd.Delete(k); err != nil {
+		return err
+	}
+	return nd.Delete(k)
+}
+
+// Flush flushes far, then near, returning ErrNotSupported if either tier doesn't implement Flusher
+func (tc *TieredConn) Flush() error {
+	ff, ok := tc.far.(Flusher)
+	if !ok {
+		return ErrNotSupported
+	}
+	nf, ok := tc.near.(Flusher)
+	if !ok {
+		return ErrNotSupported
+	}
+	if err := ff.Flush(); err != nil {
+		return err
+	}
+	return nf.Flush()
+}
+
+// Range iterates the far tier, which holds every key written through the TieredConn except
+// ephemeral entries, which live only in near and aren't visited,
+// returning ErrNotSupported if far doesn't implement Ranger
+func (tc *TieredConn) Range(fn func(k, v []byte) bool) error {
+	if r, ok := tc.far.(Ranger); ok {
+		return r.Range(fn)
+	}
+	return ErrNotSupported
+}
+
+// Stats merges the stats of both tiers, prefixing near keys with "Near" and far keys with "Far"
+func (tc *TieredConn) Stats() (map[string]interface{}, error) {
+	ret := map[string]interface{}{"ReadRepairs": atomic.LoadUint64(&tc.repairs)}
+	for prefix, c := range map[string]cache.Conn{"Near": tc.near, "Far": tc.far} {
+		s, err := c.Stats()
+		if err != nil {
+			return ret, err
+		}
+		for k, v := range s {
+			ret[prefix+k] = v
+		}
+	}
+	return ret, nil
+}
+
+// ResetStats zeroes "ReadRepairs" and resets the counters of each tier implementing StatsResetter
+func (tc *TieredConn) ResetStats() error {
+	atomic.StoreUint64(&tc.repairs, 0)
+	if err := resetStats(tc.near); err != nil {
+		return err
+	}
+	return resetStats(tc.far)
+}
@@ -0,0 +1,72 @@
+package omnicache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTieredConn(t *testing.T) {
+	near, far := createConn(), createConn()
+	tc := NewTieredConn(near, far)
+	oc := New(tc)
+	defer oc.Close()
+
+	// writes go through to both tiers
+	err := oc.SetWithTTL([]byte("k"), []byte{1}, time.Minute)
+	assert.Nil(t, err)
+	b, err := near.Read([]byte("k"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{1}, b)
+	b, err = far.Read([]byte("k"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{1}, b)
+
+	// near miss falls through to far and backfills near
+	far.WriteTTL([]byte("far-only"), []byte{2}, time.Minute)
+	b, err = oc.Get([]byte("far-only"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{2}, b)
+	b, err = near.Read([]byte("far-only"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{2}, b)
+
+	// miss in both tiers
+	_, err = oc.Get([]byte("missing"))
+	assert.Errorf(t, err, "Key not found")
+
+	s, err := oc.Stats()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), s["NearKeyCount"])
+	assert.Equal(t, uint64(2), s["FarKeyCount"])
+}
+
+func TestTieredConnReadRepair(t *testing.T) {
+	near, far := createConn(), createConn()
+	tc := NewTieredConn(near, far, WithReadRepair(0.5, nil))
+	defer tc.Close()
+
+	err := tc.WriteTTL([]byte("k"), []byte{1}, time.Minute)
+	assert.Nil(t, err)
+
+	// a peer updates far behind near's back
+	far.WriteTTL([]byte("k"), []byte{2}, time.Minute)
+
+	repaired := false
+	for i := 0; i < 1000 && !repaired; i++ {
+		b, err := tc.Read([]byte("k"))
+		assert.Nil(t, err)
+		repaired = b[0] == 2
+	}
+	assert.True(t, repaired)
+
+	// near itself now holds the repaired value
+	b, err := near.Read([]byte("k"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{2}, b)
+
+	s, err := tc.Stats()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), s["ReadRepairs"])
+}
@@ -0,0 +1,69 @@
+package omnicache
+
+import "time"
+
+// Touch extends the expiry of a live k to ttl from now without rewriting its value through the
+// OmniCache, e.g. for sliding expiry, returning ErrNotFound if k is missing or expired so the
+// caller can backfill. The Conn must implement Toucher, or EntryConn (e.g. a MetaConn)
+func (oc *OmniCache) Touch(k []byte, ttl time.Duration) error {
+	ttl, err := oc.checkTTL(ttl)
+	if err != nil {
+		return err
+	}
+
+	t, ok := oc.Conn.(Toucher)
+	ec, eok := oc.Conn.(EntryConn)
+	if !ok && !eok {
+		return ErrNotSupported
+	}
+	if err := oc.checkKey(k); err != nil {
+		return err
+	}
+
+	defer oc.locks.lock(k).Unlock()
+	if ok {
+		if _, err := oc.Conn.Read(k); err != nil {
+			return ErrNotFound
+		}
+		return t.Touch(k, ttl)
+	}
+	e, err := ec.ReadEntry(k)
+	if err != nil {
+		return ErrNotFound
+	}
+	return ec.WriteEntryTTL(k, e, ttl)
+}
+
+// TouchIfBelow extends the expiry of k to newTTL from now only if its remaining TTL is below floor,
+// reporting whether it did, so sliding expiry doesn't rewrite hot keys on every access. Keys without
+// a recorded expiry aren't extended. The check and extension are atomic with respect to other writes
+// made through this OmniCache. The Conn must implement EntryConn (e.g. a MetaConn)
+func (oc *OmniCache) TouchIfBelow(k []byte, floor, newTTL time.Duration) (bool, error) {
+	ec, ok := oc.Conn.(EntryConn)
+	if !ok {
+		return false, ErrNotSupported
+	}
+	newTTL, err := oc.checkTTL(newTTL)
+	if err != nil {
+		return false, err
+	}
+	if err := oc.checkKey(k); err != nil {
+		return false, err
+	}
+
+	defer oc.locks.lock(k).Unlock()
+	e, err := ec.ReadEntry(k)
+	if err != nil {
+		return false, err
+	}
+	if e.ExpiresAt.IsZero() || e.ExpiresAt.Sub(oc.now()) >= floor {
+		return false, nil
+	}
+
+	if t, ok := oc.Conn.(Toucher); ok {
+		err = t.Touch(k, newTTL)
+	} else {
+		err = ec.WriteEntryTTL(k, e, newTTL)
+	}
+	return err == nil, err
+}
@@ -0,0 +1,68 @@
+package omnicache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/panoplymedia/cache"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTouchIfBelow(t *testing.T) {
+	oc := New(NewMetaConn(newMapConn()))
+	defer oc.Close()
+
+	oc.SetWithTTL([]byte("session"), []byte{1}, 60*time.Millisecond)
+	_, gen, _, _ := oc.GetIfChanged([]byte("session"), 0)
+
+	// plenty of time left
+	touched, err := oc.TouchIfBelow([]byte("session"), 30*time.Millisecond, 60*time.Millisecond)
+	assert.Nil(t, err)
+	assert.False(t, touched)
+
+	// dipped below the floor
+	time.Sleep(40 * time.Millisecond)
+	touched, err = oc.TouchIfBelow([]byte("session"), 30*time.Millisecond, 60*time.Millisecond)
+	assert.Nil(t, err)
+	assert.True(t, touched)
+	touched, err = oc.TouchIfBelow([]byte("session"), 30*time.Millisecond, 60*time.Millisecond)
+	assert.Nil(t, err)
+	assert.False(t, touched)
+
+	// outlives its original expiry, unchanged
+	time.Sleep(40 * time.Millisecond)
+	b, same, changed, err := oc.GetIfChanged([]byte("session"), gen)
+	assert.Nil(t, err)
+	assert.False(t, changed)
+	assert.Nil(t, b)
+	assert.Equal(t, gen, same)
+
+	// no expiry
+	oc.SetWithTTL([]byte("forever"), []byte{1}, 0)
+	touched, err = oc.TouchIfBelow([]byte("forever"), time.Hour, time.Minute)
+	assert.Nil(t, err)
+	assert.False(t, touched)
+}
+
+func TestTouch(t *testing.T) {
+	for _, c := range []cache.Conn{newMapConn(), NewMetaConn(newMapConn())} {
+		oc := New(c)
+
+		oc.SetWithTTL([]byte("page"), []byte("<html>"), 20*time.Millisecond)
+		assert.Nil(t, oc.Touch([]byte("page"), time.Minute))
+		time.Sleep(30 * time.Millisecond)
+		b, err := oc.Get([]byte("page"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("<html>"), b)
+
+		// missing and expired keys
+		assert.Equal(t, ErrNotFound, oc.Touch([]byte("missing"), time.Minute))
+		oc.SetWithTTL([]byte("short"), []byte{1}, time.Millisecond)
+		time.Sleep(5 * time.Millisecond)
+		assert.Equal(t, ErrNotFound, oc.Touch([]byte("short"), time.Minute))
+
+		oc.Close()
+	}
+
+	assert.Equal(t, ErrNotSupported, New(createConn()).Touch([]byte("page"), time.Minute))
+}
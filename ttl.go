@@ -0,0 +1,193 @@
+package omnicache
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// ErrTTLTooSmall is returned when a TTL is below the WithMinTTL floor under RejectTTL
+var ErrTTLTooSmall = errors.New("omnicache: ttl below minimum")
+
+// MinTTLPolicy selects how a TTL below the WithMinTTL floor is handled
+type MinTTLPolicy int
+
+const (
+	// RejectTTL fails the write with ErrTTLTooSmall
+	RejectTTL MinTTLPolicy = iota
+	// ClampTTL raises the TTL up to the floor
+	ClampTTL
+)
+
+// NoExpiryPolicy selects how a zero TTL (no expiry) is handled under WithMaxTTL
+type NoExpiryPolicy int
+
+const (
+	// AllowNoExpiry stores the entry without expiry
+	AllowNoExpiry NoExpiryPolicy = iota
+	// ClampNoExpiry stores the entry with the ceiling as its TTL
+	ClampNoExpiry
+)
+
+// checkTTL applies the configured TTL guardrails and WithTTLJitter jitter to an explicit TTL
+// A zero TTL (no expiry) is allowed unless WithMaxTTL clamps it
+func (oc *OmniCache) checkTTL(ttl time.Duration) (time.Duration, error) {
+	ttl, err := oc.boundTTL(ttl)
+	if err != nil {
+		return ttl, err
+	}
+	return oc.jitter(ttl), nil
+}
+
+// boundTTL is checkTTL without the jitter, for callers jittering each of several writes themselves
+func (oc *OmniCache) boundTTL(ttl time.Duration) (time.Duration, error) {
+	if ttl > 0 && ttl < oc.minTTL {
+		if oc.minTTLPolicy == RejectTTL {
+			return ttl, ErrTTLTooSmall
+		}
+		ttl = oc.minTTL
+	}
+	if oc.maxTTL > 0 && (ttl > oc.maxTTL || ttl == 0 && oc.noExpiryPolicy == ClampNoExpiry) {
+		ttl = oc.maxTTL
+	}
+	return ttl, nil
+}
+
+// jitter moves a positive ttl by a random amount within the WithTTLJitter fraction of it,
+// keeping it within the WithMinTTL and WithMaxTTL bounds
+func (oc *OmniCache) jitter(ttl time.Duration) time.Duration {
+	if ttl <= 0 || oc.ttlJitter <= 0 {
+		return ttl
+	}
+	ttl += time.Duration((rand.Float64()*2 - 1) * oc.ttlJitter * float64(ttl))
+	if ttl < oc.minTTL {
+		ttl = oc.minTTL
+	}
+	if oc.maxTTL > 0 && ttl > oc.maxTTL {
+		ttl = oc.maxTTL
+	}
+	if ttl <= 0 {
+		ttl = 1
+	}
+	return ttl
+}
+
+// NoExpiry is the TTL reported for keys that never expire
+const NoExpiry time.Duration = -1
+
+// TTL returns the time remaining before k expires, NoExpiry if it never does, or ErrNotFound if it's
+// missing or expired. Conns implementing TTLReader are asked directly; with an EntryConn (e.g. a MetaConn)
+// the recorded expiry is used, which is missing, and reported as NoExpiry, for entries written with the
+// Conn's default TTL
+func (oc *OmniCache) TTL(k []byte) (time.Duration, error) {
+	if err := oc.checkKey(k); err != nil {
+		return 0, err
+	}
+	if tr, ok := oc.Conn.(TTLReader); ok {
+		ttl, ok := tr.TTL(k)
+		if !ok {
+			return 0, ErrNotFound
+		}
+		return ttl, nil
+	}
+
+	ec, ok := oc.Conn.(EntryConn)
+	if !ok {
+		return 0, ErrNotSupported
+	}
+	e, err := ec.ReadEntry(k)
+	if err != nil {
+		return 0, ErrNotFound
+	}
+	if e.ExpiresAt.IsZero() {
+		return NoExpiry, nil
+	}
+	ttl := e.ExpiresAt.Sub(oc.now())
+	if ttl <= 0 {
+		return 0, ErrNotFound
+	}
+	return ttl, nil
+}
+
+// GetWithTTL returns a copy of the value of k along with the time remaining before it expires, NoExpiry if it
+// never does, e.g. to set a Cache-Control max-age matching the cached expiry. It returns ErrNotFound if k is
+// missing or expired. Conns implementing ValueTTLReader, or EntryConn (e.g. a MetaConn), read both in a single
+// step; otherwise they're read one after the other like Get and TTL, so the Conn must implement TTLReader and
+// a key expiring in between is reported as ErrNotFound
+func (oc *OmniCache) GetWithTTL(k []byte) ([]byte, time.Duration, error) {
+	if err := oc.checkKey(k); err != nil {
+		return nil, 0, err
+	}
+	if vr, ok := oc.Conn.(ValueTTLReader); ok {
+		v, ttl, ok := vr.ReadWithTTL(k)
+		if !ok {
+			return nil, 0, ErrNotFound
+		}
+		return copyBytes(v), ttl, nil
+	}
+
+	if ec, ok := oc.Conn.(EntryConn); ok {
+		e, err := ec.ReadEntry(k)
+		if err != nil {
+			return nil, 0, ErrNotFound
+		}
+		if e.ExpiresAt.IsZero() {
+			return copyBytes(e.Value), NoExpiry, nil
+		}
+		ttl := e.ExpiresAt.Sub(oc.now())
+		if ttl <= 0 {
+			return nil, 0, ErrNotFound
+		}
+		return copyBytes(e.Value), ttl, nil
+	}
+
+	tr, ok := oc.Conn.(TTLReader)
+	if !ok {
+		return nil, 0, ErrNotSupported
+	}
+	v, err := oc.Conn.Read(k)
+	if err != nil {
+		return nil, 0, notFound(err)
+	}
+	ttl, ok := tr.TTL(k)
+	if !ok {
+		return nil, 0, ErrNotFound
+	}
+	return copyBytes(v), ttl, nil
+}
+
+// SetKeepTTL replaces the value of k with v while keeping its current expiry, so refreshing a value doesn't
+// reset the TTL it was written with. It returns ErrNotFound without writing if k is missing or expired, so
+// the caller can decide the TTL of a new key. It's atomic with respect to other OmniCache writes, and with
+// respect to every write when the Conn implements KeepTTLWriter; otherwise the remaining TTL is read as
+// TTL reads it
+func (oc *OmniCache) SetKeepTTL(k, v []byte) error {
+	if err := oc.checkWrite(k, v); err != nil {
+		return err
+	}
+	v = copyBytes(v)
+
+	defer oc.observeWrite()
+	defer oc.locks.lock(k).Unlock()
+	if kw, ok := oc.Conn.(KeepTTLWriter); ok {
+		written, err := kw.WriteKeepTTL(k, v)
+		if err != nil {
+			return err
+		}
+		if !written {
+			return ErrNotFound
+		}
+		oc.written(k)
+		return nil
+	}
+
+	ttl, err := oc.TTL(k)
+	if err != nil {
+		return err
+	}
+	if ttl == NoExpiry {
+		ttl = 0
+	}
+	oc.written(k)
+	return oc.Conn.WriteTTL(k, v, ttl)
+}
@@ -0,0 +1,247 @@
+package omnicache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/panoplymedia/cache"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMinTTLReject(t *testing.T) {
+	c := createConn()
+	oc := New(c, WithMinTTL(time.Second, RejectTTL))
+	defer oc.Close()
+
+	key := []byte("tiny")
+
+	// sub-minimum ttl is rejected and nothing is stored
+	err := oc.SetWithTTL(key, []byte{1}, time.Millisecond)
+	assert.Equal(t, ErrTTLTooSmall, err)
+	_, err = oc.Get(key)
+	assert.Errorf(t, err, "Key not found")
+
+	_, err = oc.FetchWithTTL(key, doubler{Value: 1}, time.Millisecond)
+	assert.Equal(t, ErrTTLTooSmall, err)
+
+	// no expiry and ttls at the floor are allowed
+	err = oc.SetWithTTL(key, []byte{1}, 0)
+	assert.Nil(t, err)
+	err = oc.SetWithTTL(key, []byte{1}, time.Second)
+	assert.Nil(t, err)
+}
+
+func TestMinTTLClamp(t *testing.T) {
+	c, clock := newFakeMapConn()
+	oc := New(c, WithMinTTL(200*time.Millisecond, ClampTTL))
+	defer oc.Close()
+
+	key := []byte("tiny")
+
+	// sub-minimum ttl is raised to the floor
+	err := oc.SetWithTTL(key, []byte{1}, time.Millisecond)
+	assert.Nil(t, err)
+	clock.Advance(50 * time.Millisecond)
+	b, err := oc.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{1}, b)
+
+	// and still expires at the floor
+	clock.Advance(200 * time.Millisecond)
+	_, err = oc.Get(key)
+	assert.Errorf(t, err, "Key not found")
+}
+
+func TestMaxTTL(t *testing.T) {
+	m, clock := newFakeMapConn()
+	oc := New(m, WithMaxTTL(50*time.Millisecond, AllowNoExpiry))
+	defer oc.Close()
+
+	// ttl above the ceiling is lowered to it
+	err := oc.SetWithTTL([]byte("long"), []byte{1}, 24*time.Hour)
+	assert.Nil(t, err)
+	_, err = oc.FetchWithTTL([]byte("fetched"), doubler{Value: 1}, 24*time.Hour)
+	assert.Nil(t, err)
+	// no expiry is allowed
+	err = oc.SetWithTTL([]byte("forever"), []byte{1}, 0)
+	assert.Nil(t, err)
+
+	clock.Advance(60 * time.Millisecond)
+	_, err = oc.Get([]byte("long"))
+	assert.Errorf(t, err, "Key not found")
+	_, err = oc.Get([]byte("fetched"))
+	assert.Errorf(t, err, "Key not found")
+	_, err = oc.Get([]byte("forever"))
+	assert.Nil(t, err)
+}
+
+func TestMaxTTLClampNoExpiry(t *testing.T) {
+	m, clock := newFakeMapConn()
+	oc := New(m, WithMaxTTL(50*time.Millisecond, ClampNoExpiry))
+	defer oc.Close()
+
+	// no expiry is lowered to the ceiling
+	err := oc.SetWithTTL([]byte("forever"), []byte{1}, 0)
+	assert.Nil(t, err)
+	ttl, err := oc.checkTTL(0)
+	assert.Nil(t, err)
+	assert.Equal(t, 50*time.Millisecond, ttl)
+
+	clock.Advance(60 * time.Millisecond)
+	_, err = oc.Get([]byte("forever"))
+	assert.Errorf(t, err, "Key not found")
+}
+
+func TestTTLJitter(t *testing.T) {
+	oc := New(newMapConn(), WithTTLJitter(0.1))
+	defer oc.Close()
+
+	// jittered within ±10%, and not all the same
+	seen := map[time.Duration]bool{}
+	for i := 0; i < 100; i++ {
+		ttl, err := oc.checkTTL(time.Minute)
+		assert.Nil(t, err)
+		assert.True(t, ttl >= 54*time.Second && ttl <= 66*time.Second)
+		seen[ttl] = true
+	}
+	assert.True(t, len(seen) > 1)
+
+	// no expiry isn't jittered
+	ttl, err := oc.checkTTL(0)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Duration(0), ttl)
+
+	// jitter stays within the guardrails
+	oc = New(newMapConn(), WithTTLJitter(0.5), WithMinTTL(time.Minute, ClampTTL), WithMaxTTL(time.Minute, AllowNoExpiry))
+	for i := 0; i < 20; i++ {
+		ttl, _ = oc.checkTTL(time.Minute)
+		assert.Equal(t, time.Minute, ttl)
+	}
+
+	ttl, _ = New(newMapConn()).checkTTL(time.Minute)
+	assert.Equal(t, time.Minute, ttl)
+}
+
+func TestTTL(t *testing.T) {
+	for _, c := range []cache.Conn{newMapConn(), NewMetaConn(createConn())} {
+		oc := New(c)
+
+		oc.SetWithTTL([]byte("window"), []byte{1}, time.Minute)
+		ttl, err := oc.TTL([]byte("window"))
+		assert.Nil(t, err)
+		assert.InDelta(t, float64(time.Minute), float64(ttl), float64(time.Second))
+
+		oc.SetWithTTL([]byte("forever"), []byte{1}, 0)
+		ttl, err = oc.TTL([]byte("forever"))
+		assert.Nil(t, err)
+		assert.Equal(t, NoExpiry, ttl)
+
+		// missing and expired keys
+		_, err = oc.TTL([]byte("missing"))
+		assert.Equal(t, ErrNotFound, err)
+		oc.SetWithTTL([]byte("short"), []byte{1}, time.Millisecond)
+		time.Sleep(5 * time.Millisecond)
+		_, err = oc.TTL([]byte("short"))
+		assert.Equal(t, ErrNotFound, err)
+
+		oc.Close()
+	}
+
+	_, err := New(createConn()).TTL([]byte("window"))
+	assert.Equal(t, ErrNotSupported, err)
+}
+
+func TestDefaultTTL(t *testing.T) {
+	m, clock := newFakeMapConn()
+	oc := New(m, WithDefaultTTL(time.Minute))
+	defer oc.Close()
+
+	// Set and Fetch use the default, explicit ttls win over it
+	oc.Set([]byte("set"), []byte{1})
+	oc.Fetch([]byte("fetched"), doubler{Value: 1})
+	oc.SetWithTTL([]byte("explicit"), []byte{1}, time.Hour)
+	ttl, err := oc.TTL([]byte("set"))
+	assert.Nil(t, err)
+	assert.Equal(t, time.Minute, ttl)
+
+	clock.Advance(2 * time.Minute)
+	_, err = oc.Get([]byte("set"))
+	assert.Errorf(t, err, "Key not found")
+	_, err = oc.Get([]byte("fetched"))
+	assert.Errorf(t, err, "Key not found")
+	_, err = oc.Get([]byte("explicit"))
+	assert.Nil(t, err)
+
+	// never expires by default
+	oc = New(m)
+	oc.Set([]byte("set"), []byte{1})
+	ttl, err = oc.TTL([]byte("set"))
+	assert.Nil(t, err)
+	assert.Equal(t, NoExpiry, ttl)
+}
+
+func TestGetWithTTL(t *testing.T) {
+	m, clock := newFakeMapConn()
+	for _, c := range []cache.Conn{m, NewMetaConn(createConn())} {
+		oc := New(c)
+
+		oc.SetWithTTL([]byte("window"), []byte{1}, time.Minute)
+		b, ttl, err := oc.GetWithTTL([]byte("window"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte{1}, b)
+		assert.InDelta(t, float64(time.Minute), float64(ttl), float64(time.Second))
+
+		oc.SetWithTTL([]byte("forever"), []byte{2}, 0)
+		b, ttl, err = oc.GetWithTTL([]byte("forever"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte{2}, b)
+		assert.Equal(t, NoExpiry, ttl)
+
+		_, _, err = oc.GetWithTTL([]byte("missing"))
+		assert.Equal(t, ErrNotFound, err)
+		oc.Close()
+	}
+
+	// expired
+	clock.Advance(2 * time.Minute)
+	_, _, err := New(m).GetWithTTL([]byte("window"))
+	assert.Equal(t, ErrNotFound, err)
+
+	_, _, err = New(createConn()).GetWithTTL([]byte("window"))
+	assert.Equal(t, ErrNotSupported, err)
+}
+
+func TestSetKeepTTL(t *testing.T) {
+	m, clock := newFakeMapConn()
+	for _, c := range []cache.Conn{m, NewMetaConn(createConn())} {
+		oc := New(c)
+
+		// missing keys aren't written
+		err := oc.SetKeepTTL([]byte("k"), []byte{1})
+		assert.Equal(t, ErrNotFound, err)
+		_, err = oc.Get([]byte("k"))
+		assert.Equal(t, ErrNotFound, err)
+
+		oc.SetWithTTL([]byte("k"), []byte{1}, time.Minute)
+		err = oc.SetKeepTTL([]byte("k"), []byte{2})
+		assert.Nil(t, err)
+		b, ttl, err := oc.GetWithTTL([]byte("k"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte{2}, b)
+		assert.InDelta(t, float64(time.Minute), float64(ttl), float64(time.Second))
+
+		oc.SetWithTTL([]byte("forever"), []byte{1}, 0)
+		oc.SetKeepTTL([]byte("forever"), []byte{2})
+		_, ttl, _ = oc.GetWithTTL([]byte("forever"))
+		assert.Equal(t, NoExpiry, ttl)
+		oc.Close()
+	}
+
+	// the refreshed value still expires on time
+	oc := New(m)
+	clock.Advance(2 * time.Minute)
+	err := oc.SetKeepTTL([]byte("k"), []byte{3})
+	assert.Equal(t, ErrNotFound, err)
+	_, err = oc.Get([]byte("k"))
+	assert.Equal(t, ErrNotFound, err)
+}
@@ -0,0 +1,31 @@
+//go:build go1.18
+// +build go1.18
+
+package omnicache
+
+// FetchTyped is Fetch for values of any type, encoded with the WithSerializer Serializer (gob by default)
+// On a hit the stored bytes are decoded into a T, on a miss loader is called and its result encoded and
+// stored before being returned
+func FetchTyped[T any](oc *OmniCache, k []byte, loader func(key string) (T, error)) (T, error) {
+	var ret T
+	s := oc.serializerOrDefault()
+
+	// the backfill hands back the loaded value so a miss doesn't decode what it just encoded
+	var loaded *T
+	b, err := oc.Fetch(k, backfillFunc(func(key string) ([]byte, error) {
+		v, err := loader(key)
+		if err != nil {
+			return nil, err
+		}
+		loaded = &v
+		return s.Marshal(v)
+	}))
+	if err != nil {
+		return ret, err
+	}
+	if loaded != nil {
+		return *loaded, nil
+	}
+	err = s.Unmarshal(b, &ret)
+	return ret, err
+}
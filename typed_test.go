@@ -0,0 +1,63 @@
+//go:build go1.18
+// +build go1.18
+
+package omnicache
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFetchTyped(t *testing.T) {
+	oc := New(createConn())
+	defer oc.Close()
+
+	calls := 0
+	loader := func(key string) (doubler, error) {
+		calls++
+		return doubler{Value: 4}, nil
+	}
+
+	// cache miss
+	d, err := FetchTyped(oc, []byte("d"), loader)
+	assert.Nil(t, err)
+	assert.Equal(t, doubler{Value: 4}, d)
+
+	// cache hit
+	d, err = FetchTyped(oc, []byte("d"), loader)
+	assert.Nil(t, err)
+	assert.Equal(t, doubler{Value: 4}, d)
+	assert.Equal(t, 1, calls)
+
+	// stored with gob
+	b, _ := oc.Get([]byte("d"))
+	d, err = decodeDoubler(b)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, d.Value)
+
+	_, err = FetchTyped(oc, []byte("e"), func(key string) (doubler, error) {
+		return doubler{}, errors.New("boom")
+	})
+	assert.EqualError(t, err, "boom")
+}
+
+func TestFetchTypedSerializer(t *testing.T) {
+	oc := New(createConn(), WithSerializer(jsonSerializer{}))
+	defer oc.Close()
+
+	d, err := FetchTyped(oc, []byte("d"), func(key string) (doubler, error) {
+		return doubler{Value: 3}, nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, d.Value)
+
+	b, _ := oc.Get([]byte("d"))
+	assert.Equal(t, []byte(`{"Value":3}`), b)
+	d, err = FetchTyped(oc, []byte("d"), func(key string) (doubler, error) {
+		return doubler{}, errors.New("not called")
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, d.Value)
+}
@@ -0,0 +1,65 @@
+package omnicache
+
+// Usage returns the number of keys and bytes of value data stored, read as a consistent pair
+// Writes made through the OmniCache are held off while the pair is read, so an in-flight write
+// can't skew one against the other. The Conn's KeyCount and BytesStored stats are used when it
+// reports both, otherwise the pair is counted by walking a Conn that implements Ranger
+func (oc *OmniCache) Usage() (keys uint64, bytes uint64, err error) {
+	oc.locks.lockAll()
+	defer oc.locks.unlockAll()
+
+	s, err := oc.Conn.Stats()
+	if err != nil {
+		return 0, 0, err
+	}
+	k, kok := statFloat(s["KeyCount"])
+	b, bok := statFloat(s["BytesStored"])
+	if kok && bok {
+		return uint64(k), uint64(b), nil
+	}
+
+	r, ok := oc.Conn.(Ranger)
+	if !ok {
+		return 0, 0, ErrNotSupported
+	}
+	err = r.Range(func(_, v []byte) bool {
+		keys++
+		bytes += uint64(len(v))
+		return true
+	})
+	return keys, bytes, err
+}
+
+// Len returns the number of live entries. It counts them by walking a Conn that implements Ranger, so
+// entries that expired but haven't been swept yet aren't counted; otherwise it falls back to the Conn's
+// KeyCount stat, which for most backends does include them until they're swept
+func (oc *OmniCache) Len() (int, error) {
+	if r, ok := oc.Conn.(Ranger); ok {
+		n := 0
+		err := r.Range(func(_, _ []byte) bool {
+			n++
+			return true
+		})
+		return n, err
+	}
+
+	s, err := oc.Conn.Stats()
+	if err != nil {
+		return 0, err
+	}
+	n, ok := statFloat(s["KeyCount"])
+	if !ok {
+		return 0, ErrNotSupported
+	}
+	return int(n), nil
+}
+
+// ShardStats returns the number of keys held by each shard of the Conn, e.g. to spot a skewed key distribution
+// It's kept out of Stats so the default stats stay small. The Conn must implement ShardStater
+func (oc *OmniCache) ShardStats() ([]uint64, error) {
+	ss, ok := oc.Conn.(ShardStater)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	return ss.ShardKeyCounts()
+}
@@ -0,0 +1,115 @@
+package omnicache
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// usageConn reports KeyCount and BytesStored from counters updated one after the other
+type usageConn struct {
+	*mapConn
+	keys  uint64
+	bytes uint64
+}
+
+func (u *usageConn) Write(k, v []byte) error {
+	err := u.mapConn.Write(k, v)
+	atomic.AddUint64(&u.keys, 1)
+	runtime.Gosched()
+	atomic.AddUint64(&u.bytes, uint64(len(v)))
+	return err
+}
+
+func (u *usageConn) Stats() (map[string]interface{}, error) {
+	return map[string]interface{}{
+		"KeyCount":    atomic.LoadUint64(&u.keys),
+		"BytesStored": atomic.LoadUint64(&u.bytes),
+	}, nil
+}
+
+func TestUsage(t *testing.T) {
+	oc := New(&usageConn{mapConn: newMapConn()})
+	defer oc.Close()
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				oc.Set([]byte(fmt.Sprintf("%d-%d", w, i)), make([]byte, 8))
+			}
+		}(w)
+	}
+
+	for i := 0; i < 200; i++ {
+		keys, bytes, err := oc.Usage()
+		assert.Nil(t, err)
+		assert.Equal(t, keys*8, bytes)
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func TestUsageRange(t *testing.T) {
+	oc := New(newMapConn())
+	defer oc.Close()
+
+	// counted by walking the Conn
+	oc.Set([]byte("a"), []byte("123"))
+	oc.Set([]byte("b"), []byte("45"))
+	keys, bytes, err := oc.Usage()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), keys)
+	assert.Equal(t, uint64(5), bytes)
+
+	// neither stats nor Range
+	oc = New(createConn())
+	defer oc.Close()
+	_, _, err = oc.Usage()
+	assert.Equal(t, ErrNotSupported, err)
+}
+
+func TestLen(t *testing.T) {
+	m, clock := newFakeMapConn()
+	oc := New(m)
+	defer oc.Close()
+
+	for i := 0; i < 20; i++ {
+		ttl := time.Minute
+		if i%4 == 0 {
+			ttl = time.Millisecond
+		}
+		oc.SetWithTTL([]byte(fmt.Sprint(i)), []byte{1}, ttl)
+	}
+	clock.Advance(time.Second)
+
+	// matches the keys Get can still retrieve
+	live := 0
+	for i := 0; i < 20; i++ {
+		if _, err := oc.Get([]byte(fmt.Sprint(i))); err == nil {
+			live++
+		}
+	}
+	n, err := oc.Len()
+	assert.Nil(t, err)
+	assert.Equal(t, 15, live)
+	assert.Equal(t, live, n)
+
+	// KeyCount fallback
+	n, err = New(createConn()).Len()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+}
@@ -0,0 +1,63 @@
+package omnicache
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var errInvalidJSON = errors.New("invalid JSON")
+
+func jsonValidator(key string, val []byte) error {
+	if !json.Valid(val) {
+		return errInvalidJSON
+	}
+	return nil
+}
+
+func TestWithWriteValidator(t *testing.T) {
+	oc := New(newMapConn(), WithWriteValidator(jsonValidator))
+	defer oc.Close()
+
+	// rejected writes store nothing
+	err := oc.Set([]byte("bad"), []byte("{not json"))
+	assert.Equal(t, errInvalidJSON, err)
+	err = oc.SetWithTTL([]byte("bad"), []byte("nope"), time.Minute)
+	assert.Equal(t, errInvalidJSON, err)
+	_, err = oc.Get([]byte("bad"))
+	assert.Errorf(t, err, "Key not found")
+
+	err = oc.Set([]byte("good"), []byte(`{"a":1}`))
+	assert.Nil(t, err)
+	b, err := oc.Get([]byte("good"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte(`{"a":1}`), b)
+
+	// backfilled values are validated before they're stored
+	var calls int32
+	_, err = oc.Fetch([]byte("3"), slowBackfill{calls: &calls})
+	assert.Nil(t, err)
+	_, err = oc.Fetch([]byte("x"), slowBackfill{calls: &calls})
+	assert.Equal(t, errInvalidJSON, err)
+}
+
+func TestWithMaxValueBytes(t *testing.T) {
+	oc := New(newMapConn(), WithMaxValueBytes(4))
+	defer oc.Close()
+
+	// exactly at the limit is stored
+	assert.Nil(t, oc.Set([]byte("k"), []byte("1234")))
+
+	// one byte over is rejected and nothing is stored
+	err := oc.SetWithTTL([]byte("big"), []byte("12345"), time.Minute)
+	assert.Equal(t, ErrValueTooLarge, err)
+	_, err = oc.Fetch([]byte("big"), backfillFunc(func(key string) ([]byte, error) {
+		return []byte("12345"), nil
+	}))
+	assert.Equal(t, ErrValueTooLarge, err)
+	_, err = oc.Get([]byte("big"))
+	assert.Errorf(t, err, "Key not found")
+}